@@ -72,7 +72,8 @@ var DebugEnabled bool
 var MemoryCacheEnabled bool
 
 var LogConsumeEnabled = true
-var ConversationLogEnabled = false // 对话记录功能开关，默认关闭
+var ConversationLogEnabled = false        // 对话记录功能开关，默认关闭
+var ConversationMaxCaptureBytes = 4 << 20 // 单次对话记录最多捕获的响应字节数，超出部分丢弃
 
 var SMTPServer = ""
 var SMTPPort = 587
@@ -101,6 +101,7 @@ func InitEnv() {
 
 	// Initialize conversation log feature
 	ConversationLogEnabled = GetEnvOrDefaultBool("CONVERSATION_LOG_ENABLED", false)
+	ConversationMaxCaptureBytes = GetEnvOrDefault("CONVERSATION_MAX_CAPTURE_BYTES", 4<<20)
 
 	initConstantEnv()
 }
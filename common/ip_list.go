@@ -0,0 +1,162 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IPList 由 IPv4 / IPv6 地址和 CIDR 组成的列表
+// 解析时转换为排序并合并后的地址区间，查询时二分查找，复杂度为 O(log n)
+type IPList struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+// ipListCacheSize 解析结果缓存的条目上限，超过后清空重建
+const ipListCacheSize = 4096
+
+var (
+	ipListCacheLock sync.RWMutex
+	ipListCache     = make(map[string]*IPList)
+)
+
+// splitIPListEntries 按换行、逗号和空白拆分列表
+func splitIPListEntries(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// lastAddr 返回前缀中的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 1 << (7 - bit%8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+func parseIPListEntry(entry string) (ipRange, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return ipRange{}, err
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+		}
+		return ipRange{from: prefix.Addr(), to: lastAddr(prefix)}, nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return ipRange{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return ipRange{from: addr, to: addr}, nil
+}
+
+// ParseIPList 解析 IP 列表，支持单个地址和 CIDR，条目之间以换行、逗号或空格分隔
+// 无法解析的条目会被跳过，并通过 error 返回，调用方可以据此校验用户输入
+func ParseIPList(text string) (*IPList, error) {
+	list := &IPList{}
+	var invalid []string
+	for _, entry := range splitIPListEntries(text) {
+		r, err := parseIPListEntry(entry)
+		if err != nil {
+			invalid = append(invalid, entry)
+			continue
+		}
+		list.ranges = append(list.ranges, r)
+	}
+	// IPv4 地址总是小于 IPv6 地址，两者可以放在同一个有序数组中
+	sort.Slice(list.ranges, func(i, j int) bool {
+		return list.ranges[i].from.Less(list.ranges[j].from)
+	})
+	merged := list.ranges[:0]
+	for _, r := range list.ranges {
+		if n := len(merged); n > 0 && merged[n-1].from.Is4() == r.from.Is4() &&
+			(r.from.Compare(merged[n-1].to) <= 0 || merged[n-1].to.Next() == r.from) {
+			if r.to.Compare(merged[n-1].to) > 0 {
+				merged[n-1].to = r.to
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	list.ranges = merged
+	if len(invalid) > 0 {
+		return list, fmt.Errorf("无效的 IP 或 CIDR: %s", strings.Join(invalid, ", "))
+	}
+	return list, nil
+}
+
+// GetIPList 返回缓存的解析结果，用于在请求路径上避免重复解析同一个列表
+func GetIPList(text string) *IPList {
+	ipListCacheLock.RLock()
+	list, ok := ipListCache[text]
+	ipListCacheLock.RUnlock()
+	if ok {
+		return list
+	}
+	list, _ = ParseIPList(text)
+	ipListCacheLock.Lock()
+	if len(ipListCache) >= ipListCacheSize {
+		ipListCache = make(map[string]*IPList)
+	}
+	ipListCache[text] = list
+	ipListCacheLock.Unlock()
+	return list
+}
+
+// Empty 列表中没有有效条目
+func (l *IPList) Empty() bool {
+	return l == nil || len(l.ranges) == 0
+}
+
+// Contains 判断 ip 是否在列表中，无法解析的 ip 视为不在列表中
+func (l *IPList) Contains(ip string) bool {
+	if l.Empty() {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	i := sort.Search(len(l.ranges), func(i int) bool {
+		return l.ranges[i].to.Compare(addr) >= 0
+	})
+	return i < len(l.ranges) && l.ranges[i].from.Compare(addr) <= 0
+}
+
+// IPAccess 按允许和禁止列表检查 IP 的结果
+type IPAccess int
+
+const (
+	IPAccessAllowed    IPAccess = iota
+	IPAccessDenied              // 命中禁止列表
+	IPAccessNotAllowed          // 不在允许列表中
+)
+
+// CheckIPAccess 按允许和禁止列表检查 ip，禁止列表优先，允许列表为空时不限制
+func CheckIPAccess(ip string, allow string, deny string) IPAccess {
+	if strings.TrimSpace(deny) != "" && GetIPList(deny).Contains(ip) {
+		return IPAccessDenied
+	}
+	if strings.TrimSpace(allow) != "" {
+		allowList := GetIPList(allow)
+		if !allowList.Empty() && !allowList.Contains(ip) {
+			return IPAccessNotAllowed
+		}
+	}
+	return IPAccessAllowed
+}
@@ -13,9 +13,13 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/token_bucket.lua
+var tokenBucketScript string
+
 type RedisLimiter struct {
-	client         *redis.Client
-	limitScriptSHA string
+	client          *redis.Client
+	limitScriptSHA  string
+	bucketScriptSHA string
 }
 
 var (
@@ -30,9 +34,14 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 		if err != nil {
 			common.SysLog(fmt.Sprintf("Failed to load rate limit script: %v", err))
 		}
+		bucketSHA, err := r.ScriptLoad(ctx, tokenBucketScript).Result()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("Failed to load token bucket script: %v", err))
+		}
 		instance = &RedisLimiter{
-			client:         r,
-			limitScriptSHA: limitSHA,
+			client:          r,
+			limitScriptSHA:  limitSHA,
+			bucketScriptSHA: bucketSHA,
 		}
 	})
 
@@ -68,6 +77,47 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	return result == 1, nil
 }
 
+// Take 按 mode 从令牌桶中扣除令牌，返回是否允许以及扣除后的剩余令牌数（透支时为负数）
+func (rl *RedisLimiter) Take(ctx context.Context, key string, mode TakeMode, opts ...Option) (bool, int64, error) {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	result, err := rl.client.EvalSha(
+		ctx,
+		rl.bucketScriptSHA,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+		int(mode),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("rate limit failed: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("rate limit failed: unexpected result %v", result)
+	}
+	return result[0] == 1, result[1], nil
+}
+
+// TakeMode 令牌桶的扣除模式
+type TakeMode int
+
+const (
+	// TakeModeEnough 令牌足够时才扣除
+	TakeModeEnough TakeMode = iota
+	// TakeModePositive 令牌数大于 0 时扣除，允许透支，用于扣除数量在请求前无法准确预估的场景
+	TakeModePositive
+	// TakeModeForce 总是扣除，用于请求结束后补扣实际用量
+	TakeModeForce
+)
+
 // Config 配置选项模式
 type Config struct {
 	Capacity  int64
@@ -0,0 +1,45 @@
+-- 返回剩余令牌数的令牌桶，用于需要向客户端返回剩余额度的限流
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 请求令牌数
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- ARGV[4]: 扣除模式，0: 令牌足够时扣除；1: 令牌数大于 0 时扣除，允许透支；2: 总是扣除
+-- 返回: {是否允许, 扣除后的剩余令牌数}
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local mode = tonumber(ARGV[4])
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    tokens = capacity
+else
+    local elapsed = nowInSeconds - last_time
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+local allowed = false
+if mode == 2 then
+    allowed = true
+elseif mode == 1 then
+    allowed = tokens > 0
+else
+    allowed = tokens >= requested
+end
+if allowed then
+    tokens = tokens - requested
+end
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', nowInSeconds)
+-- 过期时间覆盖桶重新充满（含透支部分）所需的时间
+redis.call('EXPIRE', key, math.ceil((capacity - tokens) / rate) + 60)
+
+return {allowed and 1 or 0, tokens}
@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryBucket struct {
+	tokens   int64
+	lastTime int64
+	expireAt int64
+}
+
+// MemoryLimiter 未启用 Redis 时使用的令牌桶，语义与 lua/token_bucket.lua 一致
+type MemoryLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*memoryBucket
+	cleanOnce sync.Once
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+// Take 按 mode 从令牌桶中扣除令牌，返回是否允许以及扣除后的剩余令牌数（透支时为负数）
+func (ml *MemoryLimiter) Take(key string, mode TakeMode, opts ...Option) (bool, int64) {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	ml.cleanOnce.Do(func() {
+		go ml.clearExpiredBuckets()
+	})
+
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	now := time.Now().Unix()
+	bucket, ok := ml.buckets[key]
+	if !ok || now >= bucket.expireAt {
+		bucket = &memoryBucket{tokens: config.Capacity}
+		ml.buckets[key] = bucket
+	} else {
+		bucket.tokens = min(config.Capacity, bucket.tokens+(now-bucket.lastTime)*config.Rate)
+	}
+	bucket.lastTime = now
+
+	var allowed bool
+	switch mode {
+	case TakeModeForce:
+		allowed = true
+	case TakeModePositive:
+		allowed = bucket.tokens > 0
+	default:
+		allowed = bucket.tokens >= config.Requested
+	}
+	if allowed {
+		bucket.tokens -= config.Requested
+	}
+	bucket.expireAt = now + (config.Capacity-bucket.tokens+config.Rate-1)/config.Rate + 60
+	return allowed, bucket.tokens
+}
+
+func (ml *MemoryLimiter) clearExpiredBuckets() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now().Unix()
+		ml.mutex.Lock()
+		for key, bucket := range ml.buckets {
+			if now >= bucket.expireAt {
+				delete(ml.buckets, key)
+			}
+		}
+		ml.mutex.Unlock()
+	}
+}
@@ -235,3 +235,24 @@ func MaskSensitiveInfo(str string) string {
 
 	return str
 }
+
+// WildcardMatch 判断 s 是否匹配 pattern，pattern 中的 * 匹配任意长度的字符（包括 /）
+func WildcardMatch(pattern string, s string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == s
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
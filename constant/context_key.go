@@ -17,6 +17,15 @@ const (
 	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenConversationLog   ContextKey = "token_conversation_log_mode"
+	// 令牌的周期预算，[]dto.SpendingBudget
+	ContextKeyTokenBudgets ContextKey = "token_budgets"
+	// 令牌的速率限制，0 为不限制
+	ContextKeyTokenRPM            ContextKey = "token_rpm"
+	ContextKeyTokenTPM            ContextKey = "token_tpm"
+	ContextKeyTokenMaxConcurrency ContextKey = "token_max_concurrency"
+	// 令牌的权限范围，*dto.TokenScope，未配置时不设置
+	ContextKeyTokenScope ContextKey = "token_scope"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -35,6 +44,14 @@ const (
 	ContextKeyChannelIsMultiKey        ContextKey = "channel_is_multi_key"
 	ContextKeyChannelMultiKeyIndex     ContextKey = "channel_multi_key_index"
 	ContextKeyChannelKey               ContextKey = "channel_key"
+	// 本次请求依次尝试过的渠道，[]*types.ChannelAttempt
+	ContextKeyChannelAttempts ContextKey = "channel_attempts"
+	// 触发模型备用链时用户原始请求的模型
+	ContextKeyModelFallbackFrom ContextKey = "model_fallback_from"
+	// 渠道亲和的会话 key，请求成功后据此保存绑定的 (渠道, key)
+	ContextKeyChannelAffinityKey ContextKey = "channel_affinity_key"
+	// 命中的渠道亲和绑定，*model.ChannelAffinity，选择 key 时使用后清除
+	ContextKeyChannelAffinity ContextKey = "channel_affinity"
 
 	/* user related keys */
 	ContextKeyUserId      ContextKey = "id"
@@ -45,6 +62,19 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+	// 用户的周期预算，[]dto.SpendingBudget
+	ContextKeyUserBudgets ContextKey = "user_budgets"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	// 本次请求是否记录对话，按策略计算一次后缓存，保证捕获与落库的判断一致
+	ContextKeyConversationLogDecision ContextKey = "conversation_log_decision"
+
+	// 请求开始时按预估 token 数预扣过的 TPM 桶，以及预扣的 token 数，请求结束后按实际用量补扣差额
+	ContextKeyTPMCharges ContextKey = "tpm_charges"
+	ContextKeyTPMCharged ContextKey = "tpm_charged"
+	// 请求开始时扣除过的令牌 RPM 桶，准入阶段被拒绝时退还
+	ContextKeyRPMCharges ContextKey = "rpm_charges"
+	// 本次尝试计入渠道 TPM 和多 key 本地 TPM 预算的 token 数，请求结束后按实际用量补记差额
+	ContextKeyChannelLimitCharged ContextKey = "channel_limit_charged"
 )
@@ -3,6 +3,7 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom   MultiKeyMode = "random"   // 随机
+	MultiKeyModePolling  MultiKeyMode = "polling"  // 轮询
+	MultiKeyModeHeadroom MultiKeyMode = "headroom" // 按剩余限额，优先选择余量最多的 key，被限流的 key 单独冷却
 )
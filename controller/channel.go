@@ -139,6 +139,7 @@ func GetAllChannels(c *gin.Context) {
 	for _, datum := range channelData {
 		clearChannelInfo(datum)
 	}
+	model.FillChannelUtilization(channelData)
 
 	countQuery := model.DB.Model(&model.Channel{})
 	if statusFilter == common.ChannelStatusEnabled {
@@ -351,6 +352,7 @@ func SearchChannels(c *gin.Context) {
 	for _, datum := range pagedData {
 		clearChannelInfo(datum)
 	}
+	model.FillChannelUtilization(pagedData)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -481,6 +483,8 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 type AddChannelRequest struct {
 	Mode                      string                `json:"mode"`
 	MultiKeyMode              constant.MultiKeyMode `json:"multi_key_mode"`
+	MultiKeyRPM               int                   `json:"multi_key_rpm"`
+	MultiKeyTPM               int                   `json:"multi_key_tpm"`
 	BatchAddSetKeyPrefix2Name bool                  `json:"batch_add_set_key_prefix_2_name"`
 	Channel                   *model.Channel        `json:"channel"`
 }
@@ -540,6 +544,8 @@ func AddChannel(c *gin.Context) {
 	case "multi_to_single":
 		addChannelRequest.Channel.ChannelInfo.IsMultiKey = true
 		addChannelRequest.Channel.ChannelInfo.MultiKeyMode = addChannelRequest.MultiKeyMode
+		addChannelRequest.Channel.ChannelInfo.MultiKeyRPM = max(addChannelRequest.MultiKeyRPM, 0)
+		addChannelRequest.Channel.ChannelInfo.MultiKeyTPM = max(addChannelRequest.MultiKeyTPM, 0)
 		if addChannelRequest.Channel.Type == constant.ChannelTypeVertexAi && addChannelRequest.Channel.GetOtherSettings().VertexKeyType != dto.VertexKeyTypeAPIKey {
 			array, err := getVertexArrayKeys(addChannelRequest.Channel.Key)
 			if err != nil {
@@ -766,7 +772,9 @@ func DeleteChannelBatch(c *gin.Context) {
 type PatchChannel struct {
 	model.Channel
 	MultiKeyMode *string `json:"multi_key_mode"`
-	KeyMode      *string `json:"key_mode"` // 多key模式下密钥覆盖或者追加
+	MultiKeyRPM  *int    `json:"multi_key_rpm"` // 余量模式下每个key的RPM预算
+	MultiKeyTPM  *int    `json:"multi_key_tpm"` // 余量模式下每个key的TPM预算
+	KeyMode      *string `json:"key_mode"`      // 多key模式下密钥覆盖或者追加
 }
 
 func UpdateChannel(c *gin.Context) {
@@ -802,6 +810,12 @@ func UpdateChannel(c *gin.Context) {
 	if channel.MultiKeyMode != nil && *channel.MultiKeyMode != "" {
 		channel.ChannelInfo.MultiKeyMode = constant.MultiKeyMode(*channel.MultiKeyMode)
 	}
+	if channel.MultiKeyRPM != nil {
+		channel.ChannelInfo.MultiKeyRPM = max(*channel.MultiKeyRPM, 0)
+	}
+	if channel.MultiKeyTPM != nil {
+		channel.ChannelInfo.MultiKeyTPM = max(*channel.MultiKeyTPM, 0)
+	}
 
 	// 处理多key模式下的密钥追加/覆盖逻辑
 	if channel.KeyMode != nil && channel.ChannelInfo.IsMultiKey {
@@ -863,6 +877,8 @@ func UpdateChannel(c *gin.Context) {
 			}
 		case "replace":
 			// 覆盖模式：直接使用新密钥（默认行为，不需要特殊处理）
+			// key 索引对应关系已变化，清空之前记录的限流状态
+			model.ResetChannelKeyLimits(channel.Id)
 		}
 	}
 	err = channel.Update()
@@ -1107,6 +1123,11 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	// 余量模式下的限流状态
+	Requests      int      `json:"requests,omitempty"`
+	Tokens        int      `json:"tokens,omitempty"`
+	Headroom      *float64 `json:"headroom,omitempty"`
+	CooldownUntil int64    `json:"cooldown_until,omitempty"`
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1158,6 +1179,7 @@ func ManageMultiKeys(c *gin.Context) {
 
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
+		keyLimits := model.GetChannelKeyLimits(channel.Id, channel.ChannelInfo.MultiKeyRPM, channel.ChannelInfo.MultiKeyTPM)
 		for i, key := range keys {
 			status := 1 // default enabled
 			var disabledTime int64
@@ -1194,13 +1216,20 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
-			allKeyStatusList = append(allKeyStatusList, KeyStatus{
+			keyStatus := KeyStatus{
 				Index:        i,
 				Status:       status,
 				DisabledTime: disabledTime,
 				Reason:       reason,
 				KeyPreview:   keyPreview,
-			})
+			}
+			if limit, ok := keyLimits[i]; ok {
+				keyStatus.Requests = limit.Requests
+				keyStatus.Tokens = limit.Tokens
+				keyStatus.Headroom = &limit.Headroom
+				keyStatus.CooldownUntil = limit.CooldownUntil
+			}
+			allKeyStatusList = append(allKeyStatusList, keyStatus)
 		}
 
 		// Apply status filter if specified
@@ -1483,6 +1512,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		model.ResetChannelKeyLimits(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1551,6 +1581,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		model.ResetChannelKeyLimits(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
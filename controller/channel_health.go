@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelHealth 获取渠道在各模型上的健康统计，channel_id 为空时返回全部渠道
+func GetChannelHealth(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	health, err := model.GetChannelHealthList(c.Request.Context(), channelId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    health,
+	})
+}
+
+// ResetChannelHealth 清空渠道的健康统计
+func ResetChannelHealth(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.ResetChannelHealth(c.Request.Context(), channelId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetChannelCircuit 获取渠道熔断状态，channel_id 为空时返回全部渠道
+func GetChannelCircuit(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelCircuitList(channelId),
+	})
+}
+
+// ResetChannelCircuit 手动恢复渠道的熔断状态
+func ResetChannelCircuit(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.ResetChannelCircuit(channelId)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
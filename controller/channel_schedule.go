@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelSchedules 获取渠道定时任务及下一次执行时间，可按 channel_id 或 tag 过滤
+func GetChannelSchedules(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	schedules, err := model.GetChannelSchedules(channelId, c.Query("tag"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    schedules,
+	})
+}
+
+func AddChannelSchedule(c *gin.Context) {
+	schedule := model.ChannelSchedule{}
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := schedule.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	schedule.Id = 0
+	schedule.LastRunTime = 0
+	schedule.LastError = ""
+	if err := schedule.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	created, err := model.GetChannelScheduleById(schedule.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    created,
+	})
+}
+
+func UpdateChannelSchedule(c *gin.Context) {
+	schedule := model.ChannelSchedule{}
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetChannelScheduleById(schedule.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := schedule.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := schedule.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	updated, err := model.GetChannelScheduleById(schedule.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    updated,
+	})
+}
+
+func DeleteChannelSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteChannelScheduleById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -1,11 +1,17 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/gin-gonic/gin"
 )
 
@@ -40,8 +46,8 @@ func GetConversations(c *gin.Context) {
 
 	startIdx := (page - 1) * pageSize
 
-	// 查询数据
-	conversations, total, err := model.GetConversations(userId, modelName, username, startTime, endTime, startIdx, pageSize)
+	// 查询数据，主表和压缩表合并返回，每条记录带 source 字段
+	conversations, total, err := model.ListConversations(userId, modelName, username, startTime, endTime, startIdx, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -62,7 +68,148 @@ func GetConversations(c *gin.Context) {
 	})
 }
 
+// SearchConversations 按关键词检索对话内容，返回带高亮的命中片段
+// mode=phrase 时按整句匹配，默认要求所有词都出现
+// 默认检索主表和压缩表，include_archive=true 时包含归档表，include_compressed=false 时跳过压缩表
+func SearchConversations(c *gin.Context) {
+	// 权限检查：只有管理员可以检索
+	currentUserId := c.GetInt("id")
+	if !model.IsAdmin(currentUserId) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	startTime, _ := strconv.ParseInt(c.Query("start_time"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_time"), 10, 64)
+	params := model.ConversationSearchParams{
+		Keyword:           strings.TrimSpace(c.Query("keyword")),
+		Phrase:            c.Query("mode") == "phrase",
+		UserId:            userId,
+		ModelName:         c.Query("model_name"),
+		Username:          c.Query("username"),
+		StartTime:         startTime,
+		EndTime:           endTime,
+		IncludeArchive:    c.Query("include_archive") == "true",
+		IncludeCompressed: c.Query("include_compressed") != "false",
+	}
+	if params.Keyword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "关键词不能为空",
+		})
+		return
+	}
+
+	// 设置默认值
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	startIdx := (page - 1) * pageSize
+
+	results, total, compressedTruncated, err := model.SearchConversations(params, startIdx, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "查询失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"data":                 results,
+			"total":                total,
+			"page":                 page,
+			"page_size":            pageSize,
+			"compressed_truncated": compressedTruncated,
+		},
+	})
+}
+
+// ExportConversations 以 JSONL 流式导出对话记录
+// format=raw 导出原始记录，format=finetune 导出 OpenAI chat 微调格式（无法转换的记录会被跳过）
+// 筛选参数与 GetConversations 一致，压缩表始终导出，include_archive=true 时同时导出归档表
+func ExportConversations(c *gin.Context) {
+	// 权限检查：只有管理员可以导出
+	currentUserId := c.GetInt("id")
+	if !model.IsAdmin(currentUserId) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	format := c.DefaultQuery("format", "raw")
+	if format != "raw" && format != "finetune" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的导出格式",
+		})
+		return
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	modelName := c.Query("model_name")
+	username := c.Query("username")
+	startTime, _ := strconv.ParseInt(c.Query("start_time"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_time"), 10, 64)
+	includeArchive := c.Query("include_archive") == "true"
+
+	filename := fmt.Sprintf("conversations_%s_%d.jsonl", format, time.Now().Unix())
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	exported, skipped := 0, 0
+	err := model.ExportConversations(c.Request.Context(), userId, modelName, username, startTime, endTime, includeArchive, func(conversations []*model.Conversation) error {
+		for _, conv := range conversations {
+			var line []byte
+			var err error
+			if format == "finetune" {
+				record, convertErr := service.ConversationToFineTuneRecord(conv)
+				if convertErr != nil {
+					skipped++
+					continue
+				}
+				line, err = common.Marshal(record)
+			} else {
+				line, err = common.Marshal(conv)
+			}
+			if err != nil {
+				return err
+			}
+			if _, err = c.Writer.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			exported++
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		// 响应头已发出，只能记录日志
+		logger.LogError(c, fmt.Sprintf("export conversations failed after %d records: %s", exported, err.Error()))
+		return
+	}
+	if skipped > 0 {
+		logger.LogInfo(c, fmt.Sprintf("export conversations: %d exported, %d skipped (unable to convert to fine-tuning format)", exported, skipped))
+	}
+}
+
 // GetConversationDetail 获取单条对话详情
+// source=compressed 时从压缩表读取，未指定时先查主表再查压缩表
 func GetConversationDetail(c *gin.Context) {
 	// 权限检查：只有管理员可以查看
 	userId := c.GetInt("id")
@@ -83,7 +230,7 @@ func GetConversationDetail(c *gin.Context) {
 		return
 	}
 
-	conversation, err := model.GetConversationById(id)
+	conversation, err := model.GetConversationBySource(id, c.Query("source"))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -113,6 +260,8 @@ func DeleteConversations(c *gin.Context) {
 
 	var req struct {
 		Ids []int `json:"ids"`
+		// 记录所在的表，compressed 表示压缩表，默认为主表
+		Source string `json:"source"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -131,7 +280,12 @@ func DeleteConversations(c *gin.Context) {
 		return
 	}
 
-	err := model.DeleteConversations(req.Ids)
+	var err error
+	if req.Source == model.ConversationSourceCompressed {
+		err = model.DeleteCompressedConversations(req.Ids)
+	} else {
+		err = model.DeleteConversations(req.Ids)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -200,6 +354,114 @@ func DeleteConversationsByCondition(c *gin.Context) {
 	})
 }
 
+// GetSelfConversations 获取当前用户自己的对话记录
+func GetSelfConversations(c *gin.Context) {
+	userId := c.GetInt("id")
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	modelName := c.Query("model_name")
+	startTime, _ := strconv.ParseInt(c.Query("start_time"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_time"), 10, 64)
+
+	// 设置默认值
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	startIdx := (page - 1) * pageSize
+
+	conversations, total, err := model.GetUserConversations(userId, modelName, startTime, endTime, startIdx, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "查询失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"data":      conversations,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetSelfConversationDetail 获取当前用户自己的单条对话详情
+func GetSelfConversationDetail(c *gin.Context) {
+	userId := c.GetInt("id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的ID",
+		})
+		return
+	}
+
+	conversation, err := model.GetUserConversationById(userId, id, c.Query("source"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "对话记录不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    conversation,
+	})
+}
+
+// DeleteSelfConversations 删除当前用户自己的对话记录，不属于该用户的 id 会被忽略
+func DeleteSelfConversations(c *gin.Context) {
+	userId := c.GetInt("id")
+	var req struct {
+		Ids    []int  `json:"ids"`
+		Source string `json:"source"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+
+	if len(req.Ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "未选择任何记录",
+		})
+		return
+	}
+
+	deleted, err := model.DeleteUserConversations(userId, req.Ids, req.Source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "删除成功",
+		"data":    gin.H{"deleted": deleted},
+	})
+}
+
 // GetConversationStats 获取对话统计信息
 func GetConversationStats(c *gin.Context) {
 	// 权限检查：只有管理员可以查看
@@ -233,7 +495,8 @@ func GetConversationStats(c *gin.Context) {
 	})
 }
 
-// UpdateConversationLogSetting 更新对话记录功能开关
+// UpdateConversationLogSetting 更新对话记录功能开关及记录策略
+// 策略字段均为可选，未传入的字段保持不变
 func UpdateConversationLogSetting(c *gin.Context) {
 	// 权限检查：只有管理员可以修改
 	userId := c.GetInt("id")
@@ -246,7 +509,13 @@ func UpdateConversationLogSetting(c *gin.Context) {
 	}
 
 	var req struct {
-		Enabled bool `json:"enabled"`
+		Enabled           bool                                               `json:"enabled"`
+		SampleRate        *float64                                           `json:"sample_rate"`
+		GroupRules        map[string]operation_setting.ConversationGroupRule `json:"group_rules"`
+		AllowUserOverride *bool                                              `json:"allow_user_override"`
+		StorageMode       *string                                            `json:"storage_mode"`
+		// 脱敏配置，传入时整体替换
+		Redaction *operation_setting.ConversationRedactionSetting `json:"redaction"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -257,16 +526,100 @@ func UpdateConversationLogSetting(c *gin.Context) {
 		return
 	}
 
-	common.ConversationLogEnabled = req.Enabled
+	if req.SampleRate != nil && (*req.SampleRate < 0 || *req.SampleRate > 1) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "采样率必须在 0 到 1 之间",
+		})
+		return
+	}
+	for group, rule := range req.GroupRules {
+		switch rule.Mode {
+		case operation_setting.ConversationLogModeAlways, operation_setting.ConversationLogModeNever:
+		case operation_setting.ConversationLogModeSample:
+			if rule.SampleRate < 0 || rule.SampleRate > 1 {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": fmt.Sprintf("分组 %s 的采样率必须在 0 到 1 之间", group),
+				})
+				return
+			}
+		default:
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("分组 %s 的记录模式无效", group),
+			})
+			return
+		}
+	}
+
+	if req.StorageMode != nil && *req.StorageMode != operation_setting.ConversationStorageModePlain && *req.StorageMode != operation_setting.ConversationStorageModeCompressed {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的存储模式",
+		})
+		return
+	}
+
+	if req.Redaction != nil {
+		if err := operation_setting.ValidateConversationRedactionRules(req.Redaction.BuiltinRules, req.Redaction.CustomRules); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	options := map[string]string{
+		"ConversationLogEnabled": strconv.FormatBool(req.Enabled),
+	}
+	if req.SampleRate != nil {
+		options["conversation_setting.sample_rate"] = strconv.FormatFloat(*req.SampleRate, 'f', -1, 64)
+	}
+	if req.GroupRules != nil {
+		options["conversation_setting.group_rules"] = common.GetJsonString(req.GroupRules)
+	}
+	if req.AllowUserOverride != nil {
+		options["conversation_setting.allow_user_override"] = strconv.FormatBool(*req.AllowUserOverride)
+	}
+	if req.StorageMode != nil {
+		options["conversation_setting.storage_mode"] = *req.StorageMode
+	}
+	if req.Redaction != nil {
+		options["conversation_redaction_setting.enabled"] = strconv.FormatBool(req.Redaction.Enabled)
+		options["conversation_redaction_setting.builtin_rules"] = common.GetJsonString(req.Redaction.BuiltinRules)
+		options["conversation_redaction_setting.keywords"] = common.GetJsonString(req.Redaction.Keywords)
+		options["conversation_redaction_setting.custom_rules"] = common.GetJsonString(req.Redaction.CustomRules)
+	}
+	for key, value := range options {
+		if err := model.UpdateOption(key, value); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "设置已更新",
-		"data":    gin.H{"enabled": req.Enabled},
+		"data":    conversationLogSettingData(),
 	})
 }
 
-// GetConversationLogSetting 获取对话记录功能开关状态
+// conversationLogSettingData 汇总开关状态与当前记录策略
+func conversationLogSettingData() gin.H {
+	setting := operation_setting.GetConversationSetting()
+	return gin.H{
+		"enabled":             common.ConversationLogEnabled,
+		"sample_rate":         setting.SampleRate,
+		"group_rules":         setting.GroupRules,
+		"allow_user_override": setting.AllowUserOverride,
+		"storage_mode":        setting.StorageMode,
+		"redaction":           operation_setting.GetConversationRedactionSetting(),
+	}
+}
+
+// GetConversationLogSetting 获取对话记录功能开关状态及记录策略
 func GetConversationLogSetting(c *gin.Context) {
 	// 权限检查：只有管理员可以查看
 	userId := c.GetInt("id")
@@ -281,6 +634,6 @@ func GetConversationLogSetting(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    gin.H{"enabled": common.ConversationLogEnabled},
+		"data":    conversationLogSettingData(),
 	})
 }
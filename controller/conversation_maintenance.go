@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -158,6 +159,128 @@ func OptimizeConversationTables(c *gin.Context) {
 	})
 }
 
+// BuildConversationSearchIndexes 创建对话全文索引（后台执行）
+// MySQL 首次创建 FULLTEXT 索引会重建整张表并阻塞写入，应在低峰期手动触发
+func BuildConversationSearchIndexes(c *gin.Context) {
+	userId := c.GetInt("id")
+	if !model.IsAdmin(userId) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	go func() {
+		if !model.EnsureConversationSearchIndexes(true) {
+			common.SysLog("对话全文索引正在创建中，忽略本次请求")
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "全文索引创建任务已启动，将在后台执行",
+	})
+}
+
+// StartConversationCompressMigration 将主表中的对话批量迁移到压缩表（后台执行）
+func StartConversationCompressMigration(c *gin.Context) {
+	userId := c.GetInt("id")
+	if !model.IsAdmin(userId) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	var req struct {
+		Days      int `json:"days"`       // 只迁移多少天前的数据，0 表示全部
+		BatchSize int `json:"batch_size"` // 每批处理数量
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+
+	if req.BatchSize <= 0 {
+		req.BatchSize = 500
+	}
+	var targetTime int64
+	if req.Days > 0 {
+		targetTime = time.Now().AddDate(0, 0, -req.Days).Unix()
+	}
+
+	err := model.StartConversationCompressMigration(targetTime, req.BatchSize)
+	if errors.Is(err, model.ErrCompressMigrationRunning) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "迁移任务正在执行中",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "启动迁移失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "迁移任务已启动，将在后台执行",
+		"data":    model.GetConversationCompressMigrationProgress(),
+	})
+}
+
+// GetConversationCompressMigrationProgress 获取压缩迁移任务进度
+func GetConversationCompressMigrationProgress(c *gin.Context) {
+	userId := c.GetInt("id")
+	if !model.IsAdmin(userId) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetConversationCompressMigrationProgress(),
+	})
+}
+
+// CancelConversationCompressMigration 取消压缩迁移任务，已迁移的批次保留在压缩表中
+func CancelConversationCompressMigration(c *gin.Context) {
+	userId := c.GetInt("id")
+	if !model.IsAdmin(userId) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	if !model.CancelConversationCompressMigration() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "没有正在执行的迁移任务",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已发送取消请求",
+	})
+}
+
 // GetConversationTableStats 获取对话表统计信息
 func GetConversationTableStats(c *gin.Context) {
 	userId := c.GetInt("id")
@@ -186,6 +309,7 @@ func GetConversationTableStats(c *gin.Context) {
 }
 
 // SearchArchivedConversations 搜索归档表（扩展查询功能）
+// 冷存储记录只返回元数据，完整内容通过 GET /api/conversation/:id?source=cold 加载
 func SearchArchivedConversations(c *gin.Context) {
 	currentUserId := c.GetInt("id")
 	if !model.IsAdmin(currentUserId) {
@@ -205,6 +329,7 @@ func SearchArchivedConversations(c *gin.Context) {
 	startTime, _ := strconv.ParseInt(c.Query("start_time"), 10, 64)
 	endTime, _ := strconv.ParseInt(c.Query("end_time"), 10, 64)
 	searchArchive := c.Query("search_archive") == "true"
+	conversationId, _ := strconv.Atoi(c.Query("conversation_id"))
 
 	// 设置默认值
 	if page <= 0 {
@@ -217,7 +342,7 @@ func SearchArchivedConversations(c *gin.Context) {
 	startIdx := (page - 1) * pageSize
 
 	// 查询数据（包含归档表）
-	conversations, total, err := model.GetConversationsWithArchive(userId, modelName, username, startTime, endTime, startIdx, pageSize, searchArchive)
+	conversations, total, err := model.GetConversationsWithArchive(conversationId, userId, modelName, username, startTime, endTime, startIdx, pageSize, searchArchive)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
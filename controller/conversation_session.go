@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// GetConversationSessions 按会话聚合的对话列表，筛选参数与 GetConversations 相同
+func GetConversationSessions(c *gin.Context) {
+	// 权限检查：只有管理员可以查看
+	if !model.IsAdmin(c.GetInt("id")) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	modelName := c.Query("model_name")
+	username := c.Query("username")
+	startTime, _ := strconv.ParseInt(c.Query("start_time"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_time"), 10, 64)
+
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	sessions, total, err := model.GetConversationSessions(userId, modelName, username, startTime, endTime, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "查询失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"data":      sessions,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetConversationSessionThread 返回会话中每一轮新增的消息和回复，重复的历史消息只出现一次
+// 不同用户可能使用相同的客户端会话 id，可通过 user_id 指定用户
+func GetConversationSessionThread(c *gin.Context) {
+	// 权限检查：只有管理员可以查看
+	if !model.IsAdmin(c.GetInt("id")) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权限访问",
+		})
+		return
+	}
+
+	sessionId := c.Param("session_id")
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+
+	thread, err := model.GetConversationSessionThread(c.Request.Context(), userId, sessionId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "会话不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    thread,
+	})
+}
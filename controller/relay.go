@@ -6,7 +6,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -20,6 +22,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -140,6 +143,32 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	// common.SetContextKey(c, constant.ContextKeyTokenCountMeta, meta)
 
+	newAPIError = service.CheckTokenScopeRequest(c, meta)
+	if newAPIError != nil {
+		return
+	}
+	newAPIError = service.CheckTokenScopeQuota(c, priceData)
+	if newAPIError != nil {
+		return
+	}
+
+	releaseRateLimit, newAPIError := service.AcquireTokenRateLimit(c, relayInfo)
+	if newAPIError != nil {
+		return
+	}
+	defer releaseRateLimit()
+	// 准入检查未全部通过时退还已预扣的 RPM / TPM，被拒绝的请求不消耗调用方的限额
+	admitted := false
+	defer func() {
+		if !admitted {
+			service.RefundRateLimitCharges(c)
+		}
+	}()
+	newAPIError = service.AcquireModelTPMLimit(c, relayInfo)
+	if newAPIError != nil {
+		return
+	}
+
 	if priceData.FreeModel {
 		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
 	} else {
@@ -156,35 +185,58 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
+	admitted = true
+	newAPIError = relayWithRetry(c, relayFormat, relayInfo, group, originalModel)
+	if newAPIError != nil && shouldFallbackModel(c, relayFormat, newAPIError) {
+		newAPIError = relayModelFallback(c, relayFormat, relayInfo, group, originalModel, tokens, meta, newAPIError)
+	}
+	if newAPIError == nil {
+		saveChannelAffinity(c)
+	}
+}
+
+// saveChannelAffinity 请求成功后将会话绑定到实际提供服务的渠道和 key，切换了备用模型时不绑定
+func saveChannelAffinity(c *gin.Context) {
+	affinityKey := common.GetContextKeyString(c, constant.ContextKeyChannelAffinityKey)
+	if affinityKey == "" || common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom) != "" {
+		return
+	}
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	if autoGroup := c.GetString("auto_group"); group == "auto" && autoGroup != "" {
+		group = autoGroup
+	}
+	affinity := model.ChannelAffinity{
+		ChannelId: common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		Group:     group,
+	}
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		affinity.KeyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	gopool.Go(func() {
+		model.SetChannelAffinity(affinityKey, affinity)
+	})
+}
+
+// relayWithRetry 在分组下为指定模型选择渠道转发，失败时按重试次数换渠道重试
+// 首次尝试使用已写入上下文的渠道
+func relayWithRetry(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, group string, modelName string) (newAPIError *types.NewAPIError) {
 	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
+		channel, err := getChannel(c, group, modelName, i)
 		if err != nil {
 			logger.LogError(c, err.Error())
 			newAPIError = err
 			break
 		}
 
-		addUsedChannel(c, channel.Id)
-		requestBody, _ := common.GetRequestBody(c)
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
+		if delay := operation_setting.GetHedgeDelayMs(group); i == 0 && delay > 0 && shouldHedge(c, relayFormat) {
+			newAPIError = relayHedged(c, relayFormat, relayInfo, group, modelName, channel, time.Duration(delay)*time.Millisecond)
+		} else {
+			newAPIError = relayAttempt(c, relayFormat, relayInfo, channel, modelName)
 		}
-
 		if newAPIError == nil {
-			return
+			return nil
 		}
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
-
 		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
 			break
 		}
@@ -195,6 +247,143 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
 		logger.LogInfo(c, retryLogStr)
 	}
+	return newAPIError
+}
+
+// relayAttempt 使用上下文中已选择的渠道转发一次，并记录本次尝试的结果
+func relayAttempt(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, channel *model.Channel, modelName string) *types.NewAPIError {
+	addUsedChannel(c, channel.Id)
+	attempt := addChannelAttempt(c, channel)
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		model.RecordChannelKeyTokens(channel.Id, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), relayInfo.PromptTokens)
+	}
+	release := model.AcquireChannelLimit(channel.Id, relayInfo.PromptTokens)
+	defer release()
+	common.SetContextKey(c, constant.ContextKeyChannelLimitCharged, max(relayInfo.PromptTokens, 0))
+	attemptStart := time.Now()
+	requestBody, _ := common.GetRequestBody(c)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+
+	newAPIError := dispatchRelay(c, relayFormat, relayInfo)
+	finishChannelAttempt(c, relayInfo, channel, modelName, attempt, attemptStart, newAPIError)
+	return newAPIError
+}
+
+func dispatchRelay(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, relayInfo)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, relayInfo)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, relayInfo)
+	default:
+		return relayHandler(c, relayInfo)
+	}
+}
+
+// finishChannelAttempt 将一次尝试的结果计入健康分和熔断器，失败时处理渠道错误
+func finishChannelAttempt(c *gin.Context, relayInfo *relaycommon.RelayInfo, channel *model.Channel, modelName string, attempt *types.ChannelAttempt, attemptStart time.Time, newAPIError *types.NewAPIError) {
+	recordChannelHealth(relayInfo, channel, modelName, attemptStart, newAPIError)
+	// 首次尝试的渠道只有基本信息，是否多 key 以上下文为准
+	isMultiKey := common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey)
+	service.RecordChannelCircuitResult(*types.NewChannelError(channel.Id, channel.Type, channel.Name, isMultiKey, "", channel.GetAutoBan()), modelName, newAPIError)
+	if newAPIError == nil {
+		return
+	}
+	attempt.StatusCode = newAPIError.StatusCode
+	attempt.ErrorCode = string(newAPIError.GetErrorCode())
+
+	processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, isMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+}
+
+// shouldFallbackModel 渠道侧失败且重试耗尽后才切换备用模型，请求本身的错误、额度不足和指定渠道的请求不切换
+func shouldFallbackModel(c *gin.Context, relayFormat types.RelayFormat, err *types.NewAPIError) bool {
+	if relayFormat == types.RelayFormatOpenAIRealtime {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	if err.GetErrorCode() == types.ErrorCodeGetChannelFailed {
+		return true
+	}
+	return shouldRetry(c, err, 1)
+}
+
+// tokenAllowsModel 令牌开启模型限制时，备用模型同样需要在允许列表中
+func tokenAllowsModel(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	tokenModelLimit, ok := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		return false
+	}
+	return model.TokenModelLimitAllows(tokenModelLimit, modelName)
+}
+
+// relayModelFallback 按分组配置的备用链依次改用其他模型转发，计费以实际提供服务的模型为准
+// 成功时通过响应头 X-Served-Model 返回实际模型，并在消费日志中记录原始模型
+func relayModelFallback(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, group string, originalModel string, tokens int, meta *types.TokenCountMeta, lastErr *types.NewAPIError) *types.NewAPIError {
+	chain := operation_setting.GetModelFallbackChain(group, originalModel)
+	if len(chain) == 0 {
+		return lastErr
+	}
+	tried := map[string]bool{originalModel: true}
+	for _, fallbackModel := range chain {
+		if fallbackModel == "" || tried[fallbackModel] {
+			continue
+		}
+		tried[fallbackModel] = true
+		if !tokenAllowsModel(c, fallbackModel) {
+			continue
+		}
+		channel, _, err := model.CacheGetRandomSatisfiedChannel(c, group, fallbackModel, 0, nil)
+		if err != nil || channel == nil {
+			continue
+		}
+		if newAPIError := middleware.SetupContextForSelectedChannel(c, channel, fallbackModel); newAPIError != nil {
+			lastErr = newAPIError
+			continue
+		}
+
+		// 先退还原模型的预扣费，再按备用模型重新计价预扣
+		if relayInfo.FinalPreConsumedQuota != 0 {
+			refundInfo := *relayInfo
+			service.ReturnPreConsumedQuota(c, &refundInfo)
+			relayInfo.FinalPreConsumedQuota = 0
+		}
+		relayInfo.OriginModelName = fallbackModel
+		priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeModelPriceError)
+		}
+		if newAPIError := service.CheckTokenScopeQuota(c, priceData); newAPIError != nil {
+			return newAPIError
+		}
+		if newAPIError := service.AcquireFallbackModelTPMLimit(c, relayInfo); newAPIError != nil {
+			return newAPIError
+		}
+		if !priceData.FreeModel {
+			if newAPIError := service.PreConsumeQuota(c, priceData.QuotaToPreConsume, relayInfo); newAPIError != nil {
+				return newAPIError
+			}
+		}
+
+		logger.LogInfo(c, fmt.Sprintf("模型 %s 所有渠道均失败，切换到备用模型 %s", originalModel, fallbackModel))
+		common.SetContextKey(c, constant.ContextKeyModelFallbackFrom, originalModel)
+		c.Header("X-Served-Model", fallbackModel)
+		lastErr = relayWithRetry(c, relayFormat, relayInfo, group, fallbackModel)
+		if lastErr == nil {
+			return nil
+		}
+		if !shouldFallbackModel(c, relayFormat, lastErr) {
+			break
+		}
+	}
+	c.Writer.Header().Del("X-Served-Model")
+	return lastErr
 }
 
 var upgrader = websocket.Upgrader{
@@ -210,6 +399,42 @@ func addUsedChannel(c *gin.Context, channelId int) {
 	c.Set("use_channel", useChannel)
 }
 
+// recordChannelHealth 将本次尝试的结果计入渠道健康分，请求本身的错误不影响渠道
+func recordChannelHealth(relayInfo *relaycommon.RelayInfo, channel *model.Channel, modelName string, attemptStart time.Time, err *types.NewAPIError) {
+	success := err == nil
+	if !success && !service.IsChannelSideFailure(channel.Type, err) {
+		return
+	}
+	var firstToken time.Duration
+	if relayInfo.IsStream && relayInfo.FirstResponseTime.After(attemptStart) {
+		firstToken = relayInfo.FirstResponseTime.Sub(attemptStart)
+	}
+	model.RecordChannelHealth(channel.Id, modelName, success, time.Since(attemptStart), firstToken)
+}
+
+// addChannelAttempt 记录本次尝试的渠道，失败时由调用方补充状态码和错误码
+func addChannelAttempt(c *gin.Context, channel *model.Channel) *types.ChannelAttempt {
+	attempts, _ := common.GetContextKeyType[[]*types.ChannelAttempt](c, constant.ContextKeyChannelAttempts)
+	attempt := &types.ChannelAttempt{
+		ChannelId:   channel.Id,
+		ChannelName: channel.Name,
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelAttempts, append(attempts, attempt))
+	return attempt
+}
+
+// getTriedChannelIds 本次请求已尝试过的渠道，重试时优先选择其他渠道
+func getTriedChannelIds(c *gin.Context) map[int]bool {
+	useChannel := c.GetStringSlice("use_channel")
+	tried := make(map[int]bool, len(useChannel))
+	for _, id := range useChannel {
+		if channelId, err := strconv.Atoi(id); err == nil {
+			tried[channelId] = true
+		}
+	}
+	return tried
+}
+
 func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, *types.NewAPIError) {
 	if retryCount == 0 {
 		autoBan := c.GetBool("auto_ban")
@@ -224,7 +449,7 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount, getTriedChannelIds(c))
 	if err != nil {
 		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
@@ -284,7 +509,10 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
-	if service.ShouldDisableChannel(channelError.ChannelId, err) && channelError.AutoBan {
+	// 启用熔断时由熔断器暂时摘除渠道，不再永久禁用
+	// 余量模式下被限流的 key 只冷却，不禁用
+	keyRateLimited := service.IsChannelKeyRateLimited(channelError, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), err)
+	if service.ShouldDisableChannel(channelError.ChannelId, err) && channelError.AutoBan && !service.ShouldUseChannelCircuitBreaker(channelError) && !keyRateLimited {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.Error())
 		})
@@ -310,6 +538,9 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 		other["channel_type"] = c.GetInt("channel_type")
 		adminInfo := make(map[string]interface{})
 		adminInfo["use_channel"] = c.GetStringSlice("use_channel")
+		if attempts, ok := common.GetContextKeyType[[]*types.ChannelAttempt](c, constant.ContextKeyChannelAttempts); ok && len(attempts) > 1 {
+			adminInfo["retry_path"] = attempts
+		}
 		isMultiKey := common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey)
 		if isMultiKey {
 			adminInfo["is_multi_key"] = true
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errHedgeLost = errors.New("hedged request lost to another attempt")
+
+// hedgeResponseWriter 对冲请求中每个尝试独立的 ResponseWriter
+// 获胜前响应头写入自身的副本，只有获胜的尝试才会写到真正的响应中，落选尝试的输出被丢弃
+type hedgeResponseWriter struct {
+	gin.ResponseWriter
+	attempt *relaycommon.HedgeAttempt
+	header  http.Header
+	synced  bool
+}
+
+func newHedgeResponseWriter(w gin.ResponseWriter, attempt *relaycommon.HedgeAttempt) *hedgeResponseWriter {
+	return &hedgeResponseWriter{ResponseWriter: w, attempt: attempt, header: make(http.Header)}
+}
+
+// claim 首次向下游输出时争夺获胜，获胜后将之前设置的响应头同步到真正的响应中
+func (w *hedgeResponseWriter) claim() bool {
+	if !w.attempt.Claim() {
+		return false
+	}
+	if !w.synced {
+		for key, values := range w.header {
+			w.ResponseWriter.Header()[key] = values
+		}
+		w.synced = true
+	}
+	return true
+}
+
+func (w *hedgeResponseWriter) Header() http.Header {
+	if w.synced {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeResponseWriter) WriteHeader(code int) {
+	if w.claim() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *hedgeResponseWriter) WriteHeaderNow() {
+	if w.claim() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeResponseWriter) Write(data []byte) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeResponseWriter) WriteString(s string) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeResponseWriter) Flush() {
+	if w.attempt.Won() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeResponseWriter) Written() bool {
+	return w.attempt.Won() && w.ResponseWriter.Written()
+}
+
+func (w *hedgeResponseWriter) Status() int {
+	if w.attempt.Won() {
+		return w.ResponseWriter.Status()
+	}
+	return http.StatusOK
+}
+
+func (w *hedgeResponseWriter) Size() int {
+	if w.attempt.Won() {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if !w.claim() {
+		return nil, nil, errHedgeLost
+	}
+	return w.ResponseWriter.Hijack()
+}
+
+// hedgeAttemptResult 对冲请求中一个尝试的上下文和结果
+type hedgeAttemptResult struct {
+	ctx          *gin.Context
+	info         *relaycommon.RelayInfo
+	channel      *model.Channel
+	attempt      *types.ChannelAttempt
+	hedge        *relaycommon.HedgeAttempt
+	attemptStart time.Time
+	err          *types.NewAPIError
+}
+
+// shouldHedge 实时语音和指定渠道的请求不对冲
+func shouldHedge(c *gin.Context, relayFormat types.RelayFormat) bool {
+	if relayFormat == types.RelayFormatOpenAIRealtime {
+		return false
+	}
+	_, specific := c.Get("specific_channel_id")
+	return !specific
+}
+
+// relayHedged 先向已选择的渠道转发，超过 delay 仍未收到上游响应时向第二个渠道发起相同请求，
+// 先收到响应的尝试输出给客户端并计费，另一个尝试被取消。两个渠道都会记录在 use_channel 中
+func relayHedged(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, group string, modelName string, channel *model.Channel, delay time.Duration) *types.NewAPIError {
+	race := relaycommon.NewHedgeRace()
+	defer race.Close()
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	results := make(chan *hedgeAttemptResult, 2)
+	var launched []*gin.Context
+	launch := func(hc *gin.Context, ch *model.Channel) {
+		hedge := race.NewAttempt()
+		result := &hedgeAttemptResult{
+			ctx:          hc,
+			info:         relayInfo.CloneForHedge(hedge),
+			channel:      ch,
+			hedge:        hedge,
+			attemptStart: time.Now(),
+		}
+		addUsedChannel(c, ch.Id)
+		result.attempt = addChannelAttempt(c, ch)
+		// 消费日志在尝试内部按尝试的上下文写入，将最新的 use_channel 和尝试记录同步给所有已发起的尝试
+		launched = append(launched, hc)
+		attempts, _ := common.GetContextKeyType[[]*types.ChannelAttempt](c, constant.ContextKeyChannelAttempts)
+		for _, ctx := range launched {
+			ctx.Set("use_channel", slices.Clone(c.GetStringSlice("use_channel")))
+			common.SetContextKey(ctx, constant.ContextKeyChannelAttempts, slices.Clone(attempts))
+		}
+		if common.GetContextKeyBool(hc, constant.ContextKeyChannelIsMultiKey) {
+			model.RecordChannelKeyTokens(ch.Id, common.GetContextKeyInt(hc, constant.ContextKeyChannelMultiKeyIndex), relayInfo.PromptTokens)
+		}
+		request := c.Request.Clone(c.Request.Context())
+		request.Body = io.NopCloser(bytes.NewReader(requestBody))
+		hc.Request = request
+		hc.Writer = newHedgeResponseWriter(c.Writer, hedge)
+		release := model.AcquireChannelLimit(ch.Id, relayInfo.PromptTokens)
+		common.SetContextKey(hc, constant.ContextKeyChannelLimitCharged, max(relayInfo.PromptTokens, 0))
+		go func() {
+			defer func() {
+				release()
+				if r := recover(); r != nil {
+					result.err = types.NewError(fmt.Errorf("hedged request panic: %v", r), types.ErrorCodeBadResponse)
+				}
+				results <- result
+			}()
+			result.err = dispatchRelay(hc, relayFormat, result.info)
+		}()
+	}
+
+	// 第二个渠道优先选择未尝试过的渠道，只有同一个渠道时不对冲
+	launchSecond := func() bool {
+		hc := c.Copy()
+		second, _, err := model.CacheGetRandomSatisfiedChannel(hc, group, modelName, 0, getTriedChannelIds(c))
+		if err != nil || second == nil || second.Id == channel.Id {
+			return false
+		}
+		if newAPIError := middleware.SetupContextForSelectedChannel(hc, second, modelName); newAPIError != nil {
+			return false
+		}
+		logger.LogInfo(c, fmt.Sprintf("渠道 #%d 超过 %d ms 未响应，对冲请求渠道 #%d", channel.Id, delay.Milliseconds(), second.Id))
+		launch(hc, second)
+		return true
+	}
+
+	launch(c.Copy(), channel)
+	pending := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	timerC := timer.C
+	claimedC := race.Claimed()
+	finished := make([]*hedgeAttemptResult, 0, 2)
+	for pending > 0 {
+		select {
+		case <-timerC:
+			timerC = nil
+			if launchSecond() {
+				pending++
+			}
+		case <-claimedC:
+			// 已有尝试收到响应，不再发起对冲
+			claimedC = nil
+			timerC = nil
+		case result := <-results:
+			// 首个渠道在对冲前就结束（包括失败）时不再对冲，失败交给正常的重试逻辑
+			pending--
+			finished = append(finished, result)
+		}
+	}
+
+	var chosen *hedgeAttemptResult
+	for _, result := range finished {
+		if result.hedge.Lost() {
+			result.attempt.ErrorCode = string(types.ErrorCodeHedgeLost)
+			continue
+		}
+		finishChannelAttempt(result.ctx, result.info, result.channel, modelName, result.attempt, result.attemptStart, result.err)
+		if chosen == nil || result.err == nil {
+			chosen = result
+		}
+	}
+	if chosen == nil {
+		chosen = finished[len(finished)-1]
+	}
+
+	// 将实际使用的尝试的上下文和 RelayInfo 合并回主请求，use_channel 和尝试记录以主请求为准
+	for key, value := range chosen.ctx.Keys {
+		if key == "use_channel" || key == string(constant.ContextKeyChannelAttempts) {
+			continue
+		}
+		c.Set(key, value)
+	}
+	*relayInfo = *chosen.info
+	relayInfo.HedgeAttempt = nil
+	return chosen.err
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -149,6 +150,32 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if !operation_setting.IsValidConversationLogMode(token.ConversationLogMode) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的对话记录模式",
+		})
+		return
+	}
+	if _, err := model.ParseSpendingBudgets(token.Budgets); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if token.RPM < 0 || token.TPM < 0 || token.MaxConcurrency < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "速率限制不能为负数",
+		})
+		return
+	}
+	if _, err := model.ParseTokenScope(token.Scopes); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateTokenIpLists(&token); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -159,18 +186,25 @@ func AddToken(c *gin.Context) {
 		return
 	}
 	cleanToken := model.Token{
-		UserId:             c.GetInt("id"),
-		Name:               token.Name,
-		Key:                key,
-		CreatedTime:        common.GetTimestamp(),
-		AccessedTime:       common.GetTimestamp(),
-		ExpiredTime:        token.ExpiredTime,
-		RemainQuota:        token.RemainQuota,
-		UnlimitedQuota:     token.UnlimitedQuota,
-		ModelLimitsEnabled: token.ModelLimitsEnabled,
-		ModelLimits:        token.ModelLimits,
-		AllowIps:           token.AllowIps,
-		Group:              token.Group,
+		UserId:              c.GetInt("id"),
+		Name:                token.Name,
+		Key:                 key,
+		CreatedTime:         common.GetTimestamp(),
+		AccessedTime:        common.GetTimestamp(),
+		ExpiredTime:         token.ExpiredTime,
+		RemainQuota:         token.RemainQuota,
+		UnlimitedQuota:      token.UnlimitedQuota,
+		ModelLimitsEnabled:  token.ModelLimitsEnabled,
+		ModelLimits:         token.ModelLimits,
+		AllowIps:            token.AllowIps,
+		DenyIps:             token.DenyIps,
+		Group:               token.Group,
+		ConversationLogMode: token.ConversationLogMode,
+		Budgets:             token.Budgets,
+		RPM:                 token.RPM,
+		TPM:                 token.TPM,
+		MaxConcurrency:      token.MaxConcurrency,
+		Scopes:              token.Scopes,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -215,6 +249,32 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if !operation_setting.IsValidConversationLogMode(token.ConversationLogMode) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的对话记录模式",
+		})
+		return
+	}
+	if _, err := model.ParseSpendingBudgets(token.Budgets); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if token.RPM < 0 || token.TPM < 0 || token.MaxConcurrency < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "速率限制不能为负数",
+		})
+		return
+	}
+	if _, err := model.ParseTokenScope(token.Scopes); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateTokenIpLists(&token); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
@@ -247,7 +307,14 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimitsEnabled = token.ModelLimitsEnabled
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
+		cleanToken.DenyIps = token.DenyIps
 		cleanToken.Group = token.Group
+		cleanToken.ConversationLogMode = token.ConversationLogMode
+		cleanToken.Budgets = token.Budgets
+		cleanToken.RPM = token.RPM
+		cleanToken.TPM = token.TPM
+		cleanToken.MaxConcurrency = token.MaxConcurrency
+		cleanToken.Scopes = token.Scopes
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -287,3 +354,16 @@ func DeleteTokenBatch(c *gin.Context) {
 		"data":    count,
 	})
 }
+
+// validateTokenIpLists 校验令牌的 IP 允许和禁止列表，每个条目必须是 IP 地址或 CIDR
+func validateTokenIpLists(token *model.Token) error {
+	for _, ips := range []*string{token.AllowIps, token.DenyIps} {
+		if ips == nil {
+			continue
+		}
+		if _, err := common.ParseIPList(*ips); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/QuantumNous/new-api/constant"
 
@@ -596,9 +597,16 @@ func GetUserModels(c *gin.Context) {
 	return
 }
 
+// updateUserRequest 管理员编辑用户的请求，对话记录偏好保存在用户设置中，未传时保持不变
+type updateUserRequest struct {
+	model.User
+	ConversationLogMode *string `json:"conversation_log_mode"`
+}
+
 func UpdateUser(c *gin.Context) {
-	var updatedUser model.User
-	err := json.NewDecoder(c.Request.Body).Decode(&updatedUser)
+	var req updateUserRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	updatedUser := req.User
 	if err != nil || updatedUser.Id == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -616,6 +624,23 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if _, err := model.ParseSpendingBudgets(updatedUser.Budgets); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, ips := range []string{updatedUser.AllowIps, updatedUser.DenyIps} {
+		if _, err := common.ParseIPList(ips); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if req.ConversationLogMode != nil && !operation_setting.IsValidConversationLogMode(*req.ConversationLogMode) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的对话记录模式",
+		})
+		return
+	}
 	originUser, err := model.GetUserById(updatedUser.Id, false)
 	if err != nil {
 		common.ApiError(c, err)
@@ -644,6 +669,16 @@ func UpdateUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	// Edit 后 updatedUser 为数据库中的最新记录
+	if req.ConversationLogMode != nil {
+		setting := updatedUser.GetSetting()
+		setting.ConversationLogMode = *req.ConversationLogMode
+		updatedUser.SetSetting(setting)
+		if err := updatedUser.Update(false); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", logger.LogQuota(originUser.Quota), logger.LogQuota(updatedUser.Quota)))
 	}
@@ -1108,6 +1143,7 @@ type UpdateUserSettingRequest struct {
 	GotifyPriority             int     `json:"gotify_priority,omitempty"`
 	AcceptUnsetModelRatioModel bool    `json:"accept_unset_model_ratio_model"`
 	RecordIpLog                bool    `json:"record_ip_log"`
+	ConversationLogMode        *string `json:"conversation_log_mode"` // 未传时保留原有设置
 }
 
 func UpdateUserSetting(c *gin.Context) {
@@ -1138,6 +1174,15 @@ func UpdateUserSetting(c *gin.Context) {
 		return
 	}
 
+	// 验证对话记录偏好
+	if req.ConversationLogMode != nil && !operation_setting.IsValidConversationLogMode(*req.ConversationLogMode) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的对话记录模式",
+		})
+		return
+	}
+
 	// 如果是webhook类型,验证webhook地址
 	if req.QuotaWarningType == dto.NotifyTypeWebhook {
 		if req.WebhookUrl == "" {
@@ -1243,6 +1288,10 @@ func UpdateUserSetting(c *gin.Context) {
 		QuotaWarningThreshold: req.QuotaWarningThreshold,
 		AcceptUnsetRatioModel: req.AcceptUnsetModelRatioModel,
 		RecordIpLog:           req.RecordIpLog,
+		ConversationLogMode:   user.GetSetting().ConversationLogMode,
+	}
+	if req.ConversationLogMode != nil {
+		settings.ConversationLogMode = *req.ConversationLogMode
 	}
 
 	// 如果是webhook类型,添加webhook相关设置
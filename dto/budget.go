@@ -0,0 +1,16 @@
+package dto
+
+// 周期预算的周期
+const (
+	BudgetPeriodDay   = "day"
+	BudgetPeriodWeek  = "week"
+	BudgetPeriodMonth = "month"
+)
+
+// SpendingBudget 令牌或用户的周期消费预算，与剩余额度同时生效
+type SpendingBudget struct {
+	Period string `json:"period"` // day / week / month
+	Quota  int    `json:"quota"`  // 周期内可消费的额度
+	// 滚动窗口（最近 24 小时 / 7 天 / 30 天），否则按自然日、自然周（周一开始）、自然月重置
+	Rolling bool `json:"rolling,omitempty"`
+}
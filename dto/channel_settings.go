@@ -31,6 +31,18 @@ type ChannelOtherSettings struct {
 	DisableStore          bool          `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
 	AllowSafetyIdentifier bool          `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
 	AwsKeyType            AwsKeyType    `json:"aws_key_type,omitempty"`
+	MaxConcurrency        int           `json:"max_concurrency,omitempty"` // 渠道最大并发请求数，0表示不限制
+	RPM                   int           `json:"rpm,omitempty"`             // 渠道每分钟请求数上限，0表示不限制
+	TPM                   int           `json:"tpm,omitempty"`             // 渠道每分钟token数上限（请求时按预估输入token计入，结束后按实际输入+输出补记），0表示不限制
+	// 上游采购价，key 为模型名，"*" 为未单独配置的模型的默认价格
+	UpstreamPrices map[string]UpstreamPrice `json:"upstream_prices,omitempty"`
+}
+
+// UpstreamPrice 渠道上游的采购价（美元），用于成本路由和毛利统计
+type UpstreamPrice struct {
+	Input   float64 `json:"input,omitempty"`   // 每百万输入 token
+	Output  float64 `json:"output,omitempty"`  // 每百万输出 token
+	Request float64 `json:"request,omitempty"` // 每次请求，按次计费的模型使用
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
@@ -0,0 +1,44 @@
+package dto
+
+// 令牌权限范围中的端点类型
+const (
+	TokenScopeEndpointChat        = "chat" // chat/completions、completions、messages 以及 Gemini 的内容生成
+	TokenScopeEndpointResponses   = "responses"
+	TokenScopeEndpointEmbeddings  = "embeddings"
+	TokenScopeEndpointImages      = "images"
+	TokenScopeEndpointAudio       = "audio"
+	TokenScopeEndpointRealtime    = "realtime"
+	TokenScopeEndpointRerank      = "rerank"
+	TokenScopeEndpointModerations = "moderations"
+	TokenScopeEndpointVideo       = "video"
+	TokenScopeEndpointMidjourney  = "midjourney"
+	TokenScopeEndpointSuno        = "suno"
+)
+
+var TokenScopeEndpoints = []string{
+	TokenScopeEndpointChat,
+	TokenScopeEndpointResponses,
+	TokenScopeEndpointEmbeddings,
+	TokenScopeEndpointImages,
+	TokenScopeEndpointAudio,
+	TokenScopeEndpointRealtime,
+	TokenScopeEndpointRerank,
+	TokenScopeEndpointModerations,
+	TokenScopeEndpointVideo,
+	TokenScopeEndpointMidjourney,
+	TokenScopeEndpointSuno,
+}
+
+// TokenScope 令牌的权限范围，字段为空或 0 时不限制
+type TokenScope struct {
+	Endpoints []string `json:"endpoints,omitempty"` // 允许访问的端点类型
+	// 文本生成请求（chat / responses）必须指定 max_tokens 且不超过该值
+	MaxTokens int `json:"max_tokens,omitempty"`
+	MaxN      int `json:"max_n,omitempty"` // n（Gemini 为 candidateCount）的上限
+	// 禁止出现在请求体顶层的参数，例如 logit_bias、web_search_options
+	ForbiddenParams []string `json:"forbidden_params,omitempty"`
+	// 禁止使用的工具，按工具的 type 或 name（Gemini 为工具对象的键名）匹配，支持 * 通配符，例如 web_search*
+	ForbiddenTools []string `json:"forbidden_tools,omitempty"`
+	// 单次请求的预估费用上限（额度），按模型价格和 max_tokens 预估
+	MaxRequestQuota int `json:"max_request_quota,omitempty"`
+}
@@ -86,6 +86,11 @@ func main() {
 		go model.SyncChannelCache(common.SyncFrequency)
 	}
 
+	// 渠道定时任务
+	if common.IsMasterNode {
+		go model.StartChannelScheduler()
+	}
+
 	// 热更新配置
 	go model.SyncOptions(common.SyncFrequency)
 
@@ -240,13 +240,14 @@ func TokenAuth() func(c *gin.Context) {
 			return
 		}
 
-		allowIpsMap := token.GetIpLimitsMap()
-		if len(allowIpsMap) != 0 {
-			clientIp := c.ClientIP()
-			if _, ok := allowIpsMap[clientIp]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中")
-				return
-			}
+		clientIp := c.ClientIP()
+		switch token.CheckIpAccess(clientIp) {
+		case common.IPAccessDenied:
+			abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 已被令牌禁止访问")
+			return
+		case common.IPAccessNotAllowed:
+			abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中")
+			return
 		}
 
 		userCache, err := model.GetUserCache(token.UserId)
@@ -259,6 +260,14 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithOpenAiMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		switch userCache.CheckIpAccess(clientIp) {
+		case common.IPAccessDenied:
+			abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 已被用户禁止访问")
+			return
+		case common.IPAccessNotAllowed:
+			abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在用户允许访问的列表中")
+			return
+		}
 
 		userCache.WriteContext(c)
 
@@ -308,6 +317,16 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 		c.Set("token_model_limit_enabled", false)
 	}
 	c.Set("token_group", token.Group)
+	common.SetContextKey(c, constant.ContextKeyTokenConversationLog, token.ConversationLogMode)
+	if budgets, err := model.ParseSpendingBudgets(token.Budgets); err == nil && len(budgets) > 0 {
+		common.SetContextKey(c, constant.ContextKeyTokenBudgets, budgets)
+	}
+	common.SetContextKey(c, constant.ContextKeyTokenRPM, token.RPM)
+	common.SetContextKey(c, constant.ContextKeyTokenTPM, token.TPM)
+	common.SetContextKey(c, constant.ContextKeyTokenMaxConcurrency, token.MaxConcurrency)
+	if scope, err := model.ParseTokenScope(token.Scopes); err == nil && scope != nil {
+		common.SetContextKey(c, constant.ContextKeyTokenScope, scope)
+	}
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
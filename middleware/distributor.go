@@ -16,7 +16,6 @@ import (
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
-	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -36,6 +35,10 @@ func Distribute() func(c *gin.Context) {
 			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
 			return
 		}
+		if err := service.CheckTokenScopeEndpoint(c); err != nil {
+			abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+			return
+		}
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -67,8 +70,7 @@ func Distribute() func(c *gin.Context) {
 				if !ok {
 					tokenModelLimit = map[string]bool{}
 				}
-				matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model) // match gpts & thinking-*
-				if _, ok := tokenModelLimit[matchName]; !ok {
+				if !model.TokenModelLimitAllows(tokenModelLimit, modelRequest.Model) {
 					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
 					return
 				}
@@ -97,7 +99,14 @@ func Distribute() func(c *gin.Context) {
 						userGroup = playgroundRequest.Group
 					}
 				}
-				channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+				// 渠道亲和：同一会话优先使用上次成功的渠道和 key，绑定不可用时按正常逻辑选择
+				if affinityKey := service.GetChannelAffinityKey(c, userGroup, modelRequest.Model); affinityKey != "" {
+					common.SetContextKey(c, constant.ContextKeyChannelAffinityKey, affinityKey)
+					channel, selectGroup = model.CacheGetAffinityChannel(c, affinityKey, userGroup, modelRequest.Model)
+				}
+				if channel == nil {
+					channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0, nil)
+				}
 				if err != nil {
 					showGroup := userGroup
 					if userGroup == "auto" {
@@ -124,6 +133,20 @@ func Distribute() func(c *gin.Context) {
 	}
 }
 
+// getChannelKey 命中渠道亲和时优先使用绑定的 key，该 key 不可用时按渠道的多 key 策略选择
+// 绑定只在首次选择时生效，重试换 key 时不再使用
+func getChannelKey(c *gin.Context, channel *model.Channel) (string, int, *types.NewAPIError) {
+	if affinity, ok := common.GetContextKeyType[*model.ChannelAffinity](c, constant.ContextKeyChannelAffinity); ok && affinity != nil {
+		common.SetContextKey(c, constant.ContextKeyChannelAffinity, (*model.ChannelAffinity)(nil))
+		if affinity.ChannelId == channel.Id {
+			if key, ok := channel.GetEnabledKeyAt(affinity.KeyIndex); ok {
+				return key, affinity.KeyIndex, nil
+			}
+		}
+	}
+	return channel.GetNextEnabledKey()
+}
+
 // getModelFromRequest 从请求中读取模型信息
 // 根据 Content-Type 自动处理：
 // - application/json
@@ -314,7 +337,7 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
+	key, index, newAPIError := getChannelKey(c, channel)
 	if newAPIError != nil {
 		return newAPIError
 	}
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"strings"
 	"sync"
 
@@ -103,14 +104,44 @@ func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
 	return channelQuery, nil
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// getUntriedChannelQuery 未尝试渠道中最高优先级的查询，没有未尝试的渠道时返回 nil
+func getUntriedChannelQuery(group string, model string, excludeChannelIds map[int]bool) (*gorm.DB, error) {
+	excluded := make([]int, 0, len(excludeChannelIds))
+	for channelId := range excludeChannelIds {
+		excluded = append(excluded, channelId)
+	}
+	var priorities []int
+	err := DB.Model(&Ability{}).
+		Select("priority").
+		Where(commonGroupCol+" = ? and model = ? and enabled = ? and channel_id NOT IN ?", group, model, true, excluded).
+		Order("priority DESC").
+		Limit(1).
+		Pluck("priority", &priorities).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(priorities) == 0 {
+		return nil, nil
+	}
+	return DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = ? and channel_id NOT IN ?", group, model, true, priorities[0], excluded), nil
+}
+
+func GetRandomSatisfiedChannel(group string, model string, retry int, excludeChannelIds map[int]bool) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
+	var channelQuery *gorm.DB
+	if len(excludeChannelIds) > 0 {
+		channelQuery, err = getUntriedChannelQuery(group, model, excludeChannelIds)
+	} else {
+		channelQuery, err = getChannelQuery(group, model, retry)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if channelQuery == nil {
+		return nil, nil
+	}
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("weight DESC").Find(&abilities).Error
 	} else {
@@ -119,17 +150,22 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	if err != nil {
 		return nil, err
 	}
+	// 启用成本路由时只在采购价最低的健康渠道中选择，与内存缓存路径一致
+	abilities = cheapestHealthyAbilities(abilities, model)
 	channel := Channel{}
 	if len(abilities) > 0 {
-		// Randomly choose one
-		weightSum := uint(0)
-		for _, ability_ := range abilities {
-			weightSum += ability_.Weight + 10
+		// Randomly choose one，权重按渠道在该模型上的健康分缩放
+		weights := make([]float64, len(abilities))
+		weightSum := 0.0
+		for i, ability_ := range abilities {
+			weights[i] = float64(ability_.Weight+10) * getChannelHealthFactor(ability_.ChannelId, model)
+			weightSum += weights[i]
 		}
 		// Randomly choose one
-		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilities {
-			weight -= int(ability_.Weight) + 10
+		weight := rand.Float64() * weightSum
+		channel.Id = abilities[len(abilities)-1].ChannelId
+		for i, ability_ := range abilities {
+			weight -= weights[i]
 			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
 			if weight <= 0 {
 				channel.Id = ability_.ChannelId
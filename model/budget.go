@@ -0,0 +1,248 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 周期预算的消费按小时（日预算）和按天（周、月预算）分桶累计，统计时对窗口内的桶求和
+// 启用 Redis 时记录在 Redis 中，否则记录在 budget_usages 表中
+
+const (
+	BudgetSubjectToken = "token"
+	BudgetSubjectUser  = "user"
+
+	budgetBucketHour = "h"
+	budgetBucketDay  = "d"
+
+	budgetRedisPrefix = "budget:"
+	// budgetUsageRetention 分桶数据的保留时间，需覆盖最长的统计窗口（自然月）
+	budgetUsageRetention = 32 * 24 * time.Hour
+	budgetRedisTimeout   = 2 * time.Second
+)
+
+// BudgetUsage 数据库中的分桶消费记录
+type BudgetUsage struct {
+	Id          int    `json:"id"`
+	SubjectType string `json:"subject_type" gorm:"type:varchar(8);uniqueIndex:idx_budget_usage_bucket,priority:1"`
+	SubjectId   int    `json:"subject_id" gorm:"uniqueIndex:idx_budget_usage_bucket,priority:2"`
+	Bucket      string `json:"bucket" gorm:"type:varchar(4);uniqueIndex:idx_budget_usage_bucket,priority:3"`
+	BucketStart int64  `json:"bucket_start" gorm:"bigint;uniqueIndex:idx_budget_usage_bucket,priority:4;index"`
+	Used        int64  `json:"used" gorm:"bigint;default:0"`
+}
+
+// BudgetStatus 一个预算在当前窗口内的使用情况
+type BudgetStatus struct {
+	Subject   string `json:"subject"` // token / user
+	Period    string `json:"period"`
+	Rolling   bool   `json:"rolling"`
+	Quota     int    `json:"quota"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+	ResetAt   int64  `json:"reset_at"` // 窗口下一次释放额度的时间
+}
+
+var budgetCleanupOnce sync.Once
+
+// ParseSpendingBudgets 解析令牌或用户上保存的预算配置，空字符串表示未配置
+func ParseSpendingBudgets(value string) ([]dto.SpendingBudget, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var budgets []dto.SpendingBudget
+	if err := common.UnmarshalJsonStr(value, &budgets); err != nil {
+		return nil, fmt.Errorf("预算配置格式错误: %v", err)
+	}
+	periods := make(map[string]bool)
+	for _, budget := range budgets {
+		switch budget.Period {
+		case dto.BudgetPeriodDay, dto.BudgetPeriodWeek, dto.BudgetPeriodMonth:
+		default:
+			return nil, fmt.Errorf("不支持的预算周期: %s", budget.Period)
+		}
+		if budget.Quota <= 0 {
+			return nil, errors.New("预算额度必须大于 0")
+		}
+		if periods[budget.Period] {
+			return nil, fmt.Errorf("预算周期重复: %s", budget.Period)
+		}
+		periods[budget.Period] = true
+	}
+	return budgets, nil
+}
+
+// budgetWindow 返回预算使用的分桶粒度、窗口起点和下一次释放额度的时间
+func budgetWindow(budget dto.SpendingBudget, now time.Time) (bucket string, from time.Time, resetAt time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if budget.Period == dto.BudgetPeriodDay {
+		hour := now.Truncate(time.Hour)
+		if budget.Rolling {
+			return budgetBucketHour, hour.Add(-23 * time.Hour), hour.Add(time.Hour)
+		}
+		return budgetBucketHour, today, today.AddDate(0, 0, 1)
+	}
+	if budget.Rolling {
+		days := 7
+		if budget.Period == dto.BudgetPeriodMonth {
+			days = 30
+		}
+		return budgetBucketDay, today.AddDate(0, 0, 1-days), today.AddDate(0, 0, 1)
+	}
+	if budget.Period == dto.BudgetPeriodWeek {
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return budgetBucketDay, monday, monday.AddDate(0, 0, 7)
+	}
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return budgetBucketDay, month, month.AddDate(0, 1, 0)
+}
+
+// budgetBucketStarts 窗口内所有分桶的起点，按天分桶时使用本地零点
+func budgetBucketStarts(bucket string, from time.Time, now time.Time) []int64 {
+	var starts []int64
+	for t := from; !t.After(now); {
+		starts = append(starts, t.Unix())
+		if bucket == budgetBucketHour {
+			t = t.Add(time.Hour)
+		} else {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return starts
+}
+
+func budgetCurrentBucketStart(bucket string, now time.Time) int64 {
+	if bucket == budgetBucketHour {
+		return now.Truncate(time.Hour).Unix()
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+}
+
+func budgetRedisKey(subject string, subjectId int, bucket string, start int64) string {
+	return budgetRedisPrefix + subject + ":" + strconv.Itoa(subjectId) + ":" + bucket + ":" + strconv.FormatInt(start, 10)
+}
+
+// GetBudgetStatuses 计算各预算在当前窗口内的使用情况
+func GetBudgetStatuses(subject string, subjectId int, budgets []dto.SpendingBudget) ([]BudgetStatus, error) {
+	if len(budgets) == 0 || subjectId <= 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	statuses := make([]BudgetStatus, 0, len(budgets))
+	for _, budget := range budgets {
+		bucket, from, resetAt := budgetWindow(budget, now)
+		used, err := sumBudgetUsage(subject, subjectId, bucket, from, now)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, BudgetStatus{
+			Subject:   subject,
+			Period:    budget.Period,
+			Rolling:   budget.Rolling,
+			Quota:     budget.Quota,
+			Used:      used,
+			Remaining: int64(budget.Quota) - used,
+			ResetAt:   resetAt.Unix(),
+		})
+	}
+	return statuses, nil
+}
+
+func sumBudgetUsage(subject string, subjectId int, bucket string, from time.Time, now time.Time) (int64, error) {
+	if common.RedisEnabled {
+		starts := budgetBucketStarts(bucket, from, now)
+		keys := make([]string, len(starts))
+		for i, start := range starts {
+			keys[i] = budgetRedisKey(subject, subjectId, bucket, start)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), budgetRedisTimeout)
+		defer cancel()
+		values, err := common.RDB.MGet(ctx, keys...).Result()
+		if err != nil && err != redis.Nil {
+			return 0, err
+		}
+		var used int64
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				n, _ := strconv.ParseInt(s, 10, 64)
+				used += n
+			}
+		}
+		return used, nil
+	}
+	var used int64
+	err := DB.Model(&BudgetUsage{}).
+		Select("coalesce(sum(used), 0)").
+		Where("subject_type = ? and subject_id = ? and bucket = ? and bucket_start >= ?", subject, subjectId, bucket, from.Unix()).
+		Scan(&used).Error
+	return used, err
+}
+
+// RecordBudgetSpend 累计一次消费，quota 为负数时表示退还；只记录预算用到的分桶粒度
+func RecordBudgetSpend(subject string, subjectId int, budgets []dto.SpendingBudget, quota int) error {
+	if len(budgets) == 0 || subjectId <= 0 || quota == 0 {
+		return nil
+	}
+	needBuckets := make(map[string]bool, 2)
+	for _, budget := range budgets {
+		if budget.Period == dto.BudgetPeriodDay {
+			needBuckets[budgetBucketHour] = true
+		} else {
+			needBuckets[budgetBucketDay] = true
+		}
+	}
+	now := time.Now()
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), budgetRedisTimeout)
+		defer cancel()
+		pipe := common.RDB.TxPipeline()
+		for bucket := range needBuckets {
+			key := budgetRedisKey(subject, subjectId, bucket, budgetCurrentBucketStart(bucket, now))
+			pipe.IncrBy(ctx, key, int64(quota))
+			pipe.Expire(ctx, key, budgetUsageRetention)
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+
+	budgetCleanupOnce.Do(func() {
+		go cleanupBudgetUsage()
+	})
+	for bucket := range needBuckets {
+		usage := BudgetUsage{
+			SubjectType: subject,
+			SubjectId:   subjectId,
+			Bucket:      bucket,
+			BucketStart: budgetCurrentBucketStart(bucket, now),
+			Used:        int64(quota),
+		}
+		err := DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "subject_type"}, {Name: "subject_id"}, {Name: "bucket"}, {Name: "bucket_start"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"used": gorm.Expr("budget_usages.used + ?", quota)}),
+		}).Create(&usage).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupBudgetUsage 定期删除超出保留时间的分桶
+func cleanupBudgetUsage() {
+	for {
+		before := time.Now().Add(-budgetUsageRetention).Unix()
+		if err := DB.Where("bucket_start < ?", before).Delete(&BudgetUsage{}).Error; err != nil {
+			common.SysError("failed to cleanup budget usage: " + err.Error())
+		}
+		time.Sleep(time.Hour)
+	}
+}
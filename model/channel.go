@@ -55,6 +55,8 @@ type Channel struct {
 
 	// cache info
 	Keys []string `json:"-" gorm:"-"`
+	// 渠道配置了并发、RPM 或 TPM 上限时的当前用量，仅在渠道列表中返回
+	Utilization *ChannelUtilization `json:"utilization,omitempty" gorm:"-"`
 }
 
 type ChannelInfo struct {
@@ -65,6 +67,8 @@ type ChannelInfo struct {
 	MultiKeyDisabledTime   map[int]int64         `json:"multi_key_disabled_time,omitempty"`   // key禁用时间列表，key index -> time
 	MultiKeyPollingIndex   int                   `json:"multi_key_polling_index"`             // 多Key模式下轮询的key索引
 	MultiKeyMode           constant.MultiKeyMode `json:"multi_key_mode"`
+	MultiKeyRPM            int                   `json:"multi_key_rpm,omitempty"` // 余量模式下每个key的每分钟请求数预算，0表示不限制
+	MultiKeyTPM            int                   `json:"multi_key_tpm,omitempty"` // 余量模式下每个key的每分钟token数预算，0表示不限制
 }
 
 // Value implements driver.Valuer interface
@@ -181,12 +185,42 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		// Fallback – should not happen, but return first enabled key
 		return keys[enabledIdx[0]], enabledIdx[0], nil
+	case constant.MultiKeyModeHeadroom:
+		// Pick the key with the most remaining rate limit budget, skipping keys in cooldown
+		selectedIdx := selectHeadroomKey(channel.Id, enabledIdx, channel.ChannelInfo.MultiKeyRPM, channel.ChannelInfo.MultiKeyTPM)
+		return keys[selectedIdx], selectedIdx, nil
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
 		return keys[enabledIdx[0]], enabledIdx[0], nil
 	}
 }
 
+// GetEnabledKeyAt 返回指定索引的 key，仅在 key 启用且未处于限流冷却时可用，用于渠道亲和固定 key
+func (channel *Channel) GetEnabledKeyAt(index int) (string, bool) {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.Key, true
+	}
+	keys := channel.GetKeys()
+	if index < 0 || index >= len(keys) {
+		return "", false
+	}
+
+	lock := GetChannelPollingLock(channel.Id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[index]; ok && status != common.ChannelStatusEnabled {
+		return "", false
+	}
+	if IsChannelKeyCoolingDown(channel.Id, index) {
+		return "", false
+	}
+	if channel.ChannelInfo.MultiKeyMode == constant.MultiKeyModeHeadroom {
+		recordChannelKeyRequest(channel.Id, index)
+	}
+	return keys[index], true
+}
+
 func (channel *Channel) SaveChannelInfo() error {
 	return DB.Model(channel).Update("channel_info", channel.ChannelInfo).Error
 }
@@ -379,7 +413,7 @@ func BatchDeleteChannels(ids []int) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	// 使用事务 分批删除channel表和abilities表
+	// 使用事务 分批删除channel表、abilities表和渠道定时任务
 	tx := DB.Begin()
 	if tx.Error != nil {
 		return tx.Error
@@ -393,6 +427,10 @@ func BatchDeleteChannels(ids []int) error {
 			tx.Rollback()
 			return err
 		}
+		if err := tx.Where("channel_id in (?)", chunk).Delete(&ChannelSchedule{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 	return tx.Commit().Error
 }
@@ -522,7 +560,10 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	return DB.Where("channel_id = ?", channel.Id).Delete(&ChannelSchedule{}).Error
 }
 
 var channelStatusLock sync.Mutex
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelAffinityRedisPrefix Redis 中渠道亲和绑定的 key 前缀
+const channelAffinityRedisPrefix = "channel_affinity:"
+
+// channelAffinitySweepInterval 内存模式下清理过期绑定的最小间隔
+const channelAffinitySweepInterval = time.Minute
+
+// ChannelAffinity 会话绑定的渠道和 key
+type ChannelAffinity struct {
+	ChannelId int    `json:"channel_id"`
+	KeyIndex  int    `json:"key_index"`
+	Group     string `json:"group"` // 实际选中的分组，auto 分组下为具体分组
+}
+
+type channelAffinityEntry struct {
+	affinity ChannelAffinity
+	expireAt time.Time
+}
+
+var (
+	channelAffinityLock      sync.Mutex
+	channelAffinityStore     = make(map[string]channelAffinityEntry)
+	channelAffinityLastSweep time.Time
+)
+
+func channelAffinityTTL() time.Duration {
+	ttl := operation_setting.GetChannelAffinitySetting().TTLSeconds
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// GetChannelAffinity 读取会话绑定，启用 Redis 时多实例共享
+func GetChannelAffinity(key string) (*ChannelAffinity, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(channelAffinityRedisPrefix + key)
+		if err != nil || value == "" {
+			return nil, false
+		}
+		var affinity ChannelAffinity
+		if err := common.UnmarshalJsonStr(value, &affinity); err != nil {
+			return nil, false
+		}
+		return &affinity, true
+	}
+	channelAffinityLock.Lock()
+	defer channelAffinityLock.Unlock()
+	entry, ok := channelAffinityStore[key]
+	if !ok || time.Now().After(entry.expireAt) {
+		return nil, false
+	}
+	affinity := entry.affinity
+	return &affinity, true
+}
+
+// SetChannelAffinity 保存或续期会话绑定
+func SetChannelAffinity(key string, affinity ChannelAffinity) {
+	ttl := channelAffinityTTL()
+	if common.RedisEnabled {
+		value, err := common.Marshal(affinity)
+		if err != nil {
+			return
+		}
+		if err := common.RedisSet(channelAffinityRedisPrefix+key, string(value), ttl); err != nil {
+			common.SysError("failed to save channel affinity: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	channelAffinityLock.Lock()
+	defer channelAffinityLock.Unlock()
+	if now.Sub(channelAffinityLastSweep) >= channelAffinitySweepInterval {
+		for k, entry := range channelAffinityStore {
+			if now.After(entry.expireAt) {
+				delete(channelAffinityStore, k)
+			}
+		}
+		channelAffinityLastSweep = now
+	}
+	channelAffinityStore[key] = channelAffinityEntry{affinity: affinity, expireAt: now.Add(ttl)}
+}
+
+// channelServesModel 渠道当前是否在分组下启用了该模型
+func channelServesModel(group string, modelName string, channelId int) bool {
+	if !common.MemoryCacheEnabled {
+		var count int64
+		err := DB.Model(&Ability{}).
+			Where(commonGroupCol+" = ? and model IN ? and channel_id = ? and enabled = ?", group, []string{modelName, ratio_setting.FormatMatchingModelName(modelName)}, channelId, true).
+			Count(&count).Error
+		return err == nil && count > 0
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	channels := group2model2channels[group][modelName]
+	if len(channels) == 0 {
+		channels = group2model2channels[group][ratio_setting.FormatMatchingModelName(modelName)]
+	}
+	return slices.Contains(channels, channelId)
+}
+
+// CacheGetAffinityChannel 返回会话绑定的渠道，绑定不存在或渠道已不可用（禁用、不再提供该模型、熔断、健康分过低、用量达到上限）时返回 nil，
+// 由调用方按正常逻辑重新选择。命中时将绑定写入上下文，供选择 key 时使用
+func CacheGetAffinityChannel(c *gin.Context, key string, group string, modelName string) (*Channel, string) {
+	affinity, ok := GetChannelAffinity(key)
+	if !ok {
+		return nil, group
+	}
+	if group == "auto" {
+		if !slices.Contains(setting.AutoGroups, affinity.Group) {
+			return nil, group
+		}
+	} else if affinity.Group != group {
+		return nil, group
+	}
+	channel, err := CacheGetChannel(affinity.ChannelId)
+	if err != nil || channel.Status != common.ChannelStatusEnabled {
+		return nil, group
+	}
+	if !channelServesModel(affinity.Group, modelName, channel.Id) {
+		return nil, group
+	}
+	if getUnavailableCircuitChannelIds(modelName)[channel.Id] {
+		return nil, group
+	}
+	if getChannelHealthFactor(channel.Id, modelName) < operation_setting.GetChannelAffinitySetting().MinHealthScore {
+		return nil, group
+	}
+	if isChannelSaturated(channel) {
+		return nil, group
+	}
+	acquireChannelCircuit(channel.Id, modelName)
+	if group == "auto" {
+		c.Set("auto_group", affinity.Group)
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelAffinity, affinity)
+	return channel, affinity.Group
+}
@@ -85,6 +85,7 @@ func InitChannelCache() {
 	}
 	channelsIDM = newChannelId2channel
 	channelSyncLock.Unlock()
+	refreshChannelLimits(newChannelId2channel)
 	common.SysLog("channels synced from database")
 }
 
@@ -96,7 +97,42 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
+// CacheGetRandomSatisfiedChannel 按优先级和权重选择渠道
+// excludeChannelIds 为本次请求已尝试过的渠道：优先在仍有可用渠道的最高优先级中选择，全部排除后再按 retry 对应的优先级重新选择。
+// 熔断中的渠道，以及并发、RPM 或 TPM 已达上限的渠道与禁用渠道一样始终跳过，重新选择时也不放开
+func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int, excludeChannelIds map[int]bool) (*Channel, string, error) {
+	alwaysExcluded := getSaturatedChannelIds()
+	if unavailable := getUnavailableCircuitChannelIds(model); len(unavailable) > 0 {
+		merged := make(map[int]bool, len(alwaysExcluded)+len(unavailable))
+		for channelId := range alwaysExcluded {
+			merged[channelId] = true
+		}
+		for channelId := range unavailable {
+			merged[channelId] = true
+		}
+		alwaysExcluded = merged
+	}
+	if len(alwaysExcluded) > 0 {
+		merged := make(map[int]bool, len(excludeChannelIds)+len(alwaysExcluded))
+		for channelId := range excludeChannelIds {
+			merged[channelId] = true
+		}
+		for channelId := range alwaysExcluded {
+			merged[channelId] = true
+		}
+		excludeChannelIds = merged
+	}
+	channel, selectGroup, err := cacheGetRandomSatisfiedChannel(c, group, model, retry, excludeChannelIds)
+	if err == nil && channel == nil && len(excludeChannelIds) > len(alwaysExcluded) {
+		channel, selectGroup, err = cacheGetRandomSatisfiedChannel(c, group, model, retry, alwaysExcluded)
+	}
+	if channel != nil {
+		acquireChannelCircuit(channel.Id, model)
+	}
+	return channel, selectGroup, err
+}
+
+func cacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int, excludeChannelIds map[int]bool) (*Channel, string, error) {
 	var channel *Channel
 	var err error
 	selectGroup := group
@@ -108,7 +144,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			if common.DebugEnabled {
 				println("autoGroup:", autoGroup)
 			}
-			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry)
+			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry, excludeChannelIds)
 			if channel == nil {
 				continue
 			} else {
@@ -121,7 +157,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			}
 		}
 	} else {
-		channel, err = getRandomSatisfiedChannel(group, model, retry)
+		channel, err = getRandomSatisfiedChannel(group, model, retry, excludeChannelIds)
 		if err != nil {
 			return nil, group, err
 		}
@@ -129,10 +165,10 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 	return channel, selectGroup, nil
 }
 
-func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func getRandomSatisfiedChannel(group string, model string, retry int, excludeChannelIds map[int]bool) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return GetRandomSatisfiedChannel(group, model, retry, excludeChannelIds)
 	}
 
 	channelSyncLock.RLock()
@@ -151,6 +187,10 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, nil
 	}
 
+	if len(excludeChannelIds) > 0 {
+		return getUntriedSatisfiedChannel(channels, model, excludeChannelIds)
+	}
+
 	if len(channels) == 1 {
 		if channel, ok := channelsIDM[channels[0]]; ok {
 			return channel, nil
@@ -189,23 +229,61 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		}
 	}
 
+	return weightedRandomChannel(targetChannels, model)
+}
+
+// getUntriedSatisfiedChannel 在仍有未尝试渠道的最高优先级中按权重选择，全部尝试过时返回 nil
+// channels 已按优先级降序排列，调用方需持有 channelSyncLock
+func getUntriedSatisfiedChannel(channels []int, model string, excludeChannelIds map[int]bool) (*Channel, error) {
+	var targetChannels []*Channel
+	for _, channelId := range channels {
+		channel, ok := channelsIDM[channelId]
+		if !ok {
+			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+		}
+		if excludeChannelIds[channelId] {
+			continue
+		}
+		if len(targetChannels) > 0 && channel.GetPriority() != targetChannels[0].GetPriority() {
+			break
+		}
+		targetChannels = append(targetChannels, channel)
+	}
+	if len(targetChannels) == 0 {
+		return nil, nil
+	}
+	return weightedRandomChannel(targetChannels, model)
+}
+
+// weightedRandomChannel 按权重随机选择一个渠道，权重按渠道在该模型上的健康分缩放
+// 启用成本路由时只在采购价最低的健康渠道中选择
+func weightedRandomChannel(targetChannels []*Channel, model string) (*Channel, error) {
+	if cheapest := cheapestHealthyChannels(targetChannels, model); len(cheapest) > 0 {
+		targetChannels = cheapest
+	}
 	// 平滑系数
 	smoothingFactor := 10
 	// Calculate the total weight of all channels up to endIdx
-	totalWeight := 0
-	for _, channel := range targetChannels {
-		totalWeight += channel.GetWeight() + smoothingFactor
+	weights := make([]float64, len(targetChannels))
+	totalWeight := 0.0
+	for i, channel := range targetChannels {
+		weights[i] = float64(channel.GetWeight()+smoothingFactor) * getChannelHealthFactor(channel.Id, model)
+		totalWeight += weights[i]
 	}
 	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
+	randomWeight := rand.Float64() * totalWeight
 
 	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight() + smoothingFactor
+	for i, channel := range targetChannels {
+		randomWeight -= weights[i]
 		if randomWeight < 0 {
 			return channel, nil
 		}
 	}
+	// 浮点误差导致未命中时取最后一个
+	if len(targetChannels) > 0 {
+		return targetChannels[len(targetChannels)-1], nil
+	}
 	// return null if no channel is not found
 	return nil, errors.New("channel not found")
 }
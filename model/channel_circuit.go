@@ -0,0 +1,200 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// 熔断器状态
+const (
+	ChannelCircuitClosed   = "closed"    // 正常
+	ChannelCircuitOpen     = "open"      // 熔断中，不参与渠道选择
+	ChannelCircuitHalfOpen = "half_open" // 冷却结束，按间隔放行试探请求
+)
+
+// channelCircuit 单个 (渠道, 模型) 的熔断状态，时间均为毫秒时间戳
+type channelCircuit struct {
+	state       string
+	failures    []int64 // 统计窗口内的失败时间
+	openedAt    int64
+	nextProbeAt int64
+}
+
+var (
+	channelCircuitLock sync.Mutex
+	// model -> channel id -> 熔断状态，只保存在本实例内存中
+	channelCircuits = make(map[string]map[int]*channelCircuit)
+)
+
+func isChannelCircuitBreakerEnabled() bool {
+	return operation_setting.GetChannelCircuitBreakerSetting().Enabled
+}
+
+// refresh 熔断冷却结束后转为半开
+func (b *channelCircuit) refresh(now int64, setting *operation_setting.ChannelCircuitBreakerSetting) {
+	if b.state == ChannelCircuitOpen && now >= b.openedAt+int64(setting.CooldownSeconds)*1000 {
+		b.state = ChannelCircuitHalfOpen
+		b.nextProbeAt = now
+	}
+}
+
+// available 当前是否可以选择该渠道，半开状态只在到达试探时间时可选
+func (b *channelCircuit) available(now int64) bool {
+	switch b.state {
+	case ChannelCircuitOpen:
+		return false
+	case ChannelCircuitHalfOpen:
+		return now >= b.nextProbeAt
+	}
+	return true
+}
+
+func (b *channelCircuit) open(now int64) {
+	b.state = ChannelCircuitOpen
+	b.openedAt = now
+	b.failures = nil
+}
+
+// RecordChannelCircuitResult 记录一次转发结果，返回状态发生变化时的前后状态，未变化时 from == to
+func RecordChannelCircuitResult(channelId int, modelName string, success bool) (from string, to string) {
+	setting := operation_setting.GetChannelCircuitBreakerSetting()
+	if !setting.Enabled || channelId <= 0 {
+		return ChannelCircuitClosed, ChannelCircuitClosed
+	}
+	now := time.Now().UnixMilli()
+
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	circuits, ok := channelCircuits[modelName]
+	if !ok {
+		circuits = make(map[int]*channelCircuit)
+		channelCircuits[modelName] = circuits
+	}
+	circuit, ok := circuits[channelId]
+	if !ok {
+		if success {
+			return ChannelCircuitClosed, ChannelCircuitClosed
+		}
+		circuit = &channelCircuit{state: ChannelCircuitClosed}
+		circuits[channelId] = circuit
+	}
+	circuit.refresh(now, setting)
+	from = circuit.state
+
+	switch {
+	case success && circuit.state != ChannelCircuitClosed:
+		// 试探成功，恢复正常
+		circuit.state = ChannelCircuitClosed
+		circuit.failures = nil
+	case success:
+		// 正常状态下的成功不清空窗口，窗口内失败次数仍按时间淘汰
+	case circuit.state == ChannelCircuitHalfOpen:
+		circuit.open(now)
+	case circuit.state == ChannelCircuitClosed:
+		windowStart := now - int64(setting.WindowSeconds)*1000
+		failures := circuit.failures[:0]
+		for _, failedAt := range circuit.failures {
+			if failedAt > windowStart {
+				failures = append(failures, failedAt)
+			}
+		}
+		circuit.failures = append(failures, now)
+		if len(circuit.failures) >= max(setting.FailureThreshold, 1) {
+			circuit.open(now)
+		}
+	}
+	if circuit.state == ChannelCircuitClosed && len(circuit.failures) == 0 {
+		delete(circuits, channelId)
+	}
+	return from, circuit.state
+}
+
+// getUnavailableCircuitChannelIds 返回模型下熔断中（含半开但未到试探时间）的渠道
+func getUnavailableCircuitChannelIds(modelName string) map[int]bool {
+	if !isChannelCircuitBreakerEnabled() {
+		return nil
+	}
+	setting := operation_setting.GetChannelCircuitBreakerSetting()
+	now := time.Now().UnixMilli()
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	var unavailable map[int]bool
+	for channelId, circuit := range channelCircuits[modelName] {
+		circuit.refresh(now, setting)
+		if !circuit.available(now) {
+			if unavailable == nil {
+				unavailable = make(map[int]bool)
+			}
+			unavailable[channelId] = true
+		}
+	}
+	return unavailable
+}
+
+// acquireChannelCircuit 选中半开状态的渠道时占用本次试探机会，下一个试探需等待 ProbeIntervalSeconds
+func acquireChannelCircuit(channelId int, modelName string) {
+	if !isChannelCircuitBreakerEnabled() {
+		return
+	}
+	setting := operation_setting.GetChannelCircuitBreakerSetting()
+	now := time.Now().UnixMilli()
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	if circuit, ok := channelCircuits[modelName][channelId]; ok && circuit.state == ChannelCircuitHalfOpen && now >= circuit.nextProbeAt {
+		circuit.nextProbeAt = now + int64(setting.ProbeIntervalSeconds)*1000
+	}
+}
+
+// ChannelCircuit 渠道在某个模型上的熔断状态
+type ChannelCircuit struct {
+	ChannelId   int    `json:"channel_id"`
+	Model       string `json:"model"`
+	State       string `json:"state"`
+	Failures    int    `json:"failures"` // 正常状态下统计窗口内的失败次数
+	OpenedAt    int64  `json:"opened_at"`
+	NextProbeAt int64  `json:"next_probe_at"`
+}
+
+// GetChannelCircuitList 返回非正常状态或窗口内有失败记录的熔断器，channelId 为 0 时返回全部渠道
+func GetChannelCircuitList(channelId int) []ChannelCircuit {
+	setting := operation_setting.GetChannelCircuitBreakerSetting()
+	now := time.Now().UnixMilli()
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	result := make([]ChannelCircuit, 0)
+	for modelName, circuits := range channelCircuits {
+		for id, circuit := range circuits {
+			if channelId > 0 && id != channelId {
+				continue
+			}
+			circuit.refresh(now, setting)
+			result = append(result, ChannelCircuit{
+				ChannelId:   id,
+				Model:       modelName,
+				State:       circuit.state,
+				Failures:    len(circuit.failures),
+				OpenedAt:    circuit.openedAt / 1000,
+				NextProbeAt: circuit.nextProbeAt / 1000,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ChannelId != result[j].ChannelId {
+			return result[i].ChannelId < result[j].ChannelId
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
+
+// ResetChannelCircuit 手动恢复渠道在所有模型上的熔断状态
+func ResetChannelCircuit(channelId int) {
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	for _, circuits := range channelCircuits {
+		delete(circuits, channelId)
+	}
+}
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"math"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+)
+
+// channelCostEntry 解析后的渠道采购价，settings 变化时重新解析
+type channelCostEntry struct {
+	settings string
+	prices   map[string]dto.UpstreamPrice
+}
+
+var (
+	channelCostLock  sync.Mutex
+	channelCostCache = make(map[int]*channelCostEntry)
+)
+
+// getChannelUpstreamPrice 返回渠道在模型上的采购价，依次匹配模型名、归一化的模型名和 "*"
+func getChannelUpstreamPrice(channel *Channel, modelName string) (dto.UpstreamPrice, bool) {
+	channelCostLock.Lock()
+	entry, ok := channelCostCache[channel.Id]
+	if !ok || entry.settings != channel.OtherSettings {
+		entry = &channelCostEntry{settings: channel.OtherSettings}
+		if channel.OtherSettings != "" {
+			var settings dto.ChannelOtherSettings
+			if err := common.UnmarshalJsonStr(channel.OtherSettings, &settings); err == nil {
+				entry.prices = settings.UpstreamPrices
+			}
+		}
+		channelCostCache[channel.Id] = entry
+	}
+	channelCostLock.Unlock()
+
+	if len(entry.prices) == 0 {
+		return dto.UpstreamPrice{}, false
+	}
+	for _, name := range []string{modelName, ratio_setting.FormatMatchingModelName(modelName), "*"} {
+		if price, ok := entry.prices[name]; ok {
+			return price, true
+		}
+	}
+	return dto.UpstreamPrice{}, false
+}
+
+// upstreamRequestCost 按参考 token 数估算一次请求的美元成本，按 token 计价和按次计价的渠道统一按该值比较
+func upstreamRequestCost(price dto.UpstreamPrice, promptTokens int, completionTokens int) float64 {
+	return float64(promptTokens)*price.Input/1000000 + float64(completionTokens)*price.Output/1000000 + price.Request
+}
+
+// cheapestHealthyChannels 成本路由启用时返回候选渠道中采购价最低的健康渠道，没有符合条件的渠道时返回 nil
+// 未配置采购价的渠道无法比价，与最低价渠道一起按正常权重参与选择
+func cheapestHealthyChannels(channels []*Channel, modelName string) []*Channel {
+	setting := operation_setting.GetChannelCostRoutingSetting()
+	if !setting.Enabled || len(channels) < 2 {
+		return nil
+	}
+	var cheapest []*Channel
+	var unpriced []*Channel
+	lowest := math.MaxFloat64
+	for _, channel := range channels {
+		price, ok := getChannelUpstreamPrice(channel, modelName)
+		if !ok {
+			unpriced = append(unpriced, channel)
+			continue
+		}
+		if getChannelHealthFactor(channel.Id, modelName) < setting.MinHealthScore {
+			continue
+		}
+		cost := upstreamRequestCost(price, setting.ReferencePromptTokens, setting.ReferenceCompletionTokens)
+		switch {
+		case cost < lowest-1e-9:
+			lowest = cost
+			cheapest = append(cheapest[:0], channel)
+		case math.Abs(cost-lowest) <= 1e-9:
+			cheapest = append(cheapest, channel)
+		}
+	}
+	if len(cheapest) == 0 {
+		return nil
+	}
+	return append(cheapest, unpriced...)
+}
+
+// cheapestHealthyAbilities 未启用内存缓存时的成本路由，从数据库读取同一优先级渠道的采购价后按 cheapestHealthyChannels 筛选
+// 没有符合条件的渠道时原样返回
+func cheapestHealthyAbilities(abilities []Ability, modelName string) []Ability {
+	if !operation_setting.GetChannelCostRoutingSetting().Enabled || len(abilities) < 2 {
+		return abilities
+	}
+	channelIds := make([]int, 0, len(abilities))
+	for _, ability := range abilities {
+		channelIds = append(channelIds, ability.ChannelId)
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "settings").Where("id IN ?", channelIds).Find(&channels).Error; err != nil {
+		common.SysError("failed to load channel prices for cost routing: " + err.Error())
+		return abilities
+	}
+	cheapest := cheapestHealthyChannels(channels, modelName)
+	if len(cheapest) == 0 {
+		return abilities
+	}
+	selected := make(map[int]bool, len(cheapest))
+	for _, channel := range cheapest {
+		selected[channel.Id] = true
+	}
+	filtered := make([]Ability, 0, len(cheapest))
+	for _, ability := range abilities {
+		if selected[ability.ChannelId] {
+			filtered = append(filtered, ability)
+		}
+	}
+	return filtered
+}
+
+// GetChannelUpstreamCost 按渠道采购价估算一次请求的上游成本（额度单位），渠道未配置采购价时返回 0
+func GetChannelUpstreamCost(channelId int, modelName string, promptTokens int, completionTokens int) int {
+	if channelId <= 0 {
+		return 0
+	}
+	channel, err := CacheGetChannel(channelId)
+	if err != nil || channel == nil {
+		return 0
+	}
+	price, ok := getChannelUpstreamPrice(channel, modelName)
+	if !ok {
+		return 0
+	}
+	return int(math.Round(upstreamRequestCost(price, promptTokens, completionTokens) * common.QuotaPerUnit))
+}
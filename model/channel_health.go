@@ -0,0 +1,393 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/go-redis/redis/v8"
+)
+
+// channelHealthRedisPrefix Redis 中健康统计的 key 前缀，完整 key 为 channel_health:<channel_id>:<model>
+const channelHealthRedisPrefix = "channel_health:"
+
+// channelHealthSyncInterval 从 Redis 同步其他实例统计数据的间隔
+const channelHealthSyncInterval = 5 * time.Second
+
+// channelHealthStats 按半衰期衰减的统计量，更新前先按距上次更新的时间整体衰减
+type channelHealthStats struct {
+	Success    float64
+	Failure    float64
+	LatencySum float64 // 总延迟（毫秒）加权和
+	LatencyW   float64
+	TTFTSum    float64 // 流式请求首字延迟（毫秒）加权和
+	TTFTW      float64
+	UpdatedAt  int64 // 毫秒时间戳
+}
+
+func (s *channelHealthStats) decay(now int64, halfLife float64) {
+	if s.UpdatedAt > 0 && now > s.UpdatedAt {
+		factor := math.Pow(0.5, float64(now-s.UpdatedAt)/1000/halfLife)
+		s.Success *= factor
+		s.Failure *= factor
+		s.LatencySum *= factor
+		s.LatencyW *= factor
+		s.TTFTSum *= factor
+		s.TTFTW *= factor
+	}
+	if now > s.UpdatedAt {
+		s.UpdatedAt = now
+	}
+}
+
+var (
+	channelHealthLock  sync.RWMutex
+	channelHealthStore = make(map[string]*channelHealthStats)
+	channelHealthSync  sync.Once
+)
+
+// channelHealthScript 在 Redis 中原子地衰减并累加一次结果
+// ARGV: now(ms), half_life(s), success(0/1), latency(ms), ttft(ms，0 表示无)
+var channelHealthScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local halfLife = tonumber(ARGV[2])
+local fields = {"success", "failure", "latency_sum", "latency_w", "ttft_sum", "ttft_w"}
+local values = redis.call("HMGET", key, "success", "failure", "latency_sum", "latency_w", "ttft_sum", "ttft_w", "updated_at")
+local updatedAt = tonumber(values[7]) or now
+local factor = 1
+if now > updatedAt then
+	factor = math.pow(0.5, (now - updatedAt) / 1000 / halfLife)
+else
+	now = updatedAt
+end
+local stats = {}
+for i = 1, 6 do
+	stats[i] = (tonumber(values[i]) or 0) * factor
+end
+if ARGV[3] == "1" then
+	stats[1] = stats[1] + 1
+else
+	stats[2] = stats[2] + 1
+end
+local latency = tonumber(ARGV[4])
+if latency > 0 then
+	stats[3] = stats[3] + latency
+	stats[4] = stats[4] + 1
+end
+local ttft = tonumber(ARGV[5])
+if ttft > 0 then
+	stats[5] = stats[5] + ttft
+	stats[6] = stats[6] + 1
+end
+for i = 1, 6 do
+	redis.call("HSET", key, fields[i], tostring(stats[i]))
+end
+redis.call("HSET", key, "updated_at", tostring(now))
+redis.call("EXPIRE", key, math.ceil(halfLife * 10))
+return 1
+`)
+
+func channelHealthKey(channelId int, modelName string) string {
+	return strconv.Itoa(channelId) + ":" + modelName
+}
+
+func channelHealthHalfLife() float64 {
+	halfLife := operation_setting.GetChannelHealthSetting().HalfLifeSeconds
+	if halfLife <= 0 {
+		halfLife = 300
+	}
+	return float64(halfLife)
+}
+
+// RecordChannelHealth 记录一次转发结果，latency 为本次尝试的总耗时，firstToken 为流式首字耗时（非流式传 0）
+func RecordChannelHealth(channelId int, modelName string, success bool, latency time.Duration, firstToken time.Duration) {
+	if channelId <= 0 || modelName == "" {
+		return
+	}
+	now := time.Now().UnixMilli()
+	halfLife := channelHealthHalfLife()
+	key := channelHealthKey(channelId, modelName)
+
+	channelHealthLock.Lock()
+	stats, ok := channelHealthStore[key]
+	if !ok {
+		stats = &channelHealthStats{}
+		channelHealthStore[key] = stats
+	}
+	stats.decay(now, halfLife)
+	if success {
+		stats.Success++
+	} else {
+		stats.Failure++
+	}
+	if latency > 0 {
+		stats.LatencySum += float64(latency.Milliseconds())
+		stats.LatencyW++
+	}
+	if firstToken > 0 {
+		stats.TTFTSum += float64(firstToken.Milliseconds())
+		stats.TTFTW++
+	}
+	channelHealthLock.Unlock()
+
+	if common.RedisEnabled {
+		startChannelHealthSync()
+		successArg := "0"
+		if success {
+			successArg = "1"
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			err := channelHealthScript.Run(ctx, common.RDB, []string{channelHealthRedisPrefix + key},
+				now, halfLife, successArg, latency.Milliseconds(), firstToken.Milliseconds()).Err()
+			if err != nil {
+				common.SysError("failed to record channel health: " + err.Error())
+			}
+		}()
+	}
+}
+
+// startChannelHealthSync 定期从 Redis 拉取本实例关注的统计，使多实例共享同一份健康分
+func startChannelHealthSync() {
+	channelHealthSync.Do(func() {
+		go func() {
+			for {
+				time.Sleep(channelHealthSyncInterval)
+				syncChannelHealthFromRedis()
+			}
+		}()
+	})
+}
+
+func syncChannelHealthFromRedis() {
+	channelHealthLock.RLock()
+	keys := make([]string, 0, len(channelHealthStore))
+	for key := range channelHealthStore {
+		keys = append(keys, key)
+	}
+	channelHealthLock.RUnlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	remote, err := loadChannelHealthFromRedis(ctx, keys)
+	if err != nil {
+		common.SysError("failed to sync channel health: " + err.Error())
+		return
+	}
+	channelHealthLock.Lock()
+	for _, key := range keys {
+		// Redis 中已过期或被重置的统计在本地同样清空
+		if stats, ok := remote[key]; ok {
+			channelHealthStore[key] = stats
+		} else {
+			channelHealthStore[key] = &channelHealthStats{}
+		}
+	}
+	channelHealthLock.Unlock()
+}
+
+// loadChannelHealthFromRedis 批量读取统计，keys 不含前缀，Redis 中不存在的 key 不返回
+func loadChannelHealthFromRedis(ctx context.Context, keys []string) (map[string]*channelHealthStats, error) {
+	pipe := common.RDB.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(ctx, channelHealthRedisPrefix+key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	result := make(map[string]*channelHealthStats, len(keys))
+	for i, cmd := range cmds {
+		values, err := cmd.Result()
+		if err != nil || len(values) == 0 {
+			continue
+		}
+		parse := func(field string) float64 {
+			value, _ := strconv.ParseFloat(values[field], 64)
+			return value
+		}
+		result[keys[i]] = &channelHealthStats{
+			Success:    parse("success"),
+			Failure:    parse("failure"),
+			LatencySum: parse("latency_sum"),
+			LatencyW:   parse("latency_w"),
+			TTFTSum:    parse("ttft_sum"),
+			TTFTW:      parse("ttft_w"),
+			UpdatedAt:  int64(parse("updated_at")),
+		}
+	}
+	return result, nil
+}
+
+// ChannelHealth 渠道在某个模型上的健康情况
+type ChannelHealth struct {
+	ChannelId       int     `json:"channel_id"`
+	Model           string  `json:"model"`
+	Success         float64 `json:"success"`
+	Failure         float64 `json:"failure"`
+	SuccessRate     float64 `json:"success_rate"`
+	AvgLatencyMs    float64 `json:"avg_latency_ms"`
+	AvgFirstTokenMs float64 `json:"avg_first_token_ms"`
+	Score           float64 `json:"score"`
+	UpdatedAt       int64   `json:"updated_at"`
+}
+
+// health 计算衰减到当前时间的健康情况
+// 成功率加入先验（1 次成功、1 次失败）避免样本过少时剧烈波动；
+// 延迟优先使用流式首字延迟，score = 成功率^ErrorPenalty × 参考延迟 / (参考延迟 + 延迟)
+func (s channelHealthStats) health(now int64) ChannelHealth {
+	setting := operation_setting.GetChannelHealthSetting()
+	s.decay(now, channelHealthHalfLife())
+	health := ChannelHealth{
+		Success:     s.Success,
+		Failure:     s.Failure,
+		SuccessRate: (s.Success + 1) / (s.Success + s.Failure + 2),
+		UpdatedAt:   s.UpdatedAt / 1000,
+	}
+	if s.LatencyW > 0 {
+		health.AvgLatencyMs = s.LatencySum / s.LatencyW
+	}
+	if s.TTFTW > 0 {
+		health.AvgFirstTokenMs = s.TTFTSum / s.TTFTW
+	}
+	penalty := setting.ErrorPenalty
+	if penalty <= 0 {
+		penalty = 1
+	}
+	health.Score = math.Pow(health.SuccessRate, penalty)
+	latency := health.AvgFirstTokenMs
+	if latency == 0 {
+		latency = health.AvgLatencyMs
+	}
+	if reference := float64(setting.LatencyReferenceMs); reference > 0 && latency > 0 {
+		health.Score *= reference / (reference + latency)
+	}
+	health.Score = math.Max(health.Score, setting.MinScore)
+	return health
+}
+
+// getChannelHealthFactor 渠道选择时的权重系数，没有统计数据或未启用时为 1
+func getChannelHealthFactor(channelId int, modelName string) float64 {
+	if !operation_setting.GetChannelHealthSetting().Enabled {
+		return 1
+	}
+	key := channelHealthKey(channelId, modelName)
+	channelHealthLock.RLock()
+	stats, ok := channelHealthStore[key]
+	var snapshot channelHealthStats
+	if ok {
+		snapshot = *stats
+	}
+	channelHealthLock.RUnlock()
+	if !ok {
+		if common.RedisEnabled {
+			// 记录关注的 key，下次同步时从 Redis 拉取其他实例的统计
+			channelHealthLock.Lock()
+			if _, exists := channelHealthStore[key]; !exists {
+				channelHealthStore[key] = &channelHealthStats{}
+			}
+			channelHealthLock.Unlock()
+			startChannelHealthSync()
+		}
+		return 1
+	}
+	if snapshot.Success+snapshot.Failure == 0 {
+		return 1
+	}
+	return snapshot.health(time.Now().UnixMilli()).Score
+}
+
+// GetChannelHealthList 返回渠道健康统计，channelId 为 0 时返回全部渠道
+// 启用 Redis 时读取所有实例共享的统计
+func GetChannelHealthList(ctx context.Context, channelId int) ([]ChannelHealth, error) {
+	stats := make(map[string]*channelHealthStats)
+	if common.RedisEnabled {
+		pattern := channelHealthRedisPrefix + "*"
+		if channelId > 0 {
+			pattern = channelHealthRedisPrefix + strconv.Itoa(channelId) + ":*"
+		}
+		var keys []string
+		iter := common.RDB.Scan(ctx, 0, pattern, 200).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, strings.TrimPrefix(iter.Val(), channelHealthRedisPrefix))
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			remote, err := loadChannelHealthFromRedis(ctx, keys)
+			if err != nil {
+				return nil, err
+			}
+			stats = remote
+		}
+	} else {
+		channelHealthLock.RLock()
+		for key, value := range channelHealthStore {
+			snapshot := *value
+			stats[key] = &snapshot
+		}
+		channelHealthLock.RUnlock()
+	}
+
+	now := time.Now().UnixMilli()
+	result := make([]ChannelHealth, 0, len(stats))
+	for key, value := range stats {
+		idStr, modelName, found := strings.Cut(key, ":")
+		if !found {
+			continue
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil || (channelId > 0 && id != channelId) {
+			continue
+		}
+		if value.Success+value.Failure == 0 {
+			continue
+		}
+		health := value.health(now)
+		health.ChannelId = id
+		health.Model = modelName
+		result = append(result, health)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ChannelId != result[j].ChannelId {
+			return result[i].ChannelId < result[j].ChannelId
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result, nil
+}
+
+// ResetChannelHealth 清空渠道的健康统计，渠道修复后可手动重置
+func ResetChannelHealth(ctx context.Context, channelId int) error {
+	prefix := strconv.Itoa(channelId) + ":"
+	channelHealthLock.Lock()
+	for key := range channelHealthStore {
+		if strings.HasPrefix(key, prefix) {
+			delete(channelHealthStore, key)
+		}
+	}
+	channelHealthLock.Unlock()
+	if !common.RedisEnabled {
+		return nil
+	}
+	iter := common.RDB.Scan(ctx, 0, channelHealthRedisPrefix+prefix+"*", 200).Iterator()
+	for iter.Next(ctx) {
+		if err := common.RDB.Del(ctx, iter.Val()).Err(); err != nil {
+			return fmt.Errorf("failed to reset channel health: %w", err)
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,338 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 余量调度模式下单个 key 的限流状态，只保存在本实例内存中，时间均为毫秒时间戳
+
+const (
+	channelKeyWindowMs          = int64(60 * 1000) // 本地 RPM/TPM 统计窗口
+	channelKeyDefaultCooldownMs = int64(60 * 1000) // 429 未携带重置时间时的默认冷却时长
+	channelKeyMaxCooldownMs     = int64(60 * 60 * 1000)
+)
+
+type channelKeyUsageBucket struct {
+	second   int64
+	requests int
+	tokens   int
+}
+
+// channelKeyUpstreamLimit 上游响应头中返回的限额，resetAt 之后视为失效
+type channelKeyUpstreamLimit struct {
+	limit     int
+	remaining int
+	resetAt   int64
+}
+
+func (l *channelKeyUpstreamLimit) ratio(now int64) (float64, bool) {
+	if l == nil || l.limit <= 0 || now >= l.resetAt {
+		return 0, false
+	}
+	return float64(l.remaining) / float64(l.limit), true
+}
+
+type channelKeyLimit struct {
+	buckets       []channelKeyUsageBucket // 按秒聚合的本地用量，最多保留一个窗口
+	requests      *channelKeyUpstreamLimit
+	tokens        *channelKeyUpstreamLimit
+	cooldownUntil int64
+}
+
+var (
+	channelKeyLimitLock sync.Mutex
+	// channel id -> key index -> 限流状态
+	channelKeyLimits = make(map[int]map[int]*channelKeyLimit)
+)
+
+func getChannelKeyLimit(channelId int, keyIndex int, create bool) *channelKeyLimit {
+	limits, ok := channelKeyLimits[channelId]
+	if !ok {
+		if !create {
+			return nil
+		}
+		limits = make(map[int]*channelKeyLimit)
+		channelKeyLimits[channelId] = limits
+	}
+	limit, ok := limits[keyIndex]
+	if !ok && create {
+		limit = &channelKeyLimit{}
+		limits[keyIndex] = limit
+	}
+	return limit
+}
+
+// usage 返回窗口内的本地请求数和 token 数，同时淘汰过期的桶
+func (l *channelKeyLimit) usage(now int64) (requests int, tokens int) {
+	windowStart := (now - channelKeyWindowMs) / 1000
+	buckets := l.buckets[:0]
+	for _, bucket := range l.buckets {
+		if bucket.second > windowStart {
+			buckets = append(buckets, bucket)
+			requests += bucket.requests
+			tokens += bucket.tokens
+		}
+	}
+	l.buckets = buckets
+	return requests, tokens
+}
+
+func (l *channelKeyLimit) add(now int64, requests int, tokens int) {
+	second := now / 1000
+	if n := len(l.buckets); n > 0 && l.buckets[n-1].second == second {
+		l.buckets[n-1].requests += requests
+		l.buckets[n-1].tokens += tokens
+		return
+	}
+	l.buckets = append(l.buckets, channelKeyUsageBucket{second: second, requests: requests, tokens: tokens})
+}
+
+// headroom 返回 key 的剩余余量比例（0~1）和余量耗尽时预计恢复的时间
+// 取本地预算与上游限额中最紧张的一项，均未知时为 1
+func (l *channelKeyLimit) headroom(now int64, rpm int, tpm int) (float64, int64) {
+	headroom := 1.0
+	var availableAt int64
+	requests, tokens := l.usage(now)
+	windowResetAt := now + channelKeyWindowMs
+	if len(l.buckets) > 0 {
+		windowResetAt = l.buckets[0].second*1000 + channelKeyWindowMs
+	}
+	if rpm > 0 {
+		headroom = math.Min(headroom, float64(rpm-requests)/float64(rpm))
+		if requests >= rpm {
+			availableAt = max(availableAt, windowResetAt)
+		}
+	}
+	if tpm > 0 {
+		headroom = math.Min(headroom, float64(tpm-tokens)/float64(tpm))
+		if tokens >= tpm {
+			availableAt = max(availableAt, windowResetAt)
+		}
+	}
+	for _, upstream := range []*channelKeyUpstreamLimit{l.requests, l.tokens} {
+		if ratio, ok := upstream.ratio(now); ok {
+			headroom = math.Min(headroom, ratio)
+			if upstream.remaining <= 0 {
+				availableAt = max(availableAt, upstream.resetAt)
+			}
+		}
+	}
+	if l.cooldownUntil > now {
+		availableAt = max(availableAt, l.cooldownUntil)
+	}
+	return math.Max(headroom, 0), availableAt
+}
+
+// selectHeadroomKey 在启用的 key 中选择不在冷却中且余量最多的一个，余量相同时优先本地用量少的，再随机
+// 所有 key 都不可用时返回最早恢复的 key，交由上游决定是否继续限流
+func selectHeadroomKey(channelId int, enabledIdx []int, rpm int, tpm int) int {
+	now := time.Now().UnixMilli()
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+
+	type candidate struct {
+		index       int
+		headroom    float64
+		requests    int
+		availableAt int64
+	}
+	candidates := make([]candidate, 0, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		c := candidate{index: idx, headroom: 1}
+		if limit := getChannelKeyLimit(channelId, idx, false); limit != nil {
+			c.headroom, c.availableAt = limit.headroom(now, rpm, tpm)
+			c.requests, _ = limit.usage(now)
+		}
+		candidates = append(candidates, c)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ai, aj := candidates[i].availableAt > now, candidates[j].availableAt > now
+		if ai != aj {
+			return !ai
+		}
+		if ai {
+			return candidates[i].availableAt < candidates[j].availableAt
+		}
+		if candidates[i].headroom != candidates[j].headroom {
+			return candidates[i].headroom > candidates[j].headroom
+		}
+		return candidates[i].requests < candidates[j].requests
+	})
+	selected := candidates[0].index
+	getChannelKeyLimit(channelId, selected, true).add(now, 1, 0)
+	return selected
+}
+
+// recordChannelKeyRequest 记录未经余量选择直接使用的 key（如渠道亲和固定的 key）的一次请求
+func recordChannelKeyRequest(channelId int, keyIndex int) {
+	now := time.Now().UnixMilli()
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+	getChannelKeyLimit(channelId, keyIndex, true).add(now, 1, 0)
+}
+
+// RecordChannelKeyTokens 记录 key 本次请求消耗的 token 数，用于本地 TPM 预算
+// 请求开始时按预估输入 token 数记录，结束后由 service.RecordChannelTokenUsage 补记实际用量超出的部分
+func RecordChannelKeyTokens(channelId int, keyIndex int, tokens int) {
+	if channelId <= 0 || tokens <= 0 {
+		return
+	}
+	now := time.Now().UnixMilli()
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+	getChannelKeyLimit(channelId, keyIndex, true).add(now, 0, tokens)
+}
+
+// UpdateChannelKeyRateLimit 根据上游响应头更新 key 的限额，429 时只让该 key 进入冷却
+// 支持 Retry-After、retry-after-ms、OpenAI 的 x-ratelimit-* 和 Anthropic 的 anthropic-ratelimit-*
+func UpdateChannelKeyRateLimit(channelId int, keyIndex int, statusCode int, header http.Header) {
+	if channelId <= 0 || header == nil {
+		return
+	}
+	now := time.Now().UnixMilli()
+	requests := parseUpstreamRateLimit(header, now, "x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests")
+	if requests == nil {
+		requests = parseUpstreamRateLimit(header, now, "anthropic-ratelimit-requests-limit", "anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset")
+	}
+	tokens := parseUpstreamRateLimit(header, now, "x-ratelimit-limit-tokens", "x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens")
+	if tokens == nil {
+		tokens = parseUpstreamRateLimit(header, now, "anthropic-ratelimit-tokens-limit", "anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset")
+	}
+
+	var cooldownUntil int64
+	if statusCode == http.StatusTooManyRequests {
+		cooldownUntil = parseRetryAfter(header, now)
+		if cooldownUntil == 0 {
+			// 没有 Retry-After 时使用已耗尽限额的重置时间
+			for _, upstream := range []*channelKeyUpstreamLimit{requests, tokens} {
+				if upstream != nil && upstream.remaining <= 0 {
+					cooldownUntil = max(cooldownUntil, upstream.resetAt)
+				}
+			}
+		}
+		if cooldownUntil <= now {
+			cooldownUntil = now + channelKeyDefaultCooldownMs
+		}
+		cooldownUntil = min(cooldownUntil, now+channelKeyMaxCooldownMs)
+	}
+	if requests == nil && tokens == nil && cooldownUntil == 0 {
+		return
+	}
+
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+	limit := getChannelKeyLimit(channelId, keyIndex, true)
+	if requests != nil {
+		limit.requests = requests
+	}
+	if tokens != nil {
+		limit.tokens = tokens
+	}
+	if cooldownUntil > limit.cooldownUntil {
+		limit.cooldownUntil = cooldownUntil
+	}
+}
+
+// IsChannelKeyCoolingDown key 是否处于限流冷却中
+func IsChannelKeyCoolingDown(channelId int, keyIndex int) bool {
+	now := time.Now().UnixMilli()
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+	limit := getChannelKeyLimit(channelId, keyIndex, false)
+	return limit != nil && limit.cooldownUntil > now
+}
+
+func parseUpstreamRateLimit(header http.Header, now int64, limitKey string, remainingKey string, resetKey string) *channelKeyUpstreamLimit {
+	limit, err := strconv.Atoi(strings.TrimSpace(header.Get(limitKey)))
+	if err != nil || limit <= 0 {
+		return nil
+	}
+	remaining, err := strconv.Atoi(strings.TrimSpace(header.Get(remainingKey)))
+	if err != nil {
+		return nil
+	}
+	resetAt := parseRateLimitReset(header.Get(resetKey), now)
+	if resetAt == 0 {
+		resetAt = now + channelKeyWindowMs
+	}
+	return &channelKeyUpstreamLimit{limit: limit, remaining: remaining, resetAt: resetAt}
+}
+
+// parseRateLimitReset 解析重置时间，支持 Go duration（OpenAI: "6m0s"、"20ms"）、秒数和 RFC3339 时间（Anthropic）
+func parseRateLimitReset(value string, now int64) int64 {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now + d.Milliseconds()
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now + int64(seconds*1000)
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli()
+	}
+	return 0
+}
+
+// parseRetryAfter 解析 retry-after-ms 和 Retry-After（秒数或 HTTP 日期），返回冷却结束时间
+func parseRetryAfter(header http.Header, now int64) int64 {
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header.Get("retry-after-ms")), 64); err == nil && ms > 0 {
+		return now + int64(ms)
+	}
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now + int64(seconds*1000)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.UnixMilli()
+	}
+	return 0
+}
+
+// ChannelKeyLimit key 的限流状态，用于多密钥管理展示
+type ChannelKeyLimit struct {
+	Requests      int     `json:"requests"` // 本地统计的最近一分钟请求数
+	Tokens        int     `json:"tokens"`   // 本地统计的最近一分钟 token 数
+	Headroom      float64 `json:"headroom"`
+	CooldownUntil int64   `json:"cooldown_until,omitempty"`
+}
+
+// GetChannelKeyLimits 返回渠道下有记录的 key 的限流状态，key index -> 状态
+func GetChannelKeyLimits(channelId int, rpm int, tpm int) map[int]ChannelKeyLimit {
+	now := time.Now().UnixMilli()
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+	result := make(map[int]ChannelKeyLimit)
+	for idx, limit := range channelKeyLimits[channelId] {
+		requests, tokens := limit.usage(now)
+		headroom, _ := limit.headroom(now, rpm, tpm)
+		state := ChannelKeyLimit{Requests: requests, Tokens: tokens, Headroom: headroom}
+		if limit.cooldownUntil > now {
+			state.CooldownUntil = limit.cooldownUntil / 1000
+		}
+		result[idx] = state
+	}
+	return result
+}
+
+// ResetChannelKeyLimits 清空渠道所有 key 的限流状态，在密钥列表变更时调用
+func ResetChannelKeyLimits(channelId int) {
+	channelKeyLimitLock.Lock()
+	defer channelKeyLimitLock.Unlock()
+	delete(channelKeyLimits, channelId)
+}
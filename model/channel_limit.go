@@ -0,0 +1,346 @@
+package model
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/go-redis/redis/v8"
+)
+
+// 渠道级别的并发、RPM、TPM 上限，配置在渠道的 settings 中，用量达到任一上限的渠道在选择时被跳过
+// 启用 Redis 时用量在多实例间共享，否则只统计本实例
+
+const (
+	channelLimitRedisPrefix = "channel_limit:"
+	// channelLimitInflightTTL 并发计数的过期时间，防止实例异常退出后计数无法归还
+	channelLimitInflightTTL  = 10 * time.Minute
+	channelLimitRedisTimeout = 2 * time.Second
+	// channelSaturatedCacheTTL 已达上限渠道集合的缓存时间
+	channelSaturatedCacheTTL = time.Second
+)
+
+type channelLimitConfig struct {
+	maxConcurrency int
+	rpm            int
+	tpm            int
+}
+
+func (c channelLimitConfig) enabled() bool {
+	return c.maxConcurrency > 0 || c.rpm > 0 || c.tpm > 0
+}
+
+type channelLimitState struct {
+	settings string // 解析 config 时的渠道 settings，变化时重新解析
+	config   channelLimitConfig
+	inflight int
+	window   channelKeyLimit // 复用多 key 余量模式的按秒用量窗口
+}
+
+var (
+	channelLimitLock   sync.Mutex
+	channelLimitStates = make(map[int]*channelLimitState)
+
+	channelSaturatedLock sync.Mutex
+	channelSaturatedIds  map[int]bool
+	channelSaturatedAt   time.Time
+)
+
+// ChannelUtilization 渠道当前的用量和上限，上限为 0 表示不限制
+type ChannelUtilization struct {
+	Concurrency    int  `json:"concurrency"`
+	MaxConcurrency int  `json:"max_concurrency"`
+	Requests       int  `json:"requests"` // 最近一分钟请求数
+	RPM            int  `json:"rpm"`
+	Tokens         int  `json:"tokens"` // 最近一分钟 token 数，进行中的请求按预估输入 token 数计
+	TPM            int  `json:"tpm"`
+	Saturated      bool `json:"saturated"`
+}
+
+func (u *ChannelUtilization) saturated() bool {
+	return (u.MaxConcurrency > 0 && u.Concurrency >= u.MaxConcurrency) ||
+		(u.RPM > 0 && u.Requests >= u.RPM) ||
+		(u.TPM > 0 && u.Tokens >= u.TPM)
+}
+
+// getChannelLimitState 返回渠道的限流状态，settings 变化时重新解析上限，未配置上限时返回 nil
+// 调用方需持有 channelLimitLock
+func getChannelLimitState(channel *Channel) *channelLimitState {
+	state, ok := channelLimitStates[channel.Id]
+	if ok && state.settings == channel.OtherSettings {
+		if !state.config.enabled() {
+			return nil
+		}
+		return state
+	}
+	var config channelLimitConfig
+	if channel.OtherSettings != "" {
+		var settings dto.ChannelOtherSettings
+		if err := common.UnmarshalJsonStr(channel.OtherSettings, &settings); err == nil {
+			config = channelLimitConfig{
+				maxConcurrency: max(settings.MaxConcurrency, 0),
+				rpm:            max(settings.RPM, 0),
+				tpm:            max(settings.TPM, 0),
+			}
+		}
+	}
+	if !ok {
+		state = &channelLimitState{}
+		channelLimitStates[channel.Id] = state
+	}
+	state.settings = channel.OtherSettings
+	state.config = config
+	if !config.enabled() {
+		return nil
+	}
+	return state
+}
+
+// refreshChannelLimits 渠道缓存同步后更新所有渠道的上限，已删除的渠道不再统计
+func refreshChannelLimits(channels map[int]*Channel) {
+	channelLimitLock.Lock()
+	defer channelLimitLock.Unlock()
+	for channelId := range channelLimitStates {
+		if _, ok := channels[channelId]; !ok {
+			delete(channelLimitStates, channelId)
+		}
+	}
+	for _, channel := range channels {
+		getChannelLimitState(channel)
+	}
+}
+
+// AcquireChannelLimit 向渠道发起一次请求前计入并发数、请求数和预估 token 数，返回的函数用于请求结束后归还并发数
+// 上限从缓存中完整的渠道信息读取，请求上下文中的渠道可能没有加载 settings，不能用来解析上限
+func AcquireChannelLimit(channelId int, tokens int) func() {
+	channel, err := CacheGetChannel(channelId)
+	channelLimitLock.Lock()
+	var state *channelLimitState
+	if err == nil {
+		state = getChannelLimitState(channel)
+	} else if existing, ok := channelLimitStates[channelId]; ok && existing.config.enabled() {
+		// 读取渠道失败时沿用已解析的上限
+		state = existing
+	}
+	if state == nil {
+		channelLimitLock.Unlock()
+		return func() {}
+	}
+	tokens = max(tokens, 0)
+	state.inflight++
+	state.window.add(time.Now().UnixMilli(), 1, tokens)
+	channelLimitLock.Unlock()
+
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), channelLimitRedisTimeout)
+		defer cancel()
+		usageKey := channelLimitUsageKey(channelId, time.Now().Unix()/60)
+		pipe := common.RDB.TxPipeline()
+		pipe.Incr(ctx, channelLimitInflightKey(channelId))
+		pipe.Expire(ctx, channelLimitInflightKey(channelId), channelLimitInflightTTL)
+		pipe.HIncrBy(ctx, usageKey, "requests", 1)
+		pipe.HIncrBy(ctx, usageKey, "tokens", int64(tokens))
+		pipe.Expire(ctx, usageKey, 2*time.Minute)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError("failed to record channel limit usage: " + err.Error())
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			channelLimitLock.Lock()
+			if state.inflight > 0 {
+				state.inflight--
+			}
+			channelLimitLock.Unlock()
+			if common.RedisEnabled {
+				ctx, cancel := context.WithTimeout(context.Background(), channelLimitRedisTimeout)
+				defer cancel()
+				if err := common.RDB.Decr(ctx, channelLimitInflightKey(channelId)).Err(); err != nil {
+					common.SysError("failed to release channel concurrency: " + err.Error())
+				}
+			}
+		})
+	}
+}
+
+// RecordChannelLimitTokens 请求结束后补记实际用量超出预估的 token 数，计入当前的用量窗口
+func RecordChannelLimitTokens(channelId int, tokens int) {
+	if channelId <= 0 || tokens <= 0 {
+		return
+	}
+	channelLimitLock.Lock()
+	state, ok := channelLimitStates[channelId]
+	if !ok || state.config.tpm <= 0 {
+		channelLimitLock.Unlock()
+		return
+	}
+	state.window.add(time.Now().UnixMilli(), 0, tokens)
+	channelLimitLock.Unlock()
+
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), channelLimitRedisTimeout)
+		defer cancel()
+		usageKey := channelLimitUsageKey(channelId, time.Now().Unix()/60)
+		pipe := common.RDB.TxPipeline()
+		pipe.HIncrBy(ctx, usageKey, "tokens", int64(tokens))
+		pipe.Expire(ctx, usageKey, 2*time.Minute)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError("failed to record channel limit usage: " + err.Error())
+		}
+	}
+}
+
+func channelLimitInflightKey(channelId int) string {
+	return channelLimitRedisPrefix + "inflight:" + strconv.Itoa(channelId)
+}
+
+func channelLimitUsageKey(channelId int, minute int64) string {
+	return channelLimitRedisPrefix + "usage:" + strconv.Itoa(channelId) + ":" + strconv.FormatInt(minute, 10)
+}
+
+// getChannelUtilizations 计算配置了上限的渠道的当前用量，channelIds 为空时返回所有已知渠道
+func getChannelUtilizations(channelIds []int) map[int]*ChannelUtilization {
+	now := time.Now().UnixMilli()
+	utilizations := make(map[int]*ChannelUtilization)
+	channelLimitLock.Lock()
+	collect := func(channelId int, state *channelLimitState) {
+		if state == nil || !state.config.enabled() {
+			return
+		}
+		requests, tokens := state.window.usage(now)
+		utilizations[channelId] = &ChannelUtilization{
+			Concurrency:    state.inflight,
+			MaxConcurrency: state.config.maxConcurrency,
+			Requests:       requests,
+			RPM:            state.config.rpm,
+			Tokens:         tokens,
+			TPM:            state.config.tpm,
+		}
+	}
+	if len(channelIds) == 0 {
+		for channelId, state := range channelLimitStates {
+			collect(channelId, state)
+		}
+	} else {
+		for _, channelId := range channelIds {
+			collect(channelId, channelLimitStates[channelId])
+		}
+	}
+	channelLimitLock.Unlock()
+
+	if common.RedisEnabled && len(utilizations) > 0 {
+		loadChannelUtilizationsFromRedis(utilizations, now)
+	}
+	for _, utilization := range utilizations {
+		utilization.Saturated = utilization.saturated()
+	}
+	return utilizations
+}
+
+// loadChannelUtilizationsFromRedis 使用所有实例共享的用量，RPM/TPM 按当前分钟和上一分钟的计数估算滑动窗口
+func loadChannelUtilizationsFromRedis(utilizations map[int]*ChannelUtilization, now int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), channelLimitRedisTimeout)
+	defer cancel()
+	minute := now / 1000 / 60
+	// 上一分钟计数中仍落在窗口内的比例
+	previousWeight := 1 - float64(now%60000)/60000
+
+	type cmds struct {
+		inflight *redis.StringCmd
+		current  *redis.SliceCmd
+		previous *redis.SliceCmd
+	}
+	pipe := common.RDB.Pipeline()
+	results := make(map[int]cmds, len(utilizations))
+	for channelId := range utilizations {
+		results[channelId] = cmds{
+			inflight: pipe.Get(ctx, channelLimitInflightKey(channelId)),
+			current:  pipe.HMGet(ctx, channelLimitUsageKey(channelId, minute), "requests", "tokens"),
+			previous: pipe.HMGet(ctx, channelLimitUsageKey(channelId, minute-1), "requests", "tokens"),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		common.SysError("failed to load channel limit usage: " + err.Error())
+		return
+	}
+	parse := func(cmd *redis.SliceCmd) (int, int) {
+		values, err := cmd.Result()
+		if err != nil || len(values) != 2 {
+			return 0, 0
+		}
+		var counts [2]int
+		for i, value := range values {
+			if s, ok := value.(string); ok {
+				counts[i], _ = strconv.Atoi(s)
+			}
+		}
+		return counts[0], counts[1]
+	}
+	for channelId, result := range results {
+		utilization := utilizations[channelId]
+		inflight, _ := result.inflight.Int()
+		utilization.Concurrency = max(inflight, 0)
+		currentRequests, currentTokens := parse(result.current)
+		previousRequests, previousTokens := parse(result.previous)
+		utilization.Requests = currentRequests + int(float64(previousRequests)*previousWeight)
+		utilization.Tokens = currentTokens + int(float64(previousTokens)*previousWeight)
+	}
+}
+
+// getSaturatedChannelIds 用量已达到上限的渠道，选择渠道时与禁用渠道一样跳过
+// 每次选择和重试都会调用，结果缓存 channelSaturatedCacheTTL，避免每次都读取所有渠道在 Redis 中的用量；返回的 map 只读
+func getSaturatedChannelIds() map[int]bool {
+	channelSaturatedLock.Lock()
+	defer channelSaturatedLock.Unlock()
+	if !channelSaturatedAt.IsZero() && time.Since(channelSaturatedAt) < channelSaturatedCacheTTL {
+		return channelSaturatedIds
+	}
+	var saturated map[int]bool
+	for channelId, utilization := range getChannelUtilizations(nil) {
+		if utilization.Saturated {
+			if saturated == nil {
+				saturated = make(map[int]bool)
+			}
+			saturated[channelId] = true
+		}
+	}
+	channelSaturatedIds = saturated
+	channelSaturatedAt = time.Now()
+	return saturated
+}
+
+// isChannelSaturated 单个渠道的用量是否已达到上限
+func isChannelSaturated(channel *Channel) bool {
+	channelLimitLock.Lock()
+	state := getChannelLimitState(channel)
+	channelLimitLock.Unlock()
+	if state == nil {
+		return false
+	}
+	utilization, ok := getChannelUtilizations([]int{channel.Id})[channel.Id]
+	return ok && utilization.Saturated
+}
+
+// FillChannelUtilization 为配置了上限的渠道填充当前用量
+func FillChannelUtilization(channels []*Channel) {
+	channelIds := make([]int, 0, len(channels))
+	channelLimitLock.Lock()
+	for _, channel := range channels {
+		if getChannelLimitState(channel) != nil {
+			channelIds = append(channelIds, channel.Id)
+		}
+	}
+	channelLimitLock.Unlock()
+	if len(channelIds) == 0 {
+		return
+	}
+	utilizations := getChannelUtilizations(channelIds)
+	for _, channel := range channels {
+		channel.Utilization = utilizations[channel.Id]
+	}
+}
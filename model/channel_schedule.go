@@ -0,0 +1,259 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 定时任务的动作
+const (
+	ChannelScheduleActionEnable   = "enable"
+	ChannelScheduleActionDisable  = "disable"
+	ChannelScheduleActionPriority = "priority"
+	ChannelScheduleActionWeight   = "weight"
+)
+
+// channelScheduleCheckInterval 调度器检查定时任务的间隔
+const channelScheduleCheckInterval = 15 * time.Second
+
+// channelScheduleCatchUpWindow 主节点启动时补执行停机期间错过的定时任务，最多回溯该时长
+const channelScheduleCatchUpWindow = 24 * time.Hour
+
+// ChannelSchedule 渠道定时任务，按 cron 表达式启用、禁用渠道或修改优先级、权重
+// ChannelId 和 Tag 二选一，按标签时作用于该标签下的所有渠道
+type ChannelSchedule struct {
+	Id          int    `json:"id"`
+	ChannelId   int    `json:"channel_id" gorm:"index;default:0"`
+	Tag         string `json:"tag" gorm:"type:varchar(64);index;default:''"`
+	Cron        string `json:"cron" gorm:"type:varchar(64);not null"`
+	Action      string `json:"action" gorm:"type:varchar(16);not null"`
+	Value       int64  `json:"value" gorm:"bigint;default:0"` // priority / weight 动作的目标值
+	Enabled     bool   `json:"enabled" gorm:"default:true"`
+	Remark      string `json:"remark" gorm:"type:varchar(255);default:''"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	LastRunTime int64  `json:"last_run_time" gorm:"bigint;default:0"`
+	LastError   string `json:"last_error" gorm:"type:varchar(255);default:''"`
+	NextRunTime int64  `json:"next_run_time" gorm:"-"` // 下一次执行时间，未启用时为 0
+}
+
+// Validate 校验定时任务配置
+func (schedule *ChannelSchedule) Validate() error {
+	schedule.Tag = strings.TrimSpace(schedule.Tag)
+	if (schedule.ChannelId == 0) == (schedule.Tag == "") {
+		return errors.New("渠道和标签必须且只能指定一个")
+	}
+	if _, err := common.ParseCron(schedule.Cron); err != nil {
+		return fmt.Errorf("cron 表达式错误: %v", err)
+	}
+	switch schedule.Action {
+	case ChannelScheduleActionEnable, ChannelScheduleActionDisable:
+	case ChannelScheduleActionPriority:
+	case ChannelScheduleActionWeight:
+		if schedule.Value < 0 {
+			return errors.New("权重不能为负数")
+		}
+	default:
+		return fmt.Errorf("不支持的动作: %s", schedule.Action)
+	}
+	return nil
+}
+
+func (schedule *ChannelSchedule) fillNextRunTime(now time.Time) {
+	schedule.NextRunTime = 0
+	if !schedule.Enabled {
+		return
+	}
+	cron, err := common.ParseCron(schedule.Cron)
+	if err != nil {
+		return
+	}
+	if next := cron.Next(now); !next.IsZero() {
+		schedule.NextRunTime = next.Unix()
+	}
+}
+
+// GetChannelSchedules 返回定时任务并计算下一次执行时间，按下一次执行时间排序
+// channelId 或 tag 不为空时只返回对应的任务
+func GetChannelSchedules(channelId int, tag string) ([]*ChannelSchedule, error) {
+	var schedules []*ChannelSchedule
+	tx := DB.Model(&ChannelSchedule{})
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	if tag != "" {
+		tx = tx.Where("tag = ?", tag)
+	}
+	if err := tx.Order("id desc").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, schedule := range schedules {
+		schedule.fillNextRunTime(now)
+	}
+	sort.SliceStable(schedules, func(i, j int) bool {
+		a, b := schedules[i].NextRunTime, schedules[j].NextRunTime
+		if a == 0 || b == 0 {
+			return a != 0
+		}
+		return a < b
+	})
+	return schedules, nil
+}
+
+func GetChannelScheduleById(id int) (*ChannelSchedule, error) {
+	schedule := &ChannelSchedule{}
+	err := DB.First(schedule, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	schedule.fillNextRunTime(time.Now())
+	return schedule, nil
+}
+
+func (schedule *ChannelSchedule) Insert() error {
+	schedule.CreatedTime = common.GetTimestamp()
+	return DB.Create(schedule).Error
+}
+
+func (schedule *ChannelSchedule) Update() error {
+	return DB.Model(schedule).Select("channel_id", "tag", "cron", "action", "value", "enabled", "remark").Updates(schedule).Error
+}
+
+func DeleteChannelScheduleById(id int) error {
+	return DB.Delete(&ChannelSchedule{}, "id = ?", id).Error
+}
+
+// StartChannelScheduler 定期执行到期的渠道定时任务，只应在主节点运行
+// 从节点通过 SyncChannelCache 获取变更后的渠道
+// 启动时从每个任务上次执行的时间开始检查（最多回溯 channelScheduleCatchUpWindow），停机期间错过的任务补执行最近的一次
+func StartChannelScheduler() {
+	lastCheck := time.Now()
+	if runDueChannelSchedules(time.Time{}, lastCheck) {
+		InitChannelCache()
+	}
+	for {
+		time.Sleep(channelScheduleCheckInterval)
+		now := time.Now()
+		if runDueChannelSchedules(lastCheck, now) {
+			InitChannelCache()
+		}
+		lastCheck = now
+	}
+}
+
+// catchUpFrom 启动补执行时任务的检查起点：上次执行时间和创建时间中较晚的一个，且不早于回溯窗口
+func (schedule *ChannelSchedule) catchUpFrom(now time.Time) time.Time {
+	from := now.Add(-channelScheduleCatchUpWindow)
+	for _, ts := range []int64{schedule.LastRunTime, schedule.CreatedTime} {
+		if t := time.Unix(ts, 0); ts > 0 && t.After(from) {
+			from = t
+		}
+	}
+	return from
+}
+
+// runDueChannelSchedules 执行在 (from, to] 之间到期的定时任务，返回是否修改了渠道
+// 区间内有多次触发时只执行最近的一次；from 为零值时按各任务的 catchUpFrom 计算起点
+func runDueChannelSchedules(from time.Time, to time.Time) bool {
+	var schedules []*ChannelSchedule
+	if err := DB.Where("enabled = ?", true).Order("id").Find(&schedules).Error; err != nil {
+		common.SysError("failed to load channel schedules: " + err.Error())
+		return false
+	}
+	changed := false
+	for _, schedule := range schedules {
+		cron, err := common.ParseCron(schedule.Cron)
+		if err != nil {
+			continue
+		}
+		start := from
+		if start.IsZero() {
+			start = schedule.catchUpFrom(to)
+		}
+		var next time.Time
+		for due := cron.Next(start); !due.IsZero() && !due.After(to); due = cron.Next(due) {
+			next = due
+		}
+		if next.IsZero() {
+			continue
+		}
+		err = schedule.run()
+		lastError := ""
+		if err != nil {
+			lastError = err.Error()
+			if len(lastError) > 255 {
+				lastError = lastError[:255]
+			}
+			common.SysError(fmt.Sprintf("channel schedule #%d failed: %s", schedule.Id, lastError))
+		} else {
+			changed = true
+			common.SysLog(fmt.Sprintf("channel schedule #%d executed: %s %s", schedule.Id, schedule.target(), schedule.Action))
+		}
+		DB.Model(&ChannelSchedule{}).Where("id = ?", schedule.Id).Updates(map[string]interface{}{
+			"last_run_time": next.Unix(),
+			"last_error":    lastError,
+		})
+	}
+	return changed
+}
+
+func (schedule *ChannelSchedule) target() string {
+	if schedule.Tag != "" {
+		return "tag " + schedule.Tag
+	}
+	return fmt.Sprintf("channel #%d", schedule.ChannelId)
+}
+
+func (schedule *ChannelSchedule) run() error {
+	if schedule.Tag != "" {
+		switch schedule.Action {
+		case ChannelScheduleActionEnable:
+			return EnableChannelByTag(schedule.Tag)
+		case ChannelScheduleActionDisable:
+			return DisableChannelByTag(schedule.Tag)
+		case ChannelScheduleActionPriority:
+			priority := schedule.Value
+			return EditChannelByTag(schedule.Tag, nil, nil, nil, nil, &priority, nil)
+		case ChannelScheduleActionWeight:
+			weight := uint(schedule.Value)
+			return EditChannelByTag(schedule.Tag, nil, nil, nil, nil, nil, &weight)
+		}
+		return fmt.Errorf("不支持的动作: %s", schedule.Action)
+	}
+
+	channel, err := GetChannelById(schedule.ChannelId, true)
+	if err != nil {
+		return err
+	}
+	switch schedule.Action {
+	case ChannelScheduleActionEnable, ChannelScheduleActionDisable:
+		status := common.ChannelStatusEnabled
+		if schedule.Action == ChannelScheduleActionDisable {
+			status = common.ChannelStatusManuallyDisabled
+		}
+		if err := DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("status", status).Error; err != nil {
+			return err
+		}
+		return UpdateAbilityStatus(channel.Id, status == common.ChannelStatusEnabled)
+	case ChannelScheduleActionPriority:
+		priority := schedule.Value
+		channel.Priority = &priority
+		if err := DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("priority", priority).Error; err != nil {
+			return err
+		}
+		return channel.UpdateAbilities(nil)
+	case ChannelScheduleActionWeight:
+		weight := uint(schedule.Value)
+		channel.Weight = &weight
+		if err := DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("weight", weight).Error; err != nil {
+			return err
+		}
+		return channel.UpdateAbilities(nil)
+	}
+	return fmt.Errorf("不支持的动作: %s", schedule.Action)
+}
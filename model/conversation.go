@@ -3,10 +3,14 @@ package model
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"sort"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Conversation 对话记录表 - 用于记录完整的AI对话内容
@@ -25,15 +29,36 @@ type Conversation struct {
 	TotalTokens      int    `json:"total_tokens" gorm:"default:0"`
 	IsStream         bool   `json:"is_stream" gorm:"default:false"`
 	CreatedAt        int64  `json:"created_at" gorm:"bigint;index:idx_user_model_time;index;not null"` // Unix 时间戳
-	UseTime          int    `json:"use_time" gorm:"default:0"`                                          // 响应时间（毫秒）
+	UseTime          int    `json:"use_time" gorm:"default:0"`                                         // 响应时间（毫秒）
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Group            string `json:"group" gorm:"index;default:''"`
+	Format           string `json:"format" gorm:"type:varchar(32);default:''"`              // 请求格式：openai / claude / gemini / openai_responses
+	RedactedRules    string `json:"redacted_rules" gorm:"type:varchar(255);default:''"`     // 命中的脱敏规则，逗号分隔
+	SessionId        string `json:"session_id" gorm:"type:varchar(64);index;default:''"`    // 会话 id，客户端指定或自动生成
+	TurnIndex        int    `json:"turn_index" gorm:"default:0"`                            // 会话中的轮次，从 0 开始
+	MessagesHash     string `json:"messages_hash" gorm:"type:varchar(64);index;default:''"` // 完整消息列表的哈希，用于识别下一轮
+	ParentHash       string `json:"parent_hash" gorm:"type:varchar(64);default:''"`         // 上一轮的 MessagesHash
+	PrefixMessages   int    `json:"prefix_messages" gorm:"default:0"`                       // 省略的历史消息条数，大于 0 时只存储本轮新增消息
 }
 
 func (Conversation) TableName() string {
 	return "conversations"
 }
 
+// 对话记录的来源表，主表、归档表和压缩表的 id 相互独立，需要与 id 一起使用
+const (
+	ConversationSourceMain       = "conversations"
+	ConversationSourceArchive    = "archive"
+	ConversationSourceCompressed = "compressed"
+	ConversationSourceCold       = "cold" // 冷存储分段文件，id 为 conversations_archive_index 的 id
+)
+
+// ConversationWithSource 带来源表的对话记录
+type ConversationWithSource struct {
+	*Conversation
+	Source string `json:"source"`
+}
+
 // RecordConversationParams 记录对话的参数
 type RecordConversationParams struct {
 	UserId           int
@@ -51,22 +76,42 @@ type RecordConversationParams struct {
 	UseTime          int
 	Ip               string
 	Group            string
+	Format           string // 请求格式，与 types.RelayFormat 一致，用于前端按格式渲染
+	RedactedRules    string // 落库前命中的脱敏规则，逗号分隔
+	SessionId        string // 客户端指定的会话 id，为空时自动识别
 }
 
 // RecordConversation 记录对话内容
+// 存储模式为 compressed 时写入压缩表
 func RecordConversation(c *gin.Context, params RecordConversationParams) {
 	// 检查是否启用对话记录功能
 	if !common.ConversationLogEnabled {
 		return
 	}
+	if operation_setting.IsConversationCompressedStorage() {
+		RecordConversationCompressed(c, params)
+		return
+	}
 
-	// 将 RequestMessages 转为 JSON 字符串
-	requestJSON, err := json.Marshal(params.RequestMessages)
+	conversation, err := buildConversation(params)
 	if err != nil {
 		logger.LogError(c, "failed to marshal request messages: "+err.Error())
 		return
 	}
 
+	err = LOG_DB.Create(conversation).Error
+	if err != nil {
+		logger.LogError(c, "failed to record conversation: "+err.Error())
+	}
+}
+
+// buildConversation 将记录参数转换为对话记录，RequestMessages 序列化为 JSON 字符串
+func buildConversation(params RecordConversationParams) (*Conversation, error) {
+	requestJSON, err := json.Marshal(params.RequestMessages)
+	if err != nil {
+		return nil, err
+	}
+
 	conversation := &Conversation{
 		UserId:           params.UserId,
 		Username:         params.Username,
@@ -84,22 +129,16 @@ func RecordConversation(c *gin.Context, params RecordConversationParams) {
 		UseTime:          params.UseTime,
 		Ip:               params.Ip,
 		Group:            params.Group,
+		Format:           params.Format,
+		RedactedRules:    params.RedactedRules,
+		SessionId:        params.SessionId,
 	}
-
-	err = LOG_DB.Create(conversation).Error
-	if err != nil {
-		logger.LogError(c, "failed to record conversation: "+err.Error())
-	}
+	threadConversation(conversation)
+	return conversation, nil
 }
 
-// GetConversations 查询对话记录（支持分页和筛选）
-func GetConversations(userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int) ([]*Conversation, int64, error) {
-	var conversations []*Conversation
-	var total int64
-
-	tx := LOG_DB.Model(&Conversation{})
-
-	// 筛选条件
+// applyConversationFilters 对话记录通用筛选条件，主表、归档表和导出共用
+func applyConversationFilters(tx *gorm.DB, userId int, modelName string, username string, startTime int64, endTime int64) *gorm.DB {
 	if userId > 0 {
 		tx = tx.Where("user_id = ?", userId)
 	}
@@ -115,6 +154,18 @@ func GetConversations(userId int, modelName string, username string, startTime i
 	if endTime > 0 {
 		tx = tx.Where("created_at <= ?", endTime)
 	}
+	return tx
+}
+
+// GetConversations 查询对话记录（支持分页和筛选）
+func GetConversations(userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int) ([]*Conversation, int64, error) {
+	var conversations []*Conversation
+	var total int64
+
+	tx := LOG_DB.Model(&Conversation{})
+
+	// 筛选条件
+	tx = applyConversationFilters(tx, userId, modelName, username, startTime, endTime)
 
 	// 获取总数
 	err := tx.Count(&total).Error
@@ -134,98 +185,169 @@ func GetConversationById(id int) (*Conversation, error) {
 	return &conversation, err
 }
 
-// DeleteConversations 批量删除对话记录
+// DeleteConversations 批量删除对话记录，依赖这些记录的后续轮次先还原为完整请求
 func DeleteConversations(ids []int) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	return LOG_DB.Where("id IN ?", ids).Delete(&Conversation{}).Error
+	_, err := deleteConversationRows(context.Background(), &Conversation{}, ConversationSourceMain, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id IN ?", ids)
+	}, conversationDeleteBatchSize)
+	return err
 }
 
-// DeleteConversationsByCondition 按条件批量删除对话记录
-func DeleteConversationsByCondition(userId int, modelName string, username string, startTime int64, endTime int64) (int64, error) {
-	tx := LOG_DB.Model(&Conversation{})
+// formatUserConversations 清除普通用户不应看到的管理字段，与 formatUserLogs 对日志的处理一致
+// TokenName 记录的是令牌原始 key，同样不返回
+func formatUserConversations(conversations []*ConversationWithSource) {
+	for _, conv := range conversations {
+		conv.ChannelId = 0
+		conv.TokenName = ""
+		conv.RedactedRules = ""
+	}
+}
 
-	// 筛选条件
-	if userId > 0 {
-		tx = tx.Where("user_id = ?", userId)
+// GetUserConversations 查询用户自己的对话记录，包含主表、压缩表、归档表和冷存储索引
+// 冷存储中的记录只返回元数据，内容通过 GetUserConversationById 加载
+func GetUserConversations(userId int, modelName string, startTime int64, endTime int64, startIdx int, num int) ([]*ConversationWithSource, int64, error) {
+	if userId <= 0 {
+		return nil, 0, errors.New("invalid user id")
 	}
-	if modelName != "" {
-		tx = tx.Where("model_name LIKE ?", "%"+modelName+"%")
+	// 各来源分别取前 startIdx+num 条，合并排序后再分页
+	limit := startIdx + num
+	conversations, total, err := ListConversations(userId, modelName, "", startTime, endTime, 0, limit)
+	if err != nil {
+		return nil, 0, err
 	}
-	if username != "" {
-		tx = tx.Where("username = ?", username)
+	archives, archiveTotal, err := GetArchivedConversations(userId, modelName, "", startTime, endTime, 0, limit)
+	if err != nil {
+		return nil, 0, err
 	}
-	if startTime > 0 {
-		tx = tx.Where("created_at >= ?", startTime)
+	for _, archive := range archives {
+		conversations = append(conversations, &ConversationWithSource{Conversation: archive.ToConversation(), Source: ConversationSourceArchive})
 	}
-	if endTime > 0 {
-		tx = tx.Where("created_at <= ?", endTime)
+	total += archiveTotal
+	indexes, coldTotal, err := GetColdArchivedConversations(0, userId, modelName, "", startTime, endTime, 0, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, index := range indexes {
+		conversations = append(conversations, &ConversationWithSource{Conversation: index.ToConversation(), Source: ConversationSourceCold})
 	}
+	total += coldTotal
 
-	result := tx.Delete(&Conversation{})
-	return result.RowsAffected, result.Error
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].CreatedAt > conversations[j].CreatedAt
+	})
+	if startIdx >= len(conversations) {
+		return []*ConversationWithSource{}, total, nil
+	}
+	conversations = conversations[startIdx:min(limit, len(conversations))]
+	formatUserConversations(conversations)
+	return conversations, total, nil
 }
 
-// DeleteOldConversations 删除旧的对话记录（用于定时清理）
-func DeleteOldConversations(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
-	var total int64 = 0
+// GetUserConversationById 查询用户自己的单条对话记录，不属于该用户时按不存在处理
+func GetUserConversationById(userId int, id int, source string) (*ConversationWithSource, error) {
+	conversation, err := GetConversationBySource(id, source)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.UserId != userId {
+		return nil, gorm.ErrRecordNotFound
+	}
+	formatUserConversations([]*ConversationWithSource{conversation})
+	return conversation, nil
+}
 
-	for {
-		if ctx.Err() != nil {
-			return total, ctx.Err()
-		}
+// DeleteUserConversations 删除用户自己的对话记录，返回实际删除的条数
+// 冷存储中的记录会从分段文件中抹除，不只是删除索引
+func DeleteUserConversations(userId int, ids []int, source string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	ctx := context.Background()
+	var table interface{} = &Conversation{}
+	switch source {
+	case ConversationSourceCold:
+		return deleteUserColdConversations(ctx, userId, ids)
+	case ConversationSourceCompressed:
+		table = &ConversationCompressed{}
+	case ConversationSourceArchive:
+		table = &ConversationArchive{}
+	default:
+		source = ConversationSourceMain
+	}
+	return deleteConversationRows(ctx, table, source, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("user_id = ? AND id IN ?", userId, ids)
+	}, conversationDeleteBatchSize)
+}
 
-		result := LOG_DB.Where("created_at < ?", targetTimestamp).Limit(limit).Delete(&Conversation{})
-		if result.Error != nil {
-			return total, result.Error
+// DeleteConversationsByCondition 按条件批量删除对话记录，主表和压缩表同时删除
+func DeleteConversationsByCondition(userId int, modelName string, username string, startTime int64, endTime int64) (int64, error) {
+	var total int64
+	for _, source := range []string{ConversationSourceMain, ConversationSourceCompressed} {
+		table := conversationSourceTables[source]
+		deleted, err := deleteConversationRows(context.Background(), table, source, func(tx *gorm.DB) *gorm.DB {
+			// 筛选条件
+			return applyConversationFilters(tx, userId, modelName, username, startTime, endTime)
+		}, conversationDeleteBatchSize)
+		total += deleted
+		if err != nil {
+			return total, err
 		}
+	}
+	return total, nil
+}
 
-		total += result.RowsAffected
+// DeleteOldConversations 删除主表和压缩表中旧的对话记录（用于定时清理）
+func DeleteOldConversations(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
+	var total int64 = 0
 
-		if result.RowsAffected < int64(limit) {
-			break
+	for _, source := range []string{ConversationSourceMain, ConversationSourceCompressed} {
+		table := conversationSourceTables[source]
+		deleted, err := deleteConversationRows(ctx, table, source, func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("created_at < ?", targetTimestamp)
+		}, limit)
+		total += deleted
+		if err != nil {
+			return total, err
 		}
 	}
 
 	return total, nil
 }
 
-// GetConversationStats 获取对话统计信息
+// GetConversationStats 获取对话统计信息，包含主表和压缩表
 func GetConversationStats(userId int, modelName string, startTime int64, endTime int64) (map[string]interface{}, error) {
-	var stats struct {
-		TotalCount       int64 `json:"total_count"`
-		TotalTokens      int64 `json:"total_tokens"`
+	type conversationStats struct {
+		TotalCount        int64 `json:"total_count"`
+		TotalTokens       int64 `json:"total_tokens"`
 		TotalPromptTokens int64 `json:"total_prompt_tokens"`
-		TotalCompTokens  int64 `json:"total_comp_tokens"`
+		TotalCompTokens   int64 `json:"total_comp_tokens"`
 	}
+	var stats conversationStats
 
-	tx := LOG_DB.Model(&Conversation{}).
-		Select("COUNT(*) as total_count, SUM(total_tokens) as total_tokens, SUM(prompt_tokens) as total_prompt_tokens, SUM(completion_tokens) as total_comp_tokens")
-
-	if userId > 0 {
-		tx = tx.Where("user_id = ?", userId)
-	}
-	if modelName != "" {
-		tx = tx.Where("model_name LIKE ?", "%"+modelName+"%")
-	}
-	if startTime > 0 {
-		tx = tx.Where("created_at >= ?", startTime)
-	}
-	if endTime > 0 {
-		tx = tx.Where("created_at <= ?", endTime)
-	}
+	for _, table := range []interface{}{&Conversation{}, &ConversationCompressed{}} {
+		var part conversationStats
+		tx := LOG_DB.Model(table).
+			Select("COUNT(*) as total_count, SUM(total_tokens) as total_tokens, SUM(prompt_tokens) as total_prompt_tokens, SUM(completion_tokens) as total_comp_tokens")
+		tx = applyConversationFilters(tx, userId, modelName, "", startTime, endTime)
 
-	err := tx.Scan(&stats).Error
-	if err != nil {
-		return nil, err
+		err := tx.Scan(&part).Error
+		if err != nil {
+			return nil, err
+		}
+		stats.TotalCount += part.TotalCount
+		stats.TotalTokens += part.TotalTokens
+		stats.TotalPromptTokens += part.TotalPromptTokens
+		stats.TotalCompTokens += part.TotalCompTokens
 	}
 
 	result := map[string]interface{}{
-		"total_count":        stats.TotalCount,
-		"total_tokens":       stats.TotalTokens,
+		"total_count":         stats.TotalCount,
+		"total_tokens":        stats.TotalTokens,
 		"total_prompt_tokens": stats.TotalPromptTokens,
-		"total_comp_tokens":  stats.TotalCompTokens,
+		"total_comp_tokens":   stats.TotalCompTokens,
 	}
 
 	return result, nil
@@ -29,102 +29,180 @@ type ConversationArchive struct {
 	UseTime          int    `json:"use_time" gorm:"default:0"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Group            string `json:"group" gorm:"index;default:''"`
-	ArchivedAt       int64  `json:"archived_at" gorm:"bigint;index"` // 归档时间
+	Format           string `json:"format" gorm:"type:varchar(32);default:''"`
+	RedactedRules    string `json:"redacted_rules" gorm:"type:varchar(255);default:''"`
+	SessionId        string `json:"session_id" gorm:"type:varchar(64);index;default:''"`    // 会话 id，客户端指定或自动生成
+	TurnIndex        int    `json:"turn_index" gorm:"default:0"`                            // 会话中的轮次，从 0 开始
+	MessagesHash     string `json:"messages_hash" gorm:"type:varchar(64);index;default:''"` // 完整消息列表的哈希，用于识别下一轮
+	ParentHash       string `json:"parent_hash" gorm:"type:varchar(64);default:''"`         // 上一轮的 MessagesHash
+	PrefixMessages   int    `json:"prefix_messages" gorm:"default:0"`                       // 省略的历史消息条数，大于 0 时只存储本轮新增消息
+	ArchivedAt       int64  `json:"archived_at" gorm:"bigint;index"`                        // 归档时间
 }
 
 func (ConversationArchive) TableName() string {
 	return "conversations_archive"
 }
 
+// ToConversation 转换为主表结构，便于与主表结果统一处理
+func (archive *ConversationArchive) ToConversation() *Conversation {
+	return &Conversation{
+		Id:               archive.Id,
+		UserId:           archive.UserId,
+		Username:         archive.Username,
+		ModelName:        archive.ModelName,
+		TokenId:          archive.TokenId,
+		TokenName:        archive.TokenName,
+		ChannelId:        archive.ChannelId,
+		RequestMessages:  archive.RequestMessages,
+		ResponseContent:  archive.ResponseContent,
+		PromptTokens:     archive.PromptTokens,
+		CompletionTokens: archive.CompletionTokens,
+		TotalTokens:      archive.TotalTokens,
+		IsStream:         archive.IsStream,
+		CreatedAt:        archive.CreatedAt,
+		UseTime:          archive.UseTime,
+		Ip:               archive.Ip,
+		Group:            archive.Group,
+		Format:           archive.Format,
+		RedactedRules:    archive.RedactedRules,
+		SessionId:        archive.SessionId,
+		TurnIndex:        archive.TurnIndex,
+		MessagesHash:     archive.MessagesHash,
+		ParentHash:       archive.ParentHash,
+		PrefixMessages:   archive.PrefixMessages,
+	}
+}
+
 // ArchiveOldConversations 归档旧对话到归档表
 // targetTimestamp: 归档此时间点之前的数据
 // batchSize: 每批处理的记录数
+// 主表和压缩表中的记录都会归档，压缩记录解压后写入
+// 配置了冷存储时写入分段文件，否则归档到 conversations_archive 表
 func ArchiveOldConversations(ctx context.Context, targetTimestamp int64, batchSize int) (int64, error) {
+	if store := getConversationSegmentStore(); store != nil {
+		return archiveConversationsToSegments(ctx, store, targetTimestamp, batchSize)
+	}
+
 	var totalArchived int64 = 0
 	archivedAt := time.Now().Unix()
 
-	for {
-		if ctx.Err() != nil {
-			return totalArchived, ctx.Err()
-		}
+	for _, source := range []string{ConversationSourceMain, ConversationSourceCompressed} {
+		for {
+			if ctx.Err() != nil {
+				return totalArchived, ctx.Err()
+			}
 
-		// 开启事务
-		err := LOG_DB.Transaction(func(tx *gorm.DB) error {
 			// 1. 查询需要归档的数据
-			var conversations []Conversation
-			if err := tx.Where("created_at < ?", targetTimestamp).
-				Limit(batchSize).
-				Find(&conversations).Error; err != nil {
-				return err
+			conversations, err := findConversationsBefore(source, targetTimestamp, batchSize)
+			if err != nil {
+				return totalArchived, err
 			}
 
 			if len(conversations) == 0 {
-				return nil // 没有数据需要归档
+				break // 没有数据需要归档
 			}
 
-			// 2. 转换为归档记录
-			archives := make([]ConversationArchive, len(conversations))
-			ids := make([]int, len(conversations))
-			for i, conv := range conversations {
-				archives[i] = ConversationArchive{
-					UserId:           conv.UserId,
-					Username:         conv.Username,
-					ModelName:        conv.ModelName,
-					TokenId:          conv.TokenId,
-					TokenName:        conv.TokenName,
-					ChannelId:        conv.ChannelId,
-					RequestMessages:  conv.RequestMessages,
-					ResponseContent:  conv.ResponseContent,
-					PromptTokens:     conv.PromptTokens,
-					CompletionTokens: conv.CompletionTokens,
-					TotalTokens:      conv.TotalTokens,
-					IsStream:         conv.IsStream,
-					CreatedAt:        conv.CreatedAt,
-					UseTime:          conv.UseTime,
-					Ip:               conv.Ip,
-					Group:            conv.Group,
-					ArchivedAt:       archivedAt,
-				}
-				ids[i] = conv.Id
+			// 只存储增量的会话记录还原为完整请求，归档后不再依赖上一轮
+			if err := newSessionExpandCache(ctx).expandAll(conversations); err != nil {
+				return totalArchived, err
 			}
 
-			// 3. 插入到归档表
-			if err := tx.Create(&archives).Error; err != nil {
-				return err
-			}
+			// 开启事务
+			err = LOG_DB.Transaction(func(tx *gorm.DB) error {
+				// 2. 转换为归档记录
+				archives := make([]ConversationArchive, len(conversations))
+				ids := make([]int, len(conversations))
+				for i, conv := range conversations {
+					archives[i] = ConversationArchive{
+						UserId:           conv.UserId,
+						Username:         conv.Username,
+						ModelName:        conv.ModelName,
+						TokenId:          conv.TokenId,
+						TokenName:        conv.TokenName,
+						ChannelId:        conv.ChannelId,
+						RequestMessages:  conv.RequestMessages,
+						ResponseContent:  conv.ResponseContent,
+						PromptTokens:     conv.PromptTokens,
+						CompletionTokens: conv.CompletionTokens,
+						TotalTokens:      conv.TotalTokens,
+						IsStream:         conv.IsStream,
+						CreatedAt:        conv.CreatedAt,
+						UseTime:          conv.UseTime,
+						Ip:               conv.Ip,
+						Group:            conv.Group,
+						Format:           conv.Format,
+						RedactedRules:    conv.RedactedRules,
+						SessionId:        conv.SessionId,
+						TurnIndex:        conv.TurnIndex,
+						MessagesHash:     conv.MessagesHash,
+						ParentHash:       conv.ParentHash,
+						PrefixMessages:   conv.PrefixMessages,
+						ArchivedAt:       archivedAt,
+					}
+					ids[i] = conv.Id
+				}
 
-			// 4. 从主表删除
-			if err := tx.Where("id IN ?", ids).Delete(&Conversation{}).Error; err != nil {
-				return err
-			}
+				// 3. 插入到归档表
+				if err := tx.Create(&archives).Error; err != nil {
+					return err
+				}
 
+				// 4. 从源表删除
+				return tx.Where("id IN ?", ids).Delete(conversationSourceTables[source]).Error
+			})
+
+			if err != nil {
+				return totalArchived, err
+			}
 			totalArchived += int64(len(conversations))
-			return nil
-		})
 
-		if err != nil {
-			return totalArchived, err
-		}
+			// 如果处理的数据少于 batchSize，说明已经处理完了
+			if len(conversations) < batchSize {
+				break
+			}
 
-		// 如果处理的数据少于 batchSize，说明已经处理完了
-		if totalArchived%int64(batchSize) != 0 {
-			break
+			// 休眠一下，避免持续占用数据库资源
+			time.Sleep(100 * time.Millisecond)
 		}
-
-		// 休眠一下，避免持续占用数据库资源
-		time.Sleep(100 * time.Millisecond)
 	}
 
 	return totalArchived, nil
 }
 
+// findConversationsBefore 按 id 升序读取主表或压缩表中 targetTimestamp 之前的一批记录，压缩表中的记录解压后返回
+func findConversationsBefore(source string, targetTimestamp int64, batchSize int) ([]*Conversation, error) {
+	if source == ConversationSourceCompressed {
+		var rows []*ConversationCompressed
+		if err := LOG_DB.Where("created_at < ?", targetTimestamp).Order("id ASC").Limit(batchSize).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		conversations := make([]*Conversation, len(rows))
+		for i, row := range rows {
+			conv, err := row.ToConversation()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress conversation %d: %w", row.Id, err)
+			}
+			conversations[i] = conv
+		}
+		return conversations, nil
+	}
+	var conversations []*Conversation
+	err := LOG_DB.Where("created_at < ?", targetTimestamp).Order("id ASC").Limit(batchSize).Find(&conversations).Error
+	return conversations, err
+}
+
 // GetConversationsWithArchive 从主表和归档表查询对话（统一查询接口）
-func GetConversationsWithArchive(userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int, searchArchive bool) ([]*Conversation, int64, error) {
+// 冷存储中的记录只返回元数据（source 为 cold），内容需按 id 单独加载
+func GetConversationsWithArchive(conversationId int, userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int, searchArchive bool) ([]*ConversationWithSource, int64, error) {
 	// 默认只查询主表
-	conversations, total, err := GetConversations(userId, modelName, username, startTime, endTime, startIdx, num)
+	mainConversations, total, err := GetConversations(userId, modelName, username, startTime, endTime, startIdx, num)
 	if err != nil {
 		return nil, 0, err
 	}
+	conversations := make([]*ConversationWithSource, 0, len(mainConversations))
+	for _, conv := range mainConversations {
+		conversations = append(conversations, &ConversationWithSource{Conversation: conv, Source: ConversationSourceMain})
+	}
 
 	// 如果需要查询归档表
 	if searchArchive {
@@ -135,28 +213,19 @@ func GetConversationsWithArchive(userId int, modelName string, username string,
 
 		// 合并结果
 		for _, archive := range archives {
-			conv := &Conversation{
-				Id:               archive.Id,
-				UserId:           archive.UserId,
-				Username:         archive.Username,
-				ModelName:        archive.ModelName,
-				TokenId:          archive.TokenId,
-				TokenName:        archive.TokenName,
-				ChannelId:        archive.ChannelId,
-				RequestMessages:  archive.RequestMessages,
-				ResponseContent:  archive.ResponseContent,
-				PromptTokens:     archive.PromptTokens,
-				CompletionTokens: archive.CompletionTokens,
-				TotalTokens:      archive.TotalTokens,
-				IsStream:         archive.IsStream,
-				CreatedAt:        archive.CreatedAt,
-				UseTime:          archive.UseTime,
-				Ip:               archive.Ip,
-				Group:            archive.Group,
-			}
-			conversations = append(conversations, conv)
+			conversations = append(conversations, &ConversationWithSource{Conversation: archive.ToConversation(), Source: ConversationSourceArchive})
 		}
 		total += archiveTotal
+
+		// 冷存储索引
+		indexes, coldTotal, err := GetColdArchivedConversations(conversationId, userId, modelName, username, startTime, endTime, startIdx, num)
+		if err != nil {
+			return conversations, total, err
+		}
+		for _, index := range indexes {
+			conversations = append(conversations, &ConversationWithSource{Conversation: index.ToConversation(), Source: ConversationSourceCold})
+		}
+		total += coldTotal
 	}
 
 	return conversations, total, nil
@@ -170,21 +239,7 @@ func GetArchivedConversations(userId int, modelName string, username string, sta
 	tx := LOG_DB.Model(&ConversationArchive{})
 
 	// 筛选条件
-	if userId > 0 {
-		tx = tx.Where("user_id = ?", userId)
-	}
-	if modelName != "" {
-		tx = tx.Where("model_name LIKE ?", "%"+modelName+"%")
-	}
-	if username != "" {
-		tx = tx.Where("username = ?", username)
-	}
-	if startTime > 0 {
-		tx = tx.Where("created_at >= ?", startTime)
-	}
-	if endTime > 0 {
-		tx = tx.Where("created_at <= ?", endTime)
-	}
+	tx = applyConversationFilters(tx, userId, modelName, username, startTime, endTime)
 
 	// 获取总数
 	err := tx.Count(&total).Error
@@ -198,32 +253,23 @@ func GetArchivedConversations(userId int, modelName string, username string, sta
 }
 
 // CleanupOldArchives 清理归档表中的超旧数据（比如1年以前的）
+// 同时清理冷存储索引及不再被引用的分段文件
 func CleanupOldArchives(ctx context.Context, targetTimestamp int64, batchSize int) (int64, error) {
 	var totalDeleted int64 = 0
 
-	for {
-		if ctx.Err() != nil {
-			return totalDeleted, ctx.Err()
-		}
-
-		result := LOG_DB.Where("created_at < ?", targetTimestamp).
-			Limit(batchSize).
-			Delete(&ConversationArchive{})
-
-		if result.Error != nil {
-			return totalDeleted, result.Error
-		}
-
-		totalDeleted += result.RowsAffected
-
-		if result.RowsAffected < int64(batchSize) {
-			break
+	if store := getConversationSegmentStore(); store != nil {
+		deleted, err := cleanupColdArchives(ctx, store, targetTimestamp, batchSize)
+		totalDeleted += deleted
+		if err != nil {
+			return totalDeleted, err
 		}
-
-		time.Sleep(100 * time.Millisecond)
 	}
 
-	return totalDeleted, nil
+	deleted, err := deleteConversationRows(ctx, &ConversationArchive{}, ConversationSourceArchive, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("created_at < ?", targetTimestamp)
+	}, batchSize)
+	totalDeleted += deleted
+	return totalDeleted, err
 }
 
 // GetTableSize 获取表的大小信息（仅支持 MySQL 和 PostgreSQL）
@@ -285,6 +331,9 @@ func OptimizeConversationTable() error {
 		if err := LOG_DB.Exec("VACUUM ANALYZE conversations_archive").Error; err != nil {
 			return err
 		}
+		if err := LOG_DB.Exec("VACUUM ANALYZE conversations_compressed").Error; err != nil {
+			return err
+		}
 	} else if !common.UsingSQLite {
 		// MySQL: OPTIMIZE TABLE
 		if err := LOG_DB.Exec("OPTIMIZE TABLE conversations").Error; err != nil {
@@ -293,6 +342,9 @@ func OptimizeConversationTable() error {
 		if err := LOG_DB.Exec("OPTIMIZE TABLE conversations_archive").Error; err != nil {
 			return err
 		}
+		if err := LOG_DB.Exec("OPTIMIZE TABLE conversations_compressed").Error; err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -317,8 +369,25 @@ func GetConversationTableStats() (map[string]interface{}, error) {
 		stats["archive_table_count"] = archiveCount
 	}
 
+	// 压缩表统计
+	var compressedCount int64
+	if err := LOG_DB.Model(&ConversationCompressed{}).Count(&compressedCount).Error; err != nil {
+		stats["compressed_table_count"] = 0
+	} else {
+		stats["compressed_table_count"] = compressedCount
+	}
+
+	// 冷存储索引统计
+	var coldCount int64
+	if err := LOG_DB.Model(&ConversationArchiveIndex{}).Count(&coldCount).Error; err != nil {
+		stats["cold_archive_count"] = 0
+	} else {
+		stats["cold_archive_count"] = coldCount
+	}
+	stats["cold_archive_enabled"] = IsConversationColdArchiveEnabled()
+
 	// 总计
-	stats["total_count"] = mainCount + archiveCount
+	stats["total_count"] = mainCount + archiveCount + compressedCount + coldCount
 
 	// 表大小（仅 MySQL/PostgreSQL）
 	if !common.UsingSQLite {
@@ -331,6 +400,11 @@ func GetConversationTableStats() (map[string]interface{}, error) {
 		if err == nil {
 			stats["archive_table_size"] = archiveSize
 		}
+
+		compressedSize, err := GetTableSize("conversations_compressed")
+		if err == nil {
+			stats["compressed_table_size"] = compressedSize
+		}
 	}
 
 	// 最老和最新的记录
@@ -0,0 +1,443 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// ConversationArchiveIndex 冷存储归档索引，内容保存在分段文件中，表中只保留元数据和位置
+type ConversationArchiveIndex struct {
+	Id               int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ConversationId   int    `json:"conversation_id" gorm:"index"` // 原记录 id
+	UserId           int    `json:"user_id" gorm:"index;not null"`
+	Username         string `json:"username" gorm:"index;not null;default:''"`
+	ModelName        string `json:"model_name" gorm:"index;not null;default:''"`
+	TokenId          int    `json:"token_id" gorm:"default:0"`
+	TokenName        string `json:"token_name" gorm:"default:''"`
+	ChannelId        int    `json:"channel_id" gorm:"default:0"`
+	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
+	TotalTokens      int    `json:"total_tokens" gorm:"default:0"`
+	IsStream         bool   `json:"is_stream" gorm:"default:false"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index;not null"`
+	UseTime          int    `json:"use_time" gorm:"default:0"`
+	Ip               string `json:"ip" gorm:"default:''"`
+	Group            string `json:"group" gorm:"default:''"`
+	Format           string `json:"format" gorm:"type:varchar(32);default:''"`
+	RedactedRules    string `json:"redacted_rules" gorm:"type:varchar(255);default:''"`
+	SessionId        string `json:"session_id" gorm:"type:varchar(64);index;default:''"`
+	TurnIndex        int    `json:"turn_index" gorm:"default:0"`
+	MessagesHash     string `json:"messages_hash" gorm:"type:varchar(64);index;default:''"`
+	SegmentKey       string `json:"segment_key" gorm:"type:varchar(255);index;not null"`
+	SegmentLine      int    `json:"segment_line"` // 在分段文件中的行号，从 0 开始
+	ArchivedAt       int64  `json:"archived_at" gorm:"bigint;index"`
+}
+
+func (ConversationArchiveIndex) TableName() string {
+	return "conversations_archive_index"
+}
+
+// ToConversation 转换为主表结构，只包含元数据，内容需通过 LoadColdArchivedConversation 加载
+func (index *ConversationArchiveIndex) ToConversation() *Conversation {
+	return &Conversation{
+		Id:               index.Id,
+		UserId:           index.UserId,
+		Username:         index.Username,
+		ModelName:        index.ModelName,
+		TokenId:          index.TokenId,
+		TokenName:        index.TokenName,
+		ChannelId:        index.ChannelId,
+		PromptTokens:     index.PromptTokens,
+		CompletionTokens: index.CompletionTokens,
+		TotalTokens:      index.TotalTokens,
+		IsStream:         index.IsStream,
+		CreatedAt:        index.CreatedAt,
+		UseTime:          index.UseTime,
+		Ip:               index.Ip,
+		Group:            index.Group,
+		Format:           index.Format,
+		RedactedRules:    index.RedactedRules,
+		SessionId:        index.SessionId,
+		TurnIndex:        index.TurnIndex,
+		MessagesHash:     index.MessagesHash,
+	}
+}
+
+// IsConversationColdArchiveEnabled 是否配置了冷存储归档
+func IsConversationColdArchiveEnabled() bool {
+	return getConversationSegmentStore() != nil
+}
+
+// encodeConversationSegment 将一批对话编码为 GZIP 压缩的 JSONL，每行一条记录
+func encodeConversationSegment(conversations []*Conversation) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, conv := range conversations {
+		line, err := common.Marshal(conv)
+		if err != nil {
+			gz.Close()
+			return nil, err
+		}
+		if _, err := gz.Write(append(line, '\n')); err != nil {
+			gz.Close()
+			return nil, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readConversationSegment 读取并解码整个分段文件
+func readConversationSegment(ctx context.Context, store ConversationSegmentStore, key string) ([]*Conversation, error) {
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var conversations []*Conversation
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		var conv Conversation
+		if err := common.Unmarshal(scanner.Bytes(), &conv); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, &conv)
+	}
+	return conversations, scanner.Err()
+}
+
+// offloadConversationsToSegment 将一批对话写入分段文件，再在同一事务中写入索引并删除源表记录
+// source 为源表模型（&Conversation{}、&ConversationCompressed{} 或 &ConversationArchive{}），sourceName 用于区分分段文件名，ids 为源表中的 id
+func offloadConversationsToSegment(ctx context.Context, store ConversationSegmentStore, source interface{}, sourceName string, ids []int, conversations []*Conversation, archivedAt int64) error {
+	// 分段中保存完整请求，冷存储记录不再依赖上一轮
+	if err := newSessionExpandCache(ctx).expandAll(conversations); err != nil {
+		return err
+	}
+	data, err := encodeConversationSegment(conversations)
+	if err != nil {
+		return err
+	}
+	now := time.Unix(archivedAt, 0).UTC()
+	key := fmt.Sprintf("conversations/%s/%s-%d-%d-%d.jsonl.gz", now.Format("2006/01/02"), sourceName, archivedAt, conversations[0].Id, conversations[len(conversations)-1].Id)
+	if err := store.Put(ctx, key, data); err != nil {
+		return err
+	}
+
+	indexes := make([]ConversationArchiveIndex, len(conversations))
+	for i, conv := range conversations {
+		indexes[i] = ConversationArchiveIndex{
+			ConversationId:   conv.Id,
+			UserId:           conv.UserId,
+			Username:         conv.Username,
+			ModelName:        conv.ModelName,
+			TokenId:          conv.TokenId,
+			TokenName:        conv.TokenName,
+			ChannelId:        conv.ChannelId,
+			PromptTokens:     conv.PromptTokens,
+			CompletionTokens: conv.CompletionTokens,
+			TotalTokens:      conv.TotalTokens,
+			IsStream:         conv.IsStream,
+			CreatedAt:        conv.CreatedAt,
+			UseTime:          conv.UseTime,
+			Ip:               conv.Ip,
+			Group:            conv.Group,
+			Format:           conv.Format,
+			RedactedRules:    conv.RedactedRules,
+			SessionId:        conv.SessionId,
+			TurnIndex:        conv.TurnIndex,
+			MessagesHash:     conv.MessagesHash,
+			SegmentKey:       key,
+			SegmentLine:      i,
+			ArchivedAt:       archivedAt,
+		}
+	}
+	err = LOG_DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&indexes).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(source).Error
+	})
+	if err != nil {
+		// 索引未写入，分段文件没有引用，尽量清理掉
+		if deleteErr := store.Delete(context.Background(), key); deleteErr != nil {
+			common.SysError("清理未引用的归档分段失败: " + deleteErr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// archiveConversationsToSegments 冷存储模式下的归档：
+// 先把主表和压缩表中 targetTimestamp 之前的记录写入分段文件（压缩记录解压后写入），再把 conversations_archive 表中已有的记录全部迁出
+func archiveConversationsToSegments(ctx context.Context, store ConversationSegmentStore, targetTimestamp int64, batchSize int) (int64, error) {
+	var totalArchived int64 = 0
+	archivedAt := time.Now().Unix()
+
+	for _, source := range []string{ConversationSourceMain, ConversationSourceCompressed} {
+		for {
+			if ctx.Err() != nil {
+				return totalArchived, ctx.Err()
+			}
+			conversations, err := findConversationsBefore(source, targetTimestamp, batchSize)
+			if err != nil {
+				return totalArchived, err
+			}
+			if len(conversations) == 0 {
+				break
+			}
+			ids := make([]int, len(conversations))
+			for i, conv := range conversations {
+				ids[i] = conv.Id
+			}
+			if err := offloadConversationsToSegment(ctx, store, conversationSourceTables[source], source, ids, conversations, archivedAt); err != nil {
+				return totalArchived, err
+			}
+			totalArchived += int64(len(conversations))
+			if len(conversations) < batchSize {
+				break
+			}
+			// 休眠一下，避免持续占用数据库资源
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return totalArchived, ctx.Err()
+		}
+		var archives []*ConversationArchive
+		if err := LOG_DB.Order("id ASC").Limit(batchSize).Find(&archives).Error; err != nil {
+			return totalArchived, err
+		}
+		if len(archives) == 0 {
+			break
+		}
+		ids := make([]int, len(archives))
+		conversations := make([]*Conversation, len(archives))
+		for i, archive := range archives {
+			ids[i] = archive.Id
+			conversations[i] = archive.ToConversation()
+		}
+		if err := offloadConversationsToSegment(ctx, store, &ConversationArchive{}, ConversationSourceArchive, ids, conversations, archivedAt); err != nil {
+			return totalArchived, err
+		}
+		totalArchived += int64(len(archives))
+		if len(archives) < batchSize {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return totalArchived, nil
+}
+
+// GetColdArchivedConversations 按与 GetConversations 相同的筛选条件查询冷存储索引
+// conversationId 大于 0 时按原记录 id 精确定位
+func GetColdArchivedConversations(conversationId int, userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int) ([]*ConversationArchiveIndex, int64, error) {
+	var indexes []*ConversationArchiveIndex
+	var total int64
+
+	tx := applyConversationFilters(LOG_DB.Model(&ConversationArchiveIndex{}), userId, modelName, username, startTime, endTime)
+	if conversationId > 0 {
+		tx = tx.Where("conversation_id = ?", conversationId)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := tx.Order("created_at DESC").Limit(num).Offset(startIdx).Find(&indexes).Error
+	return indexes, total, err
+}
+
+// LoadColdArchivedConversation 按索引 id 从分段文件中加载完整对话，返回记录的 id 为索引 id
+func LoadColdArchivedConversation(ctx context.Context, indexId int) (*Conversation, error) {
+	var index ConversationArchiveIndex
+	if err := LOG_DB.Where("id = ?", indexId).First(&index).Error; err != nil {
+		return nil, err
+	}
+	store := getConversationSegmentStore()
+	if store == nil {
+		return nil, fmt.Errorf("conversation archive storage is not configured")
+	}
+	conversations, err := readConversationSegment(ctx, store, index.SegmentKey)
+	if err != nil {
+		return nil, err
+	}
+	if index.SegmentLine >= len(conversations) {
+		return nil, fmt.Errorf("segment %s has no line %d", index.SegmentKey, index.SegmentLine)
+	}
+	conv := conversations[index.SegmentLine]
+	conv.Id = index.Id
+	return conv, nil
+}
+
+// cleanupColdArchives 删除 targetTimestamp 之前的冷存储索引，分段中的记录全部删除后再删除分段文件
+func cleanupColdArchives(ctx context.Context, store ConversationSegmentStore, targetTimestamp int64, batchSize int) (int64, error) {
+	var totalDeleted int64 = 0
+	for {
+		if ctx.Err() != nil {
+			return totalDeleted, ctx.Err()
+		}
+		var keys []string
+		if err := LOG_DB.Model(&ConversationArchiveIndex{}).Where("created_at < ?", targetTimestamp).
+			Distinct("segment_key").Limit(batchSize).Pluck("segment_key", &keys).Error; err != nil {
+			return totalDeleted, err
+		}
+		if len(keys) == 0 {
+			break
+		}
+		for _, key := range keys {
+			// 仍在热存储中的后续轮次可能依赖即将删除的冷存储记录
+			var rows []conversationDeleteRow
+			if err := LOG_DB.Model(&ConversationArchiveIndex{}).Select("id, user_id, session_id, messages_hash").
+				Where("segment_key = ? AND created_at < ?", key, targetTimestamp).Scan(&rows).Error; err != nil {
+				return totalDeleted, err
+			}
+			if err := detachConversationChildren(ctx, ConversationSourceCold, rows); err != nil {
+				return totalDeleted, err
+			}
+			result := LOG_DB.Where("segment_key = ? AND created_at < ?", key, targetTimestamp).Delete(&ConversationArchiveIndex{})
+			if result.Error != nil {
+				return totalDeleted, result.Error
+			}
+			totalDeleted += result.RowsAffected
+
+			var remaining int64
+			if err := LOG_DB.Model(&ConversationArchiveIndex{}).Where("segment_key = ?", key).Count(&remaining).Error; err != nil {
+				return totalDeleted, err
+			}
+			if remaining == 0 {
+				if err := store.Delete(ctx, key); err != nil {
+					return totalDeleted, err
+				}
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return totalDeleted, nil
+}
+
+// deleteUserColdConversations 删除用户自己的冷存储记录，分段中对应的行被清空后重新写回
+// 行号保持不变，分段中的记录全部删除后再删除分段文件
+func deleteUserColdConversations(ctx context.Context, userId int, ids []int) (int64, error) {
+	var indexes []*ConversationArchiveIndex
+	if err := LOG_DB.Where("user_id = ? AND id IN ?", userId, ids).Find(&indexes).Error; err != nil {
+		return 0, err
+	}
+	if len(indexes) == 0 {
+		return 0, nil
+	}
+	store := getConversationSegmentStore()
+	if store == nil {
+		return 0, fmt.Errorf("conversation archive storage is not configured")
+	}
+
+	rows := make([]conversationDeleteRow, 0, len(indexes))
+	lines := make(map[string][]*ConversationArchiveIndex)
+	for _, index := range indexes {
+		rows = append(rows, conversationDeleteRow{Id: index.Id, UserId: index.UserId, SessionId: index.SessionId, MessagesHash: index.MessagesHash})
+		lines[index.SegmentKey] = append(lines[index.SegmentKey], index)
+	}
+	if err := detachConversationChildren(ctx, ConversationSourceCold, rows); err != nil {
+		return 0, err
+	}
+
+	var totalDeleted int64 = 0
+	for key, segmentIndexes := range lines {
+		indexIds := make([]int, 0, len(segmentIndexes))
+		for _, index := range segmentIndexes {
+			indexIds = append(indexIds, index.Id)
+		}
+		var remaining int64
+		if err := LOG_DB.Model(&ConversationArchiveIndex{}).Where("segment_key = ? AND id NOT IN ?", key, indexIds).Count(&remaining).Error; err != nil {
+			return totalDeleted, err
+		}
+		if remaining > 0 {
+			conversations, err := readConversationSegment(ctx, store, key)
+			if err != nil {
+				return totalDeleted, err
+			}
+			for _, index := range segmentIndexes {
+				if index.SegmentLine < len(conversations) {
+					conversations[index.SegmentLine] = &Conversation{}
+				}
+			}
+			data, err := encodeConversationSegment(conversations)
+			if err != nil {
+				return totalDeleted, err
+			}
+			if err := store.Put(ctx, key, data); err != nil {
+				return totalDeleted, err
+			}
+		}
+		result := LOG_DB.Where("id IN ?", indexIds).Delete(&ConversationArchiveIndex{})
+		if result.Error != nil {
+			return totalDeleted, result.Error
+		}
+		totalDeleted += result.RowsAffected
+		if remaining == 0 {
+			if err := store.Delete(ctx, key); err != nil {
+				return totalDeleted, err
+			}
+		}
+	}
+	return totalDeleted, nil
+}
+
+// exportColdArchivedConversations 按索引 id 升序逐批导出冷存储中的对话，相邻记录通常在同一分段中，只缓存最近一个分段
+func exportColdArchivedConversations(ctx context.Context, userId int, modelName string, username string, startTime int64, endTime int64, fn func(conversations []*Conversation) error) error {
+	store := getConversationSegmentStore()
+	if store == nil {
+		return nil
+	}
+	var cachedKey string
+	var cachedSegment []*Conversation
+	lastId := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var indexes []*ConversationArchiveIndex
+		tx := applyConversationFilters(LOG_DB.Model(&ConversationArchiveIndex{}), userId, modelName, username, startTime, endTime)
+		err := tx.Where("id > ?", lastId).Order("id ASC").Limit(conversationExportBatchSize).Find(&indexes).Error
+		if err != nil {
+			return err
+		}
+		if len(indexes) == 0 {
+			return nil
+		}
+		conversations := make([]*Conversation, 0, len(indexes))
+		for _, index := range indexes {
+			if index.SegmentKey != cachedKey {
+				cachedSegment, err = readConversationSegment(ctx, store, index.SegmentKey)
+				if err != nil {
+					return err
+				}
+				cachedKey = index.SegmentKey
+			}
+			if index.SegmentLine < len(cachedSegment) {
+				conv := *cachedSegment[index.SegmentLine]
+				conv.Id = index.Id
+				conversations = append(conversations, &conv)
+			}
+		}
+		if err := fn(conversations); err != nil {
+			return err
+		}
+		lastId = indexes[len(indexes)-1].Id
+	}
+}
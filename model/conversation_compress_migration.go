@@ -0,0 +1,175 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// ConversationCompressMigrationProgress 批量迁移到压缩表的进度
+type ConversationCompressMigrationProgress struct {
+	Running    bool   `json:"running"`
+	Canceled   bool   `json:"canceled"`
+	Total      int64  `json:"total"`    // 启动时主表中待迁移的记录数
+	Migrated   int64  `json:"migrated"` // 已迁移并从主表删除的记录数
+	Failed     int64  `json:"failed"`   // 迁移失败、仍保留在主表中的记录数
+	StartedAt  int64  `json:"started_at"`
+	FinishedAt int64  `json:"finished_at"`
+	LastError  string `json:"last_error"`
+}
+
+var (
+	compressMigrationMutex    sync.Mutex
+	compressMigrationProgress ConversationCompressMigrationProgress
+	compressMigrationCancel   context.CancelFunc
+)
+
+var ErrCompressMigrationRunning = errors.New("compress migration is already running")
+
+// GetConversationCompressMigrationProgress 获取最近一次迁移任务的进度
+func GetConversationCompressMigrationProgress() ConversationCompressMigrationProgress {
+	compressMigrationMutex.Lock()
+	defer compressMigrationMutex.Unlock()
+	return compressMigrationProgress
+}
+
+// StartConversationCompressMigration 在后台将主表中的对话迁移到压缩表
+// targetTimestamp 大于 0 时只迁移该时间点之前的记录，同一时间只允许一个迁移任务
+func StartConversationCompressMigration(targetTimestamp int64, batchSize int) error {
+	compressMigrationMutex.Lock()
+	defer compressMigrationMutex.Unlock()
+	if compressMigrationProgress.Running {
+		return ErrCompressMigrationRunning
+	}
+
+	var total int64
+	tx := LOG_DB.Model(&Conversation{})
+	if targetTimestamp > 0 {
+		tx = tx.Where("created_at < ?", targetTimestamp)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	compressMigrationCancel = cancel
+	compressMigrationProgress = ConversationCompressMigrationProgress{
+		Running:   true,
+		Total:     total,
+		StartedAt: time.Now().Unix(),
+	}
+	go runConversationCompressMigration(ctx, cancel, targetTimestamp, batchSize)
+	return nil
+}
+
+// CancelConversationCompressMigration 取消正在执行的迁移任务，已提交的批次不会回滚
+func CancelConversationCompressMigration() bool {
+	compressMigrationMutex.Lock()
+	defer compressMigrationMutex.Unlock()
+	if !compressMigrationProgress.Running || compressMigrationCancel == nil {
+		return false
+	}
+	compressMigrationCancel()
+	return true
+}
+
+func updateCompressMigrationProgress(update func(progress *ConversationCompressMigrationProgress)) {
+	compressMigrationMutex.Lock()
+	defer compressMigrationMutex.Unlock()
+	update(&compressMigrationProgress)
+}
+
+func runConversationCompressMigration(ctx context.Context, cancel context.CancelFunc, targetTimestamp int64, batchSize int) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.SysError(fmt.Sprintf("对话压缩迁移异常退出: %v", r))
+			updateCompressMigrationProgress(func(progress *ConversationCompressMigrationProgress) {
+				progress.LastError = fmt.Sprintf("%v", r)
+			})
+		}
+		updateCompressMigrationProgress(func(progress *ConversationCompressMigrationProgress) {
+			progress.Running = false
+			progress.Canceled = ctx.Err() != nil
+			progress.FinishedAt = time.Now().Unix()
+		})
+		cancel()
+	}()
+
+	// 按 id 升序推进，失败的记录留在主表中并被跳过，避免反复重试同一批
+	lastId := 0
+	for ctx.Err() == nil {
+		var conversations []*Conversation
+		tx := LOG_DB.Where("id > ?", lastId)
+		if targetTimestamp > 0 {
+			tx = tx.Where("created_at < ?", targetTimestamp)
+		}
+		if err := tx.Order("id ASC").Limit(batchSize).Find(&conversations).Error; err != nil {
+			updateCompressMigrationProgress(func(progress *ConversationCompressMigrationProgress) {
+				progress.LastError = err.Error()
+			})
+			return
+		}
+		if len(conversations) == 0 {
+			break
+		}
+		lastId = conversations[len(conversations)-1].Id
+
+		compressedRows := make([]*ConversationCompressed, 0, len(conversations))
+		ids := make([]int, 0, len(conversations))
+		var failed int64
+		var lastErr error
+		for _, conv := range conversations {
+			compressed, err := newConversationCompressed(conv)
+			if err != nil {
+				failed++
+				lastErr = err
+				continue
+			}
+			compressedRows = append(compressedRows, compressed)
+			ids = append(ids, conv.Id)
+		}
+
+		var migrated int64
+		if len(compressedRows) > 0 {
+			err := LOG_DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&compressedRows).Error; err != nil {
+					return err
+				}
+				return tx.Where("id IN ?", ids).Delete(&Conversation{}).Error
+			})
+			if err != nil {
+				failed += int64(len(compressedRows))
+				lastErr = err
+			} else {
+				migrated = int64(len(compressedRows))
+			}
+		}
+
+		updateCompressMigrationProgress(func(progress *ConversationCompressMigrationProgress) {
+			progress.Migrated += migrated
+			progress.Failed += failed
+			if lastErr != nil {
+				progress.LastError = lastErr.Error()
+			}
+		})
+
+		if len(conversations) < batchSize {
+			break
+		}
+		// 休眠一下，避免持续占用数据库资源
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	progress := GetConversationCompressMigrationProgress()
+	common.SysLog(common.GetJsonString(map[string]interface{}{
+		"action":   "compress_conversations",
+		"migrated": progress.Migrated,
+		"failed":   progress.Failed,
+		"canceled": ctx.Err() != nil,
+	}))
+}
@@ -3,34 +3,46 @@ package model
 import (
 	"bytes"
 	"compress/gzip"
-	"encoding/json"
+	"context"
+	"fmt"
 	"io"
+	"sort"
 
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // ConversationCompressed 压缩存储版本的对话记录
 // 适用于长对话或需要节省存储空间的场景
 type ConversationCompressed struct {
-	Id               int    `json:"id" gorm:"primaryKey;autoIncrement"`
-	UserId           int    `json:"user_id" gorm:"index:idx_user_model_time;index;not null"`
-	Username         string `json:"username" gorm:"index;not null;default:''"`
-	ModelName        string `json:"model_name" gorm:"index:idx_user_model_time;index;not null;default:''"`
-	TokenId          int    `json:"token_id" gorm:"index;default:0"`
-	TokenName        string `json:"token_name" gorm:"default:''"`
-	ChannelId        int    `json:"channel_id" gorm:"index;default:0"`
-	RequestMessagesGz  []byte `json:"-" gorm:"type:blob"`                         // GZIP 压缩的请求消息
-	ResponseContentGz  []byte `json:"-" gorm:"type:blob"`                         // GZIP 压缩的响应内容
-	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
-	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
-	TotalTokens      int    `json:"total_tokens" gorm:"default:0"`
-	IsStream         bool   `json:"is_stream" gorm:"default:false"`
-	CreatedAt        int64  `json:"created_at" gorm:"bigint;index:idx_user_model_time;index;not null"`
-	UseTime          int    `json:"use_time" gorm:"default:0"`
-	Ip               string `json:"ip" gorm:"index;default:''"`
-	Group            string `json:"group" gorm:"index;default:''"`
-	CompressionRatio float64 `json:"compression_ratio" gorm:"default:0"` // 压缩率
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId    int    `json:"user_id" gorm:"index:idx_compressed_user_model_time;index;not null"`
+	Username  string `json:"username" gorm:"index;not null;default:''"`
+	ModelName string `json:"model_name" gorm:"index:idx_compressed_user_model_time;index;not null;default:''"`
+	TokenId   int    `json:"token_id" gorm:"index;default:0"`
+	TokenName string `json:"token_name" gorm:"default:''"`
+	ChannelId int    `json:"channel_id" gorm:"index;default:0"`
+	// 不指定列类型，由各数据库选择二进制类型（MySQL longblob / PostgreSQL bytea / SQLite blob）
+	RequestMessagesGz []byte  `json:"-"` // GZIP 压缩的请求消息
+	ResponseContentGz []byte  `json:"-"` // GZIP 压缩的响应内容
+	PromptTokens      int     `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens  int     `json:"completion_tokens" gorm:"default:0"`
+	TotalTokens       int     `json:"total_tokens" gorm:"default:0"`
+	IsStream          bool    `json:"is_stream" gorm:"default:false"`
+	CreatedAt         int64   `json:"created_at" gorm:"bigint;index:idx_compressed_user_model_time;index;not null"`
+	UseTime           int     `json:"use_time" gorm:"default:0"`
+	Ip                string  `json:"ip" gorm:"index;default:''"`
+	Group             string  `json:"group" gorm:"index;default:''"`
+	CompressionRatio  float64 `json:"compression_ratio" gorm:"default:0"` // 压缩率
+	Format            string  `json:"format" gorm:"type:varchar(32);default:''"`
+	RedactedRules     string  `json:"redacted_rules" gorm:"type:varchar(255);default:''"`
+	SessionId         string  `json:"session_id" gorm:"type:varchar(64);index;default:''"`    // 会话 id，客户端指定或自动生成
+	TurnIndex         int     `json:"turn_index" gorm:"default:0"`                            // 会话中的轮次，从 0 开始
+	MessagesHash      string  `json:"messages_hash" gorm:"type:varchar(64);index;default:''"` // 完整消息列表的哈希，用于识别下一轮
+	ParentHash        string  `json:"parent_hash" gorm:"type:varchar(64);default:''"`         // 上一轮的 MessagesHash
+	PrefixMessages    int     `json:"prefix_messages" gorm:"default:0"`                       // 省略的历史消息条数，大于 0 时只存储本轮新增消息
 }
 
 func (ConversationCompressed) TableName() string {
@@ -80,58 +92,67 @@ func DecompressString(compressed []byte) (string, error) {
 	return string(decompressed), nil
 }
 
-// RecordConversationCompressed 记录压缩版本的对话
-func RecordConversationCompressed(c *gin.Context, params RecordConversationParams) {
-	// 将 RequestMessages 转为 JSON 字符串
-	requestJSON, err := json.Marshal(params.RequestMessages)
-	if err != nil {
-		logger.LogError(c, "failed to marshal request messages: "+err.Error())
-		return
-	}
-
-	// 压缩请求消息
-	requestCompressed, err := CompressString(string(requestJSON))
+// newConversationCompressed 压缩对话内容，生成压缩表记录
+func newConversationCompressed(conv *Conversation) (*ConversationCompressed, error) {
+	requestCompressed, err := CompressString(conv.RequestMessages)
 	if err != nil {
-		logger.LogError(c, "failed to compress request: "+err.Error())
-		return
+		return nil, err
 	}
-
-	// 压缩响应内容
-	responseCompressed, err := CompressString(params.ResponseContent)
+	responseCompressed, err := CompressString(conv.ResponseContent)
 	if err != nil {
-		logger.LogError(c, "failed to compress response: "+err.Error())
-		return
+		return nil, err
 	}
 
 	// 计算压缩率
-	originalSize := len(requestJSON) + len(params.ResponseContent)
+	originalSize := len(conv.RequestMessages) + len(conv.ResponseContent)
 	compressedSize := len(requestCompressed) + len(responseCompressed)
 	compressionRatio := 0.0
 	if originalSize > 0 {
 		compressionRatio = float64(compressedSize) / float64(originalSize)
 	}
 
-	conversation := &ConversationCompressed{
-		UserId:           params.UserId,
-		Username:         params.Username,
-		ModelName:        params.ModelName,
-		TokenId:          params.TokenId,
-		TokenName:        params.TokenName,
-		ChannelId:        params.ChannelId,
-		RequestMessagesGz:  requestCompressed,
-		ResponseContentGz:  responseCompressed,
-		PromptTokens:     params.PromptTokens,
-		CompletionTokens: params.CompletionTokens,
-		TotalTokens:      params.PromptTokens + params.CompletionTokens,
-		IsStream:         params.IsStream,
-		CreatedAt:        params.CreatedAt,
-		UseTime:          params.UseTime,
-		Ip:               params.Ip,
-		Group:            params.Group,
-		CompressionRatio: compressionRatio,
-	}
-
-	err = LOG_DB.Create(conversation).Error
+	return &ConversationCompressed{
+		UserId:            conv.UserId,
+		Username:          conv.Username,
+		ModelName:         conv.ModelName,
+		TokenId:           conv.TokenId,
+		TokenName:         conv.TokenName,
+		ChannelId:         conv.ChannelId,
+		RequestMessagesGz: requestCompressed,
+		ResponseContentGz: responseCompressed,
+		PromptTokens:      conv.PromptTokens,
+		CompletionTokens:  conv.CompletionTokens,
+		TotalTokens:       conv.TotalTokens,
+		IsStream:          conv.IsStream,
+		CreatedAt:         conv.CreatedAt,
+		UseTime:           conv.UseTime,
+		Ip:                conv.Ip,
+		Group:             conv.Group,
+		CompressionRatio:  compressionRatio,
+		Format:            conv.Format,
+		RedactedRules:     conv.RedactedRules,
+		SessionId:         conv.SessionId,
+		TurnIndex:         conv.TurnIndex,
+		MessagesHash:      conv.MessagesHash,
+		ParentHash:        conv.ParentHash,
+		PrefixMessages:    conv.PrefixMessages,
+	}, nil
+}
+
+// RecordConversationCompressed 记录压缩版本的对话
+func RecordConversationCompressed(c *gin.Context, params RecordConversationParams) {
+	conversation, err := buildConversation(params)
+	if err != nil {
+		logger.LogError(c, "failed to marshal request messages: "+err.Error())
+		return
+	}
+	compressed, err := newConversationCompressed(conversation)
+	if err != nil {
+		logger.LogError(c, "failed to compress conversation: "+err.Error())
+		return
+	}
+
+	err = LOG_DB.Create(compressed).Error
 	if err != nil {
 		logger.LogError(c, "failed to record compressed conversation: "+err.Error())
 	}
@@ -145,6 +166,11 @@ func GetCompressedConversationById(id int) (*Conversation, error) {
 		return nil, err
 	}
 
+	return compressed.ToConversation()
+}
+
+// ToConversation 解压并转换为普通对话记录
+func (compressed *ConversationCompressed) ToConversation() (*Conversation, error) {
 	// 解压
 	requestMessages, err := DecompressString(compressed.RequestMessagesGz)
 	if err != nil {
@@ -175,6 +201,13 @@ func GetCompressedConversationById(id int) (*Conversation, error) {
 		UseTime:          compressed.UseTime,
 		Ip:               compressed.Ip,
 		Group:            compressed.Group,
+		Format:           compressed.Format,
+		RedactedRules:    compressed.RedactedRules,
+		SessionId:        compressed.SessionId,
+		TurnIndex:        compressed.TurnIndex,
+		MessagesHash:     compressed.MessagesHash,
+		ParentHash:       compressed.ParentHash,
+		PrefixMessages:   compressed.PrefixMessages,
 	}
 
 	return conversation, nil
@@ -190,48 +223,151 @@ func MigrateToCompressed(oldConvId int) error {
 	}
 
 	// 2. 压缩数据
-	requestCompressed, err := CompressString(conv.RequestMessages)
+	compressed, err := newConversationCompressed(&conv)
 	if err != nil {
 		return err
 	}
 
-	responseCompressed, err := CompressString(conv.ResponseContent)
-	if err != nil {
-		return err
+	// 3. 插入到压缩表并删除原记录
+	return LOG_DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(compressed).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&conv).Error
+	})
+}
+
+// DeleteCompressedConversations 批量删除压缩表中的对话记录，依赖这些记录的后续轮次先还原为完整请求
+func DeleteCompressedConversations(ids []int) error {
+	if len(ids) == 0 {
+		return nil
 	}
+	_, err := deleteConversationRows(context.Background(), &ConversationCompressed{}, ConversationSourceCompressed, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id IN ?", ids)
+	}, conversationDeleteBatchSize)
+	return err
+}
 
-	// 计算压缩率
-	originalSize := len(conv.RequestMessages) + len(conv.ResponseContent)
-	compressedSize := len(requestCompressed) + len(responseCompressed)
-	compressionRatio := float64(compressedSize) / float64(originalSize)
-
-	// 3. 插入到压缩表
-	compressed := &ConversationCompressed{
-		UserId:           conv.UserId,
-		Username:         conv.Username,
-		ModelName:        conv.ModelName,
-		TokenId:          conv.TokenId,
-		TokenName:        conv.TokenName,
-		ChannelId:        conv.ChannelId,
-		RequestMessagesGz:  requestCompressed,
-		ResponseContentGz:  responseCompressed,
-		PromptTokens:     conv.PromptTokens,
-		CompletionTokens: conv.CompletionTokens,
-		TotalTokens:      conv.TotalTokens,
-		IsStream:         conv.IsStream,
-		CreatedAt:        conv.CreatedAt,
-		UseTime:          conv.UseTime,
-		Ip:               conv.Ip,
-		Group:            conv.Group,
-		CompressionRatio: compressionRatio,
+// ListConversations 同时查询主表和压缩表，按时间倒序合并后分页
+// 两张表各取前 startIdx+num 条合并，只有落在当前页的压缩记录才会被解压
+func ListConversations(userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int) ([]*ConversationWithSource, int64, error) {
+	limit := startIdx + num
+
+	var conversations []*Conversation
+	var total int64
+	tx := applyConversationFilters(LOG_DB.Model(&Conversation{}), userId, modelName, username, startTime, endTime)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Order("created_at DESC").Limit(limit).Find(&conversations).Error; err != nil {
+		return nil, 0, err
+	}
+	results := make([]*ConversationWithSource, 0, len(conversations))
+	for _, conv := range conversations {
+		results = append(results, &ConversationWithSource{Conversation: conv, Source: ConversationSourceMain})
 	}
 
-	err = LOG_DB.Create(compressed).Error
+	// 压缩表先只取元数据，避免读取大量压缩内容
+	var compressedRows []*ConversationCompressed
+	var compressedTotal int64
+	tx = applyConversationFilters(LOG_DB.Model(&ConversationCompressed{}), userId, modelName, username, startTime, endTime)
+	if err := tx.Count(&compressedTotal).Error; err != nil {
+		return nil, 0, err
+	}
+	total += compressedTotal
+	if compressedTotal > 0 {
+		err := tx.Omit("request_messages_gz", "response_content_gz").Order("created_at DESC").Limit(limit).Find(&compressedRows).Error
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, row := range compressedRows {
+			conv, _ := row.ToConversation()
+			results = append(results, &ConversationWithSource{Conversation: conv, Source: ConversationSourceCompressed})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreatedAt > results[j].CreatedAt
+	})
+	if startIdx >= len(results) {
+		return []*ConversationWithSource{}, total, nil
+	}
+	results = results[startIdx:min(limit, len(results))]
+
+	// 解压当前页的压缩记录
+	var compressedIds []int
+	for _, result := range results {
+		if result.Source == ConversationSourceCompressed {
+			compressedIds = append(compressedIds, result.Id)
+		}
+	}
+	if len(compressedIds) > 0 {
+		var rows []*ConversationCompressed
+		if err := LOG_DB.Where("id IN ?", compressedIds).Find(&rows).Error; err != nil {
+			return nil, 0, err
+		}
+		decompressed := make(map[int]*Conversation, len(rows))
+		for _, row := range rows {
+			conv, err := row.ToConversation()
+			if err != nil {
+				return nil, 0, err
+			}
+			decompressed[row.Id] = conv
+		}
+		for _, result := range results {
+			if result.Source == ConversationSourceCompressed && decompressed[result.Id] != nil {
+				result.Conversation = decompressed[result.Id]
+			}
+		}
+	}
+	return results, total, nil
+}
+
+// GetConversationBySource 按来源表查询单条对话记录
+// source 为空时先查主表，不存在再查压缩表；冷存储记录从分段文件中加载
+// 只存储了增量消息的会话记录会还原为完整请求
+func GetConversationBySource(id int, source string) (*ConversationWithSource, error) {
+	result, err := getConversationBySource(id, source)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := ExpandConversation(context.Background(), result.Conversation); err != nil {
+		common.SysError(fmt.Sprintf("failed to expand conversation %d: %s", result.Id, err.Error()))
 	}
+	return result, nil
+}
 
-	// 4. 删除原记录
-	err = LOG_DB.Delete(&conv).Error
-	return err
+func getConversationBySource(id int, source string) (*ConversationWithSource, error) {
+	switch source {
+	case ConversationSourceCompressed:
+		conv, err := GetCompressedConversationById(id)
+		if err != nil {
+			return nil, err
+		}
+		return &ConversationWithSource{Conversation: conv, Source: ConversationSourceCompressed}, nil
+	case ConversationSourceMain:
+		conv, err := GetConversationById(id)
+		if err != nil {
+			return nil, err
+		}
+		return &ConversationWithSource{Conversation: conv, Source: ConversationSourceMain}, nil
+	case ConversationSourceArchive:
+		var archive ConversationArchive
+		if err := LOG_DB.Where("id = ?", id).First(&archive).Error; err != nil {
+			return nil, err
+		}
+		return &ConversationWithSource{Conversation: archive.ToConversation(), Source: ConversationSourceArchive}, nil
+	case ConversationSourceCold:
+		conv, err := LoadColdArchivedConversation(context.Background(), id)
+		if err != nil {
+			return nil, err
+		}
+		return &ConversationWithSource{Conversation: conv, Source: ConversationSourceCold}, nil
+	case "":
+		if result, err := getConversationBySource(id, ConversationSourceMain); err == nil {
+			return result, nil
+		}
+		return getConversationBySource(id, ConversationSourceCompressed)
+	}
+	return nil, fmt.Errorf("unknown conversation source: %s", source)
 }
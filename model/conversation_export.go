@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+)
+
+// conversationExportBatchSize 导出时每批读取的记录数，避免一次性加载全部数据
+const conversationExportBatchSize = 200
+
+// ExportConversations 按与 GetConversations 相同的筛选条件逐批遍历对话记录
+// 依次遍历主表、压缩表，includeArchive 为 true 时再遍历归档表和冷存储，均按 id 升序
+// fn 返回错误或 ctx 被取消时停止遍历
+func ExportConversations(ctx context.Context, userId int, modelName string, username string, startTime int64, endTime int64, includeArchive bool, fn func(conversations []*Conversation) error) error {
+	// 只存储增量的会话记录还原为完整请求后再交给调用方
+	expandCache := newSessionExpandCache(ctx)
+	emit := fn
+	fn = func(conversations []*Conversation) error {
+		if err := expandCache.expandAll(conversations); err != nil {
+			return err
+		}
+		return emit(conversations)
+	}
+
+	lastId := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var conversations []*Conversation
+		tx := applyConversationFilters(LOG_DB.Model(&Conversation{}), userId, modelName, username, startTime, endTime)
+		err := tx.Where("id > ?", lastId).Order("id ASC").Limit(conversationExportBatchSize).Find(&conversations).Error
+		if err != nil {
+			return err
+		}
+		if len(conversations) == 0 {
+			break
+		}
+		if err := fn(conversations); err != nil {
+			return err
+		}
+		lastId = conversations[len(conversations)-1].Id
+	}
+
+	// 压缩表逐条解压后交给 fn
+	lastId = 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var rows []*ConversationCompressed
+		tx := applyConversationFilters(LOG_DB.Model(&ConversationCompressed{}), userId, modelName, username, startTime, endTime)
+		err := tx.Where("id > ?", lastId).Order("id ASC").Limit(conversationExportBatchSize).Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		conversations := make([]*Conversation, 0, len(rows))
+		for _, row := range rows {
+			conv, err := row.ToConversation()
+			if err != nil {
+				return err
+			}
+			conversations = append(conversations, conv)
+		}
+		if err := fn(conversations); err != nil {
+			return err
+		}
+		lastId = rows[len(rows)-1].Id
+	}
+
+	if !includeArchive {
+		return nil
+	}
+
+	lastId = 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var archives []*ConversationArchive
+		tx := applyConversationFilters(LOG_DB.Model(&ConversationArchive{}), userId, modelName, username, startTime, endTime)
+		err := tx.Where("id > ?", lastId).Order("id ASC").Limit(conversationExportBatchSize).Find(&archives).Error
+		if err != nil {
+			return err
+		}
+		if len(archives) == 0 {
+			break
+		}
+		conversations := make([]*Conversation, 0, len(archives))
+		for _, archive := range archives {
+			conversations = append(conversations, archive.ToConversation())
+		}
+		if err := fn(conversations); err != nil {
+			return err
+		}
+		lastId = archives[len(archives)-1].Id
+	}
+
+	// 冷存储中的记录从分段文件中读取
+	return exportColdArchivedConversations(ctx, userId, modelName, username, startTime, endTime, fn)
+}
@@ -0,0 +1,460 @@
+package model
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// 全文检索：MySQL 使用 FULLTEXT(ngram) 索引，PostgreSQL 使用 tsvector 表达式索引，
+// SQLite 使用 FTS5 外部内容表；索引不可用时回退到 LIKE。
+// 压缩表的内容无法在数据库中检索，只能解压后逐条匹配，因此最多扫描最近的
+// conversationSearchCompressedScanLimit 条。
+
+const (
+	conversationSearchCompressedScanLimit = 2000
+	conversationSearchSnippetRadius       = 60
+	conversationSearchMaxHighlights       = 3
+)
+
+// ConversationHighlight 命中片段，Snippet 已做 HTML 转义，命中词使用 <mark> 包裹
+type ConversationHighlight struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
+
+// ConversationSearchResult 检索结果
+type ConversationSearchResult struct {
+	*ConversationWithSource
+	Highlights []ConversationHighlight `json:"highlights"`
+}
+
+// ConversationSearchParams 检索参数，筛选条件与 GetConversations 一致
+type ConversationSearchParams struct {
+	Keyword           string
+	Phrase            bool // true 时按整句匹配，否则要求所有词都出现
+	UserId            int
+	ModelName         string
+	Username          string
+	StartTime         int64
+	EndTime           int64
+	IncludeArchive    bool
+	IncludeCompressed bool
+}
+
+// terms 返回需要全部命中的检索词
+func (p *ConversationSearchParams) terms() []string {
+	keyword := strings.TrimSpace(p.Keyword)
+	if keyword == "" {
+		return nil
+	}
+	if p.Phrase {
+		return []string{keyword}
+	}
+	return strings.Fields(keyword)
+}
+
+var (
+	conversationFTSIndexMutex   sync.Mutex
+	conversationFTSIndexReady   = map[string]bool{}
+	conversationFTSIndexChecked = map[string]time.Time{}
+)
+
+func conversationFTSIndexName(table string) string {
+	return "ft_" + table + "_content"
+}
+
+func conversationFTSTableName(table string) string {
+	return table + "_fts"
+}
+
+// conversationFTSAvailable 检查全文索引是否已建好，已建好的结果会被缓存，未建好的每分钟重新检查
+func conversationFTSAvailable(table string) bool {
+	conversationFTSIndexMutex.Lock()
+	defer conversationFTSIndexMutex.Unlock()
+	if conversationFTSIndexReady[table] {
+		return true
+	}
+	if checkedAt, ok := conversationFTSIndexChecked[table]; ok && time.Since(checkedAt) < time.Minute {
+		return false
+	}
+	conversationFTSIndexChecked[table] = time.Now()
+	var count int64
+	var err error
+	switch common.LogSqlType {
+	case common.DatabaseTypeMySQL:
+		err = LOG_DB.Raw("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?",
+			table, conversationFTSIndexName(table)).Scan(&count).Error
+	case common.DatabaseTypePostgreSQL:
+		// 并发创建中断后会留下无效索引，只统计 indisvalid 的索引
+		err = LOG_DB.Raw("SELECT COUNT(*) FROM pg_indexes i JOIN pg_class c ON c.relname = i.indexname JOIN pg_index x ON x.indexrelid = c.oid WHERE i.tablename = ? AND i.indexname = ? AND x.indisvalid",
+			table, conversationFTSIndexName(table)).Scan(&count).Error
+	default:
+		err = LOG_DB.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+			conversationFTSTableName(table)).Scan(&count).Error
+	}
+	ready := err == nil && count > 0
+	conversationFTSIndexReady[table] = ready
+	return ready
+}
+
+var conversationFTSBuildLock sync.Mutex
+
+// EnsureConversationSearchIndexes 为主表和归档表创建全文索引，失败时检索自动回退到 LIKE
+// PostgreSQL 使用 CREATE INDEX CONCURRENTLY，不阻塞写入，可以在启动时执行；
+// MySQL 首次创建 FULLTEXT 索引会重建整张表，SQLite 需要为已有数据重建 FTS 表，两者都会长时间阻塞写入，
+// 只在 allowBlocking 为 true（管理员手动触发）时执行。返回 false 表示已有建索引任务在运行
+func EnsureConversationSearchIndexes(allowBlocking bool) bool {
+	if !conversationFTSBuildLock.TryLock() {
+		return false
+	}
+	defer conversationFTSBuildLock.Unlock()
+	if common.LogSqlType != common.DatabaseTypePostgreSQL && !allowBlocking {
+		return true
+	}
+	for _, table := range []string{(Conversation{}).TableName(), (ConversationArchive{}).TableName()} {
+		if conversationFTSAvailable(table) {
+			continue
+		}
+		start := time.Now()
+		var err error
+		switch common.LogSqlType {
+		case common.DatabaseTypeMySQL:
+			err = LOG_DB.Exec(fmt.Sprintf("CREATE FULLTEXT INDEX %s ON %s (request_messages, response_content) WITH PARSER ngram",
+				conversationFTSIndexName(table), table)).Error
+		case common.DatabaseTypePostgreSQL:
+			err = createPostgresConversationFTS(table)
+		default:
+			err = createSQLiteConversationFTS(table)
+		}
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to create full-text index for %s, falling back to LIKE search: %s", table, err.Error()))
+			continue
+		}
+		conversationFTSIndexMutex.Lock()
+		conversationFTSIndexReady[table] = true
+		conversationFTSIndexMutex.Unlock()
+		common.SysLog(fmt.Sprintf("full-text index for %s is ready, took %s", table, time.Since(start)))
+	}
+	return true
+}
+
+// createPostgresConversationFTS 并发创建 GIN 索引，之前中断留下的无效索引先删除
+// CONCURRENTLY 不能在事务中执行，这里直接使用 LOG_DB
+func createPostgresConversationFTS(table string) error {
+	index := conversationFTSIndexName(table)
+	if err := LOG_DB.Exec(fmt.Sprintf("DROP INDEX CONCURRENTLY IF EXISTS %s", index)).Error; err != nil {
+		return err
+	}
+	return LOG_DB.Exec(fmt.Sprintf("CREATE INDEX CONCURRENTLY %s ON %s USING GIN (to_tsvector('simple', coalesce(request_messages, '') || ' ' || coalesce(response_content, '')))",
+		index, table)).Error
+}
+
+// createSQLiteConversationFTS 创建 FTS5 外部内容表，并通过触发器与原表保持同步
+func createSQLiteConversationFTS(table string) error {
+	fts := conversationFTSTableName(table)
+	statements := []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(request_messages, response_content, content='%s', content_rowid='id')", fts, table),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_ai AFTER INSERT ON %[2]s BEGIN
+	INSERT INTO %[1]s(rowid, request_messages, response_content) VALUES (new.id, new.request_messages, new.response_content);
+END`, fts, table),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_ad AFTER DELETE ON %[2]s BEGIN
+	INSERT INTO %[1]s(%[1]s, rowid, request_messages, response_content) VALUES ('delete', old.id, old.request_messages, old.response_content);
+END`, fts, table),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_au AFTER UPDATE ON %[2]s BEGIN
+	INSERT INTO %[1]s(%[1]s, rowid, request_messages, response_content) VALUES ('delete', old.id, old.request_messages, old.response_content);
+	INSERT INTO %[1]s(rowid, request_messages, response_content) VALUES (new.id, new.request_messages, new.response_content);
+END`, fts, table),
+		// 为已有数据建立索引
+		fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')", fts),
+	}
+	return LOG_DB.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// escapeLikeKeyword 转义 LIKE 中的通配符
+func escapeLikeKeyword(keyword string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(keyword) + "%"
+}
+
+// applyConversationKeyword 在主表或归档表上追加关键词条件
+func applyConversationKeyword(tx *gorm.DB, table string, params *ConversationSearchParams) *gorm.DB {
+	terms := params.terms()
+	if !conversationFTSAvailable(table) {
+		for _, term := range terms {
+			pattern := escapeLikeKeyword(term)
+			switch common.LogSqlType {
+			case common.DatabaseTypePostgreSQL:
+				tx = tx.Where("(request_messages ILIKE ? OR response_content ILIKE ?)", pattern, pattern)
+			case common.DatabaseTypeMySQL:
+				tx = tx.Where("(request_messages LIKE ? OR response_content LIKE ?)", pattern, pattern)
+			default:
+				// SQLite 没有默认的转义字符
+				tx = tx.Where(`(request_messages LIKE ? ESCAPE '\' OR response_content LIKE ? ESCAPE '\')`, pattern, pattern)
+			}
+		}
+		return tx
+	}
+	switch common.LogSqlType {
+	case common.DatabaseTypeMySQL:
+		// 布尔模式：短语整体加引号，多个词均加 + 表示必须出现
+		var query string
+		if params.Phrase {
+			query = `"` + strings.ReplaceAll(terms[0], `"`, " ") + `"`
+		} else {
+			parts := make([]string, 0, len(terms))
+			for _, term := range terms {
+				parts = append(parts, `+"`+strings.ReplaceAll(term, `"`, " ")+`"`)
+			}
+			query = strings.Join(parts, " ")
+		}
+		return tx.Where("MATCH(request_messages, response_content) AGAINST (? IN BOOLEAN MODE)", query)
+	case common.DatabaseTypePostgreSQL:
+		tsquery := "plainto_tsquery('simple', ?)"
+		if params.Phrase {
+			tsquery = "phraseto_tsquery('simple', ?)"
+		}
+		return tx.Where("to_tsvector('simple', coalesce(request_messages, '') || ' ' || coalesce(response_content, '')) @@ "+tsquery, strings.TrimSpace(params.Keyword))
+	default:
+		// FTS5：每个词用双引号包裹，空格分隔表示 AND
+		parts := make([]string, 0, len(terms))
+		for _, term := range terms {
+			parts = append(parts, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+		}
+		return tx.Where(fmt.Sprintf("id IN (SELECT rowid FROM %s WHERE %s MATCH ?)", conversationFTSTableName(table), conversationFTSTableName(table)), strings.Join(parts, " "))
+	}
+}
+
+// SearchConversations 在主表、归档表和压缩表中检索对话内容
+// 各来源分别取前 startIdx+num 条按时间倒序合并，再截取当前页
+// 压缩表只扫描最近的记录，返回的 compressedTruncated 表示是否未扫描完
+func SearchConversations(params ConversationSearchParams, startIdx int, num int) (results []*ConversationSearchResult, total int64, compressedTruncated bool, err error) {
+	terms := params.terms()
+	if len(terms) == 0 {
+		return nil, 0, false, fmt.Errorf("keyword is empty")
+	}
+	limit := startIdx + num
+
+	// 主表
+	var conversations []*Conversation
+	mainTable := (Conversation{}).TableName()
+	tx := applyConversationFilters(LOG_DB.Model(&Conversation{}), params.UserId, params.ModelName, params.Username, params.StartTime, params.EndTime)
+	tx = applyConversationKeyword(tx, mainTable, &params)
+	var count int64
+	if err = tx.Count(&count).Error; err != nil {
+		return nil, 0, false, err
+	}
+	total += count
+	if err = tx.Order("created_at DESC").Limit(limit).Find(&conversations).Error; err != nil {
+		return nil, 0, false, err
+	}
+	for _, conv := range conversations {
+		results = append(results, &ConversationSearchResult{ConversationWithSource: &ConversationWithSource{Conversation: conv, Source: ConversationSourceMain}})
+	}
+
+	// 归档表
+	if params.IncludeArchive {
+		var archives []*ConversationArchive
+		archiveTable := (ConversationArchive{}).TableName()
+		tx = applyConversationFilters(LOG_DB.Model(&ConversationArchive{}), params.UserId, params.ModelName, params.Username, params.StartTime, params.EndTime)
+		tx = applyConversationKeyword(tx, archiveTable, &params)
+		if err = tx.Count(&count).Error; err != nil {
+			return nil, 0, false, err
+		}
+		total += count
+		if err = tx.Order("created_at DESC").Limit(limit).Find(&archives).Error; err != nil {
+			return nil, 0, false, err
+		}
+		for _, archive := range archives {
+			results = append(results, &ConversationSearchResult{ConversationWithSource: &ConversationWithSource{Conversation: archive.ToConversation(), Source: ConversationSourceArchive}})
+		}
+	}
+
+	// 压缩表
+	if params.IncludeCompressed && LOG_DB.Migrator().HasTable(&ConversationCompressed{}) {
+		var matched []*Conversation
+		var matchedCount int64
+		matched, matchedCount, compressedTruncated, err = searchCompressedConversations(&params, terms, limit)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		total += matchedCount
+		for _, conv := range matched {
+			results = append(results, &ConversationSearchResult{ConversationWithSource: &ConversationWithSource{Conversation: conv, Source: ConversationSourceCompressed}})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreatedAt > results[j].CreatedAt
+	})
+	if startIdx >= len(results) {
+		return []*ConversationSearchResult{}, total, compressedTruncated, nil
+	}
+	results = results[startIdx:min(limit, len(results))]
+	for _, result := range results {
+		result.Highlights = buildConversationHighlights(result.Conversation, terms)
+	}
+	return results, total, compressedTruncated, nil
+}
+
+// searchCompressedConversations 解压最近的压缩记录并逐条匹配，最多返回 limit 条
+// matchedCount 为已扫描范围内的命中总数
+func searchCompressedConversations(params *ConversationSearchParams, terms []string, limit int) (matched []*Conversation, matchedCount int64, truncated bool, err error) {
+	const batchSize = 200
+	scanned := 0
+	lastCreatedAt, lastId := int64(0), 0
+	for scanned < conversationSearchCompressedScanLimit {
+		var rows []*ConversationCompressed
+		tx := applyConversationFilters(LOG_DB.Model(&ConversationCompressed{}), params.UserId, params.ModelName, params.Username, params.StartTime, params.EndTime)
+		if scanned > 0 {
+			tx = tx.Where("(created_at < ? OR (created_at = ? AND id < ?))", lastCreatedAt, lastCreatedAt, lastId)
+		}
+		if err = tx.Order("created_at DESC, id DESC").Limit(batchSize).Find(&rows).Error; err != nil {
+			return nil, 0, false, err
+		}
+		for _, row := range rows {
+			conv, decompressErr := row.ToConversation()
+			if decompressErr != nil {
+				continue
+			}
+			if conversationContainsTerms(conv, terms) {
+				matchedCount++
+				if len(matched) < limit {
+					matched = append(matched, conv)
+				}
+			}
+		}
+		scanned += len(rows)
+		if len(rows) < batchSize {
+			return matched, matchedCount, false, nil
+		}
+		lastCreatedAt, lastId = rows[len(rows)-1].CreatedAt, rows[len(rows)-1].Id
+	}
+	return matched, matchedCount, true, nil
+}
+
+func conversationContainsTerms(conv *Conversation, terms []string) bool {
+	request := strings.ToLower(conv.RequestMessages)
+	response := strings.ToLower(conv.ResponseContent)
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		if !strings.Contains(request, term) && !strings.Contains(response, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildConversationHighlights 在请求和响应中截取命中片段
+func buildConversationHighlights(conv *Conversation, terms []string) []ConversationHighlight {
+	highlights := make([]ConversationHighlight, 0)
+	fields := []struct {
+		name    string
+		content string
+	}{
+		{"request_messages", conv.RequestMessages},
+		{"response_content", conv.ResponseContent},
+	}
+	for _, field := range fields {
+		for _, snippet := range buildSnippets(field.content, terms) {
+			if len(highlights) >= conversationSearchMaxHighlights {
+				return highlights
+			}
+			highlights = append(highlights, ConversationHighlight{Field: field.name, Snippet: snippet})
+		}
+	}
+	return highlights
+}
+
+// buildSnippets 以每个命中位置为中心截取片段，相互重叠的片段会合并
+func buildSnippets(content string, terms []string) []string {
+	if content == "" {
+		return nil
+	}
+	lower := strings.ToLower(content)
+	// ToLower 可能改变字节长度，此时无法用下标对应原文，直接在小写文本上截取
+	if len(lower) != len(content) {
+		content = lower
+	}
+	type span struct{ start, end int }
+	var matches []span
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		if term == "" {
+			continue
+		}
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], term)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			matches = append(matches, span{start, start + len(term)})
+			offset = start + len(term)
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+
+	var snippets []string
+	for i := 0; i < len(matches) && len(snippets) < conversationSearchMaxHighlights; {
+		windowStart := runeBoundaryBefore(content, matches[i].start, conversationSearchSnippetRadius)
+		windowEnd := runeBoundaryAfter(content, matches[i].end, conversationSearchSnippetRadius)
+		var builder strings.Builder
+		if windowStart > 0 {
+			builder.WriteString("…")
+		}
+		last := windowStart
+		for ; i < len(matches) && matches[i].start < windowEnd; i++ {
+			if matches[i].start < last {
+				continue
+			}
+			end := min(matches[i].end, windowEnd)
+			builder.WriteString(html.EscapeString(content[last:matches[i].start]))
+			builder.WriteString("<mark>")
+			builder.WriteString(html.EscapeString(content[matches[i].start:end]))
+			builder.WriteString("</mark>")
+			last = end
+		}
+		builder.WriteString(html.EscapeString(content[last:windowEnd]))
+		if windowEnd < len(content) {
+			builder.WriteString("…")
+		}
+		snippets = append(snippets, builder.String())
+	}
+	return snippets
+}
+
+// runeBoundaryBefore 从 pos 向前移动 n 个字符
+func runeBoundaryBefore(s string, pos int, n int) int {
+	for ; n > 0 && pos > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(s[:pos])
+		pos -= size
+	}
+	return pos
+}
+
+// runeBoundaryAfter 从 pos 向后移动 n 个字符
+func runeBoundaryAfter(s string, pos int, n int) int {
+	for ; n > 0 && pos < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[pos:])
+		pos += size
+	}
+	return pos
+}
@@ -0,0 +1,210 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// 归档存储后端，通过环境变量 CONVERSATION_ARCHIVE_STORAGE 选择
+const (
+	ConversationArchiveStorageDB    = "db"    // 默认，归档到 conversations_archive 表
+	ConversationArchiveStorageLocal = "local" // 本地目录
+	ConversationArchiveStorageS3    = "s3"    // S3 兼容的对象存储
+)
+
+var ErrSegmentNotFound = errors.New("archive segment not found")
+
+// ConversationSegmentStore 归档分段文件的存储后端
+type ConversationSegmentStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	conversationSegmentStore     ConversationSegmentStore
+	conversationSegmentStoreOnce sync.Once
+)
+
+// getConversationSegmentStore 返回配置的分段存储，使用数据库归档时返回 nil
+func getConversationSegmentStore() ConversationSegmentStore {
+	conversationSegmentStoreOnce.Do(func() {
+		store, err := newConversationSegmentStoreFromEnv()
+		if err != nil {
+			common.SysError("对话归档存储配置无效，回退到数据库归档: " + err.Error())
+			return
+		}
+		conversationSegmentStore = store
+	})
+	return conversationSegmentStore
+}
+
+func newConversationSegmentStoreFromEnv() (ConversationSegmentStore, error) {
+	switch storage := common.GetEnvOrDefaultString("CONVERSATION_ARCHIVE_STORAGE", ConversationArchiveStorageDB); storage {
+	case ConversationArchiveStorageDB, "":
+		return nil, nil
+	case ConversationArchiveStorageLocal:
+		return &localSegmentStore{
+			dir: common.GetEnvOrDefaultString("CONVERSATION_ARCHIVE_DIR", "conversation_archive"),
+		}, nil
+	case ConversationArchiveStorageS3:
+		store := &s3SegmentStore{
+			endpoint: strings.TrimRight(os.Getenv("CONVERSATION_ARCHIVE_S3_ENDPOINT"), "/"),
+			bucket:   os.Getenv("CONVERSATION_ARCHIVE_S3_BUCKET"),
+			region:   common.GetEnvOrDefaultString("CONVERSATION_ARCHIVE_S3_REGION", "us-east-1"),
+			prefix:   strings.Trim(os.Getenv("CONVERSATION_ARCHIVE_S3_PREFIX"), "/"),
+			credentials: aws.Credentials{
+				AccessKeyID:     os.Getenv("CONVERSATION_ARCHIVE_S3_ACCESS_KEY"),
+				SecretAccessKey: os.Getenv("CONVERSATION_ARCHIVE_S3_SECRET_KEY"),
+			},
+			signer: v4.NewSigner(func(options *v4.SignerOptions) {
+				// S3 的对象路径不做二次转义
+				options.DisableURIPathEscaping = true
+			}),
+			client: &http.Client{Timeout: 60 * time.Second},
+		}
+		if store.endpoint == "" || store.bucket == "" {
+			return nil, errors.New("CONVERSATION_ARCHIVE_S3_ENDPOINT and CONVERSATION_ARCHIVE_S3_BUCKET are required")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown archive storage: %s", storage)
+	}
+}
+
+// localSegmentStore 将分段文件保存在本地目录，key 即相对路径
+type localSegmentStore struct {
+	dir string
+}
+
+func (s *localSegmentStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid segment key: %s", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *localSegmentStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免读到写了一半的分段
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *localSegmentStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSegmentNotFound
+	}
+	return data, err
+}
+
+func (s *localSegmentStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// s3SegmentStore 通过 SigV4 签名的 HTTP 请求访问 S3 兼容存储（AWS S3 / MinIO / R2 等），使用 path-style 地址
+type s3SegmentStore struct {
+	endpoint    string
+	bucket      string
+	region      string
+	prefix      string
+	credentials aws.Credentials
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+func (s *s3SegmentStore) do(ctx context.Context, method string, key string, body []byte) (*http.Response, error) {
+	objectKey := key
+	if s.prefix != "" {
+		objectKey = s.prefix + "/" + key
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+s.bucket+"/"+objectKey, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if err := s.signer.SignHTTP(ctx, s.credentials, req, payloadHash, "s3", s.region, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+func (s *s3SegmentStore) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put segment %s failed: status %d, %s", key, resp.StatusCode, string(message))
+	}
+	return nil
+}
+
+func (s *s3SegmentStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrSegmentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("get segment %s failed: status %d, %s", key, resp.StatusCode, string(message))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3SegmentStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("delete segment %s failed: status %d, %s", key, resp.StatusCode, string(message))
+	}
+	return nil
+}
@@ -0,0 +1,712 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+	"gorm.io/gorm"
+)
+
+// maxSessionPrefixCandidates 识别上一轮时最多比对的前缀数量
+const maxSessionPrefixCandidates = 64
+
+// conversationMessageList 记录中的消息列表，rebuild 用新的消息列表重新生成请求 JSON
+type conversationMessageList struct {
+	messages []json.RawMessage
+	rebuild  func(messages []json.RawMessage) (string, error)
+}
+
+// parseConversationMessageList 按请求格式取出消息列表
+// openai 记录本身就是 messages 数组，其余格式为请求对象中的 messages / contents / input 字段
+func parseConversationMessageList(format string, requestMessages string) (*conversationMessageList, bool) {
+	var field string
+	switch format {
+	case "", string(types.RelayFormatOpenAI):
+		var messages []json.RawMessage
+		if err := common.UnmarshalJsonStr(requestMessages, &messages); err != nil {
+			return nil, false
+		}
+		return &conversationMessageList{
+			messages: messages,
+			rebuild: func(messages []json.RawMessage) (string, error) {
+				data, err := common.Marshal(messages)
+				return string(data), err
+			},
+		}, true
+	case string(types.RelayFormatClaude):
+		field = "messages"
+	case string(types.RelayFormatGemini):
+		field = "contents"
+	case string(types.RelayFormatOpenAIResponses):
+		field = "input"
+	default:
+		return nil, false
+	}
+	var request map[string]json.RawMessage
+	if err := common.UnmarshalJsonStr(requestMessages, &request); err != nil {
+		return nil, false
+	}
+	var messages []json.RawMessage
+	// Responses 的 input 可能是字符串，此时没有可比对的历史
+	if err := common.Unmarshal(request[field], &messages); err != nil {
+		return nil, false
+	}
+	return &conversationMessageList{
+		messages: messages,
+		rebuild: func(messages []json.RawMessage) (string, error) {
+			list, err := common.Marshal(messages)
+			if err != nil {
+				return "", err
+			}
+			request[field] = list
+			data, err := common.Marshal(request)
+			return string(data), err
+		},
+	}, true
+}
+
+// canonicalMessage 去掉 null 字段并按键排序，避免客户端回传时的格式差异影响哈希
+func canonicalMessage(message json.RawMessage) []byte {
+	var value any
+	if err := common.Unmarshal(message, &value); err != nil {
+		return message
+	}
+	data, err := common.Marshal(dropNullFields(value))
+	if err != nil {
+		return message
+	}
+	return data
+}
+
+func dropNullFields(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if item == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = dropNullFields(item)
+		}
+	case []any:
+		for i := range v {
+			v[i] = dropNullFields(v[i])
+		}
+	}
+	return value
+}
+
+// prefixHashes 返回每个前缀的哈希，hashes[i] 对应前 i 条消息，hashes[0] 为空
+func prefixHashes(messages []json.RawMessage) []string {
+	hashes := make([]string, len(messages)+1)
+	for i, message := range messages {
+		sum := sha256.Sum256(append([]byte(hashes[i]+"\n"), canonicalMessage(message)...))
+		hashes[i+1] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
+
+// sessionParent 上一轮记录中识别会话所需的字段
+type sessionParent struct {
+	SessionId    string
+	TurnIndex    int
+	MessagesHash string
+	CreatedAt    int64
+}
+
+// threadConversation 为新记录识别所属会话，并在找到上一轮时只保留本轮新增的消息
+// 上一轮的完整消息列表是本轮消息列表的前缀：按前缀哈希在主表和压缩表中查找同一用户最近的记录
+// 客户端指定了会话 id 时只在该会话内查找，找不到上一轮时作为该会话的新一轮完整存储
+func threadConversation(conv *Conversation) {
+	list, ok := parseConversationMessageList(conv.Format, conv.RequestMessages)
+	if !ok || len(list.messages) == 0 {
+		if conv.SessionId == "" {
+			conv.SessionId = common.GetUUID()
+		} else {
+			conv.TurnIndex = nextSessionTurn(conv.UserId, conv.SessionId)
+		}
+		return
+	}
+	hashes := prefixHashes(list.messages)
+	conv.MessagesHash = hashes[len(list.messages)]
+
+	candidates := make([]string, 0, maxSessionPrefixCandidates)
+	prefixLength := make(map[string]int, maxSessionPrefixCandidates)
+	for i := len(list.messages) - 1; i >= 1 && len(candidates) < maxSessionPrefixCandidates; i-- {
+		candidates = append(candidates, hashes[i])
+		prefixLength[hashes[i]] = i
+	}
+
+	var parent *sessionParent
+	if len(candidates) > 0 {
+		for _, table := range []interface{}{&Conversation{}, &ConversationCompressed{}} {
+			var rows []*sessionParent
+			tx := LOG_DB.Model(table).Select("session_id, turn_index, messages_hash, created_at").
+				Where("user_id = ? AND messages_hash IN ?", conv.UserId, candidates)
+			if conv.SessionId != "" {
+				tx = tx.Where("session_id = ?", conv.SessionId)
+			}
+			if err := tx.Order("created_at DESC").Limit(maxSessionPrefixCandidates).Find(&rows).Error; err != nil {
+				common.SysError("failed to find previous conversation turn: " + err.Error())
+				continue
+			}
+			// 取最长的前缀，长度相同时取最近的记录
+			for _, row := range rows {
+				if parent == nil || prefixLength[row.MessagesHash] > prefixLength[parent.MessagesHash] ||
+					(prefixLength[row.MessagesHash] == prefixLength[parent.MessagesHash] && row.CreatedAt > parent.CreatedAt) {
+					parent = row
+				}
+			}
+		}
+	}
+
+	if parent == nil {
+		if conv.SessionId == "" {
+			conv.SessionId = common.GetUUID()
+		} else {
+			conv.TurnIndex = nextSessionTurn(conv.UserId, conv.SessionId)
+		}
+		return
+	}
+
+	prefix := prefixLength[parent.MessagesHash]
+	delta, err := list.rebuild(list.messages[prefix:])
+	if err != nil {
+		return
+	}
+	conv.SessionId = parent.SessionId
+	conv.TurnIndex = parent.TurnIndex + 1
+	conv.ParentHash = parent.MessagesHash
+	conv.PrefixMessages = prefix
+	conv.RequestMessages = delta
+}
+
+// nextSessionTurn 客户端指定会话 id 但找不到上一轮时，使用会话中最大轮次 + 1
+func nextSessionTurn(userId int, sessionId string) int {
+	next := 0
+	for _, table := range []interface{}{&Conversation{}, &ConversationCompressed{}} {
+		var turn *int
+		LOG_DB.Model(table).Select("MAX(turn_index)").Where("user_id = ? AND session_id = ?", userId, sessionId).Scan(&turn)
+		if turn != nil && *turn+1 > next {
+			next = *turn + 1
+		}
+	}
+	return next
+}
+
+// loadSessionConversations 读取会话中的全部记录（主表、压缩表、归档表），按时间升序
+// loadCold 为 true 时同时从冷存储分段中加载
+func loadSessionConversations(ctx context.Context, userId int, sessionId string, loadCold bool) ([]*ConversationWithSource, error) {
+	var results []*ConversationWithSource
+
+	var conversations []*Conversation
+	if err := LOG_DB.Where("user_id = ? AND session_id = ?", userId, sessionId).Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	for _, conv := range conversations {
+		results = append(results, &ConversationWithSource{Conversation: conv, Source: ConversationSourceMain})
+	}
+
+	var compressedRows []*ConversationCompressed
+	if err := LOG_DB.Where("user_id = ? AND session_id = ?", userId, sessionId).Find(&compressedRows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range compressedRows {
+		conv, err := row.ToConversation()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &ConversationWithSource{Conversation: conv, Source: ConversationSourceCompressed})
+	}
+
+	var archives []*ConversationArchive
+	if err := LOG_DB.Where("user_id = ? AND session_id = ?", userId, sessionId).Find(&archives).Error; err != nil {
+		return nil, err
+	}
+	for _, archive := range archives {
+		results = append(results, &ConversationWithSource{Conversation: archive.ToConversation(), Source: ConversationSourceArchive})
+	}
+
+	if store := getConversationSegmentStore(); loadCold && store != nil {
+		var indexes []*ConversationArchiveIndex
+		if err := LOG_DB.Where("user_id = ? AND session_id = ?", userId, sessionId).Find(&indexes).Error; err != nil {
+			return nil, err
+		}
+		segments := make(map[string][]*Conversation)
+		for _, index := range indexes {
+			segment, ok := segments[index.SegmentKey]
+			if !ok {
+				var err error
+				segment, err = readConversationSegment(ctx, store, index.SegmentKey)
+				if err != nil {
+					return nil, err
+				}
+				segments[index.SegmentKey] = segment
+			}
+			if index.SegmentLine < len(segment) {
+				conv := *segment[index.SegmentLine]
+				conv.Id = index.Id
+				results = append(results, &ConversationWithSource{Conversation: &conv, Source: ConversationSourceCold})
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].CreatedAt != results[j].CreatedAt {
+			return results[i].CreatedAt < results[j].CreatedAt
+		}
+		return results[i].TurnIndex < results[j].TurnIndex
+	})
+	return results, nil
+}
+
+// sessionExpander 在一个会话的记录中还原每一轮的完整消息列表
+type sessionExpander struct {
+	byHash   map[string]*Conversation
+	expanded map[*Conversation][]json.RawMessage
+	visiting map[*Conversation]bool
+}
+
+func newSessionExpander(conversations []*ConversationWithSource) *sessionExpander {
+	expander := &sessionExpander{
+		byHash:   make(map[string]*Conversation, len(conversations)),
+		expanded: make(map[*Conversation][]json.RawMessage, len(conversations)),
+		visiting: make(map[*Conversation]bool),
+	}
+	for _, conv := range conversations {
+		if conv.MessagesHash != "" {
+			if _, exists := expander.byHash[conv.MessagesHash]; !exists {
+				expander.byHash[conv.MessagesHash] = conv.Conversation
+			}
+		}
+	}
+	return expander
+}
+
+// messages 返回记录的完整消息列表，上一轮缺失（已删除或不可读）时 ok 为 false，只返回本轮存储的消息
+func (e *sessionExpander) messages(conv *Conversation) (messages []json.RawMessage, ok bool) {
+	if cached, exists := e.expanded[conv]; exists {
+		return cached, true
+	}
+	list, parsed := parseConversationMessageList(conv.Format, conv.RequestMessages)
+	if !parsed {
+		return nil, false
+	}
+	if conv.PrefixMessages == 0 {
+		e.expanded[conv] = list.messages
+		return list.messages, true
+	}
+	parent := e.byHash[conv.ParentHash]
+	if parent == nil || e.visiting[conv] {
+		return list.messages, false
+	}
+	e.visiting[conv] = true
+	parentMessages, parentOk := e.messages(parent)
+	delete(e.visiting, conv)
+	if !parentOk || len(parentMessages) < conv.PrefixMessages {
+		return list.messages, false
+	}
+	full := make([]json.RawMessage, 0, conv.PrefixMessages+len(list.messages))
+	full = append(full, parentMessages[:conv.PrefixMessages]...)
+	full = append(full, list.messages...)
+	e.expanded[conv] = full
+	return full, true
+}
+
+// expand 将只存储增量的记录还原为完整请求，无法还原时保持原样
+func (e *sessionExpander) expand(conv *Conversation) bool {
+	if conv.PrefixMessages == 0 {
+		return true
+	}
+	full, ok := e.messages(conv)
+	if !ok {
+		return false
+	}
+	list, parsed := parseConversationMessageList(conv.Format, conv.RequestMessages)
+	if !parsed {
+		return false
+	}
+	requestMessages, err := list.rebuild(full)
+	if err != nil {
+		return false
+	}
+	conv.RequestMessages = requestMessages
+	conv.PrefixMessages = 0
+	return true
+}
+
+// ExpandConversation 还原单条记录的完整请求内容，记录未使用增量存储时直接返回
+func ExpandConversation(ctx context.Context, conv *Conversation) error {
+	if conv.PrefixMessages == 0 || conv.SessionId == "" {
+		return nil
+	}
+	conversations, err := loadSessionConversations(ctx, conv.UserId, conv.SessionId, true)
+	if err != nil {
+		return err
+	}
+	newSessionExpander(conversations).expand(conv)
+	return nil
+}
+
+// sessionExpandCache 批量处理（导出、归档）时按会话缓存已加载的记录
+type sessionExpandCache struct {
+	ctx       context.Context
+	expanders map[string]*sessionExpander
+}
+
+// maxCachedSessions 缓存的会话数上限，超过后清空重新加载
+const maxCachedSessions = 100
+
+func newSessionExpandCache(ctx context.Context) *sessionExpandCache {
+	return &sessionExpandCache{ctx: ctx, expanders: make(map[string]*sessionExpander)}
+}
+
+// expandAll 还原一批记录中使用增量存储的记录
+func (cache *sessionExpandCache) expandAll(conversations []*Conversation) error {
+	for _, conv := range conversations {
+		if conv.PrefixMessages == 0 || conv.SessionId == "" {
+			continue
+		}
+		key := strconv.Itoa(conv.UserId) + ":" + conv.SessionId
+		expander, ok := cache.expanders[key]
+		if !ok {
+			rows, err := loadSessionConversations(cache.ctx, conv.UserId, conv.SessionId, true)
+			if err != nil {
+				return err
+			}
+			if len(cache.expanders) >= maxCachedSessions {
+				cache.expanders = make(map[string]*sessionExpander)
+			}
+			expander = newSessionExpander(rows)
+			cache.expanders[key] = expander
+		}
+		expander.expand(conv)
+	}
+	return nil
+}
+
+// ConversationSession 会话列表中的一行
+type ConversationSession struct {
+	SessionId   string `json:"session_id"`
+	UserId      int    `json:"user_id"`
+	Username    string `json:"username"`
+	ModelName   string `json:"model_name"`
+	Turns       int64  `json:"turns"`
+	TotalTokens int64  `json:"total_tokens"`
+	FirstAt     int64  `json:"first_at"`
+	LastAt      int64  `json:"last_at"`
+}
+
+// GetConversationSessions 按会话聚合主表和压缩表中的记录，按最近一轮时间倒序分页
+// 同一会话跨两张表时合并为一行，total 为两张表会话数之和，可能略大于实际值
+func GetConversationSessions(userId int, modelName string, username string, startTime int64, endTime int64, startIdx int, num int) ([]*ConversationSession, int64, error) {
+	limit := startIdx + num
+	merged := make(map[string]*ConversationSession)
+	var total int64
+	for _, table := range []interface{}{&Conversation{}, &ConversationCompressed{}} {
+		tx := applyConversationFilters(LOG_DB.Model(table), userId, modelName, username, startTime, endTime).
+			Where("session_id <> ''")
+
+		var count int64
+		if err := LOG_DB.Table("(?) AS s", tx.Session(&gorm.Session{}).Select("session_id, user_id").Group("session_id, user_id")).Count(&count).Error; err != nil {
+			return nil, 0, err
+		}
+		total += count
+
+		var sessions []*ConversationSession
+		err := tx.Select("session_id, user_id, MAX(username) AS username, MAX(model_name) AS model_name, COUNT(*) AS turns, " +
+			"SUM(total_tokens) AS total_tokens, MIN(created_at) AS first_at, MAX(created_at) AS last_at").
+			Group("session_id, user_id").Order("last_at DESC").Limit(limit).Scan(&sessions).Error
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, session := range sessions {
+			key := strconv.Itoa(session.UserId) + ":" + session.SessionId
+			existing, ok := merged[key]
+			if !ok {
+				merged[key] = session
+				continue
+			}
+			existing.Turns += session.Turns
+			existing.TotalTokens += session.TotalTokens
+			existing.FirstAt = min(existing.FirstAt, session.FirstAt)
+			existing.LastAt = max(existing.LastAt, session.LastAt)
+		}
+	}
+
+	results := make([]*ConversationSession, 0, len(merged))
+	for _, session := range merged {
+		results = append(results, session)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LastAt > results[j].LastAt
+	})
+	if startIdx >= len(results) {
+		return []*ConversationSession{}, total, nil
+	}
+	return results[startIdx:min(limit, len(results))], total, nil
+}
+
+// ConversationSessionTurn 会话中的一轮，Messages 只包含本轮新增的消息
+type ConversationSessionTurn struct {
+	Id               int               `json:"id"`
+	Source           string            `json:"source"`
+	TurnIndex        int               `json:"turn_index"`
+	ModelName        string            `json:"model_name"`
+	Format           string            `json:"format"`
+	PromptTokens     int               `json:"prompt_tokens"`
+	CompletionTokens int               `json:"completion_tokens"`
+	TotalTokens      int               `json:"total_tokens"`
+	CreatedAt        int64             `json:"created_at"`
+	Messages         []json.RawMessage `json:"messages"`
+	ResponseContent  string            `json:"response_content"`
+	// 上一轮已被删除或无法读取，Messages 可能不完整
+	Partial bool `json:"partial"`
+}
+
+// ConversationSessionThread 去重后的会话内容
+type ConversationSessionThread struct {
+	SessionId string                     `json:"session_id"`
+	UserId    int                        `json:"user_id"`
+	Username  string                     `json:"username"`
+	Turns     []*ConversationSessionTurn `json:"turns"`
+}
+
+// isAssistantMessage 上一轮的回复会被客户端作为下一轮请求的开头回传，展示时跳过
+func isAssistantMessage(message json.RawMessage) bool {
+	var item struct {
+		Role string `json:"role"`
+		Type string `json:"type"`
+	}
+	if err := common.Unmarshal(message, &item); err != nil {
+		return false
+	}
+	switch item.Role {
+	case "assistant", "model":
+		return true
+	}
+	return item.Type == "function_call" || item.Type == "reasoning"
+}
+
+// GetConversationSessionThread 返回会话的完整对话，每条消息只出现一次
+// userId 为 0 时按会话 id 在主表和压缩表中查找所属用户
+func GetConversationSessionThread(ctx context.Context, userId int, sessionId string) (*ConversationSessionThread, error) {
+	if userId <= 0 {
+		for _, table := range []interface{}{&Conversation{}, &ConversationCompressed{}} {
+			var owners []int
+			if err := LOG_DB.Model(table).Where("session_id = ?", sessionId).Limit(1).Pluck("user_id", &owners).Error; err != nil {
+				return nil, err
+			}
+			if len(owners) > 0 {
+				userId = owners[0]
+				break
+			}
+		}
+		if userId <= 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	conversations, err := loadSessionConversations(ctx, userId, sessionId, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	expander := newSessionExpander(conversations)
+	thread := &ConversationSessionThread{
+		SessionId: sessionId,
+		UserId:    userId,
+		Username:  conversations[0].Username,
+	}
+	for _, conv := range conversations {
+		turn := &ConversationSessionTurn{
+			Id:               conv.Id,
+			Source:           conv.Source,
+			TurnIndex:        conv.TurnIndex,
+			ModelName:        conv.ModelName,
+			Format:           conv.Format,
+			PromptTokens:     conv.PromptTokens,
+			CompletionTokens: conv.CompletionTokens,
+			TotalTokens:      conv.TotalTokens,
+			CreatedAt:        conv.CreatedAt,
+			ResponseContent:  conv.ResponseContent,
+		}
+		full, ok := expander.messages(conv.Conversation)
+		turn.Partial = !ok
+		messages := full
+		// 去掉与上一轮重复的部分：上一轮的请求消息，以及回传的上一轮回复
+		if parent := expander.byHash[conv.ParentHash]; ok && conv.ParentHash != "" && parent != nil {
+			if parentMessages, parentOk := expander.messages(parent); parentOk && len(parentMessages) <= len(full) {
+				messages = full[len(parentMessages):]
+				for len(messages) > 0 && isAssistantMessage(messages[0]) {
+					messages = messages[1:]
+				}
+			}
+		} else if !ok {
+			list, parsed := parseConversationMessageList(conv.Format, conv.RequestMessages)
+			if parsed {
+				messages = list.messages
+			}
+		}
+		turn.Messages = messages
+		thread.Turns = append(thread.Turns, turn)
+	}
+	return thread, nil
+}
+
+// conversationDeleteRow 删除记录前识别依赖它的后续轮次所需的字段
+type conversationDeleteRow struct {
+	Id           int
+	UserId       int
+	SessionId    string
+	MessagesHash string
+}
+
+const (
+	// conversationDetachHashChunk 按上一轮哈希查找后续轮次时每次查询的哈希数量
+	conversationDetachHashChunk = 500
+	// conversationDeleteBatchSize 按 id 或条件删除记录时每批处理的条数
+	conversationDeleteBatchSize = 500
+)
+
+// detachConversationChildren 删除记录前将依赖它们的后续轮次还原为完整请求
+// 后续轮次只存储本轮新增的消息，上一轮删除后将无法还原，因此在上一轮仍然存在时先写回完整请求
+// source 为记录所在的来源，rows 为即将删除的记录，同一批中一起删除的后续轮次不再处理
+func detachConversationChildren(ctx context.Context, source string, rows []conversationDeleteRow) error {
+	type sessionKey struct {
+		UserId    int
+		SessionId string
+	}
+	deleting := make(map[int]bool, len(rows))
+	parentHashes := make(map[string]bool, len(rows))
+	deletingSessions := make(map[sessionKey]bool)
+	hashes := make([]string, 0, len(rows))
+	for _, row := range rows {
+		deleting[row.Id] = true
+		if row.MessagesHash == "" || row.SessionId == "" {
+			continue
+		}
+		deletingSessions[sessionKey{UserId: row.UserId, SessionId: row.SessionId}] = true
+		if !parentHashes[row.MessagesHash] {
+			parentHashes[row.MessagesHash] = true
+			hashes = append(hashes, row.MessagesHash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	// 冷存储中的记录归档时已还原为完整请求，只需处理其余三处
+	sessions := make(map[sessionKey]bool)
+	for _, table := range []interface{}{&Conversation{}, &ConversationCompressed{}, &ConversationArchive{}} {
+		for start := 0; start < len(hashes); start += conversationDetachHashChunk {
+			var keys []sessionKey
+			err := LOG_DB.Model(table).Distinct("user_id", "session_id").
+				Where("parent_hash IN ? AND prefix_messages > 0", hashes[start:min(start+conversationDetachHashChunk, len(hashes))]).
+				Scan(&keys).Error
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if deletingSessions[key] {
+					sessions[key] = true
+				}
+			}
+		}
+	}
+
+	for key := range sessions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		conversations, err := loadSessionConversations(ctx, key.UserId, key.SessionId, true)
+		if err != nil {
+			return err
+		}
+		expander := newSessionExpander(conversations)
+		for _, conv := range conversations {
+			if conv.PrefixMessages == 0 || !parentHashes[conv.ParentHash] || conv.Source == ConversationSourceCold {
+				continue
+			}
+			if conv.Source == source && deleting[conv.Id] {
+				continue
+			}
+			if !expander.expand(conv.Conversation) {
+				continue
+			}
+			if err := saveExpandedConversation(conv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// conversationSourceTables 可以按行删除和更新的来源对应的表
+var conversationSourceTables = map[string]interface{}{
+	ConversationSourceMain:       &Conversation{},
+	ConversationSourceCompressed: &ConversationCompressed{},
+	ConversationSourceArchive:    &ConversationArchive{},
+}
+
+// saveExpandedConversation 将还原后的完整请求写回记录所在的表
+func saveExpandedConversation(conv *ConversationWithSource) error {
+	switch conv.Source {
+	case ConversationSourceMain:
+		return LOG_DB.Model(&Conversation{}).Where("id = ?", conv.Id).
+			Updates(map[string]interface{}{"request_messages": conv.RequestMessages, "prefix_messages": 0}).Error
+	case ConversationSourceArchive:
+		return LOG_DB.Model(&ConversationArchive{}).Where("id = ?", conv.Id).
+			Updates(map[string]interface{}{"request_messages": conv.RequestMessages, "prefix_messages": 0}).Error
+	case ConversationSourceCompressed:
+		requestCompressed, err := CompressString(conv.RequestMessages)
+		if err != nil {
+			return err
+		}
+		return LOG_DB.Model(&ConversationCompressed{}).Where("id = ?", conv.Id).
+			Updates(map[string]interface{}{"request_messages_gz": requestCompressed, "prefix_messages": 0}).Error
+	}
+	return nil
+}
+
+// deleteConversationRows 分批删除 table 中满足 scope 条件的记录，每批删除前先还原依赖这些记录的后续轮次
+func deleteConversationRows(ctx context.Context, table interface{}, source string, scope func(tx *gorm.DB) *gorm.DB, batchSize int) (int64, error) {
+	var total int64
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		var rows []conversationDeleteRow
+		err := scope(LOG_DB.Model(table)).Select("id, user_id, session_id, messages_hash").
+			Order("id ASC").Limit(batchSize).Scan(&rows).Error
+		if err != nil {
+			return total, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		if err := detachConversationChildren(ctx, source, rows); err != nil {
+			return total, err
+		}
+		ids := make([]int, len(rows))
+		for i, row := range rows {
+			ids[i] = row.Id
+		}
+		result := LOG_DB.Where("id IN ?", ids).Delete(table)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if len(rows) < batchSize || result.RowsAffected == 0 {
+			break
+		}
+	}
+	return total, nil
+}
@@ -37,6 +37,7 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Other            string `json:"other"`
+	UpstreamCost     int    `json:"upstream_cost,omitempty" gorm:"default:0"` // 按渠道采购价估算的上游成本，未配置采购价时为 0
 }
 
 // don't use iota, avoid change log type value
@@ -53,6 +54,7 @@ const (
 func formatUserLogs(logs []*Log) {
 	for i := range logs {
 		logs[i].ChannelName = ""
+		logs[i].UpstreamCost = 0
 		var otherMap map[string]interface{}
 		otherMap, _ = common.StrToMap(logs[i].Other)
 		if otherMap != nil {
@@ -189,7 +191,8 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		Other:        otherStr,
+		UpstreamCost: GetChannelUpstreamCost(params.ChannelId, params.ModelName, params.PromptTokens, params.CompletionTokens),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/types"
+)
+
+// marginReportDefaultRange 未指定开始时间时统计的时间范围
+const marginReportDefaultRange = 7 * 24 * time.Hour
+
+// MarginReportItem 渠道在某个模型上一天的收入与上游成本，额度单位
+type MarginReportItem struct {
+	Day            int64  `json:"day"` // 当天零点（服务器时区）的时间戳
+	ChannelId      int    `json:"channel_id"`
+	ChannelName    string `json:"channel_name" gorm:"-"`
+	ModelName      string `json:"model_name"`
+	Requests       int64  `json:"requests"`
+	CostedRequests int64  `json:"costed_requests"` // 配置了采购价的请求数，只有这部分请求计入上游成本
+	Quota          int64  `json:"quota"`           // 向用户收取的额度
+	UpstreamCost   int64  `json:"upstream_cost"`
+	Margin         int64  `json:"margin"`
+}
+
+// GetMarginReport 按渠道、模型和天汇总消费日志中的用户扣费和上游成本
+func GetMarginReport(startTimestamp int64, endTimestamp int64, modelName string, channel int) ([]*MarginReportItem, error) {
+	if startTimestamp == 0 {
+		startTimestamp = time.Now().Add(-marginReportDefaultRange).Unix()
+	}
+	// 按服务器时区划分自然日
+	_, offset := time.Now().Zone()
+	dayExpr := fmt.Sprintf("created_at - (created_at + %d) %% 86400", offset)
+
+	tx := LOG_DB.Table("logs").
+		Select(dayExpr+" as day, channel_id, model_name, count(*) as requests, "+
+			"sum(case when upstream_cost > 0 then 1 else 0 end) as costed_requests, "+
+			"sum(quota) as quota, sum(upstream_cost) as upstream_cost").
+		Where("type = ?", LogTypeConsume).
+		Where("created_at >= ?", startTimestamp)
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name like ?", modelName)
+	}
+	if channel != 0 {
+		tx = tx.Where("channel_id = ?", channel)
+	}
+
+	var items []*MarginReportItem
+	err := tx.Group("day, channel_id, model_name").Order("day desc, channel_id, model_name").Scan(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	channelIds := types.NewSet[int]()
+	for _, item := range items {
+		item.Margin = item.Quota - item.UpstreamCost
+		if item.ChannelId != 0 {
+			channelIds.Add(item.ChannelId)
+		}
+	}
+	if channelIds.Len() > 0 {
+		var channels []struct {
+			Id   int    `gorm:"column:id"`
+			Name string `gorm:"column:name"`
+		}
+		if err = DB.Table("channels").Select("id, name").Where("id IN ?", channelIds.Items()).Find(&channels).Error; err != nil {
+			return items, err
+		}
+		channelMap := make(map[int]string, len(channels))
+		for _, channel := range channels {
+			channelMap[channel.Id] = channel.Name
+		}
+		for _, item := range items {
+			item.ChannelName = channelMap[item.ChannelId]
+		}
+	}
+	return items, nil
+}
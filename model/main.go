@@ -273,6 +273,8 @@ func migrateDB() error {
 		&Setup{},
 		&TwoFA{},
 		&TwoFABackupCode{},
+		&ChannelSchedule{},
+		&BudgetUsage{},
 	)
 	if err != nil {
 		return err
@@ -306,6 +308,8 @@ func migrateDBFast() error {
 		{&Setup{}, "Setup"},
 		{&TwoFA{}, "TwoFA"},
 		{&TwoFABackupCode{}, "TwoFABackupCode"},
+		{&ChannelSchedule{}, "ChannelSchedule"},
+		{&BudgetUsage{}, "BudgetUsage"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -347,6 +351,19 @@ func migrateLOGDB() error {
 	if err = LOG_DB.AutoMigrate(&ConversationArchive{}); err != nil {
 		return err
 	}
+	// 迁移对话记录压缩表
+	if err = LOG_DB.AutoMigrate(&ConversationCompressed{}); err != nil {
+		return err
+	}
+	// 迁移冷存储归档索引表
+	if err = LOG_DB.AutoMigrate(&ConversationArchiveIndex{}); err != nil {
+		return err
+	}
+	// 全文索引只由主节点在后台创建，建好之前检索回退到 LIKE
+	// 会阻塞写入的 MySQL / SQLite 索引不在启动时创建，需管理员通过 /api/conversation/search_index 手动触发
+	if common.IsMasterNode {
+		go EnsureConversationSearchIndexes(false)
+	}
 	return nil
 }
 
@@ -6,54 +6,53 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
 )
 
 type Token struct {
-	Id                 int            `json:"id"`
-	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status             int            `json:"status" gorm:"default:1"`
-	Name               string         `json:"name" gorm:"index" `
-	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
-	AccessedTime       int64          `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime        int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota        int            `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota     bool           `json:"unlimited_quota"`
-	ModelLimitsEnabled bool           `json:"model_limits_enabled"`
-	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
-	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
-	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
-	Group              string         `json:"group" gorm:"default:''"`
-	DeletedAt          gorm.DeletedAt `gorm:"index"`
+	Id                  int            `json:"id"`
+	UserId              int            `json:"user_id" gorm:"index"`
+	Key                 string         `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Status              int            `json:"status" gorm:"default:1"`
+	Name                string         `json:"name" gorm:"index" `
+	CreatedTime         int64          `json:"created_time" gorm:"bigint"`
+	AccessedTime        int64          `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime         int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota         int            `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota      bool           `json:"unlimited_quota"`
+	ModelLimitsEnabled  bool           `json:"model_limits_enabled"`
+	ModelLimits         string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
+	AllowIps            *string        `json:"allow_ips" gorm:"default:''"`
+	DenyIps             *string        `json:"deny_ips" gorm:"type:text"`   // IP 禁止列表，优先于允许列表
+	UsedQuota           int            `json:"used_quota" gorm:"default:0"` // used quota
+	Group               string         `json:"group" gorm:"default:''"`
+	ConversationLogMode string         `json:"conversation_log_mode" gorm:"type:varchar(16);default:''"` // 对话记录偏好：always / never，空为跟随用户设置
+	Budgets             string         `json:"budgets" gorm:"type:varchar(1024);default:''"`             // 周期预算，[]dto.SpendingBudget 的 JSON，空为不限制
+	RPM                 int            `json:"rpm" gorm:"default:0"`                                     // 每分钟请求数上限，0 为不限制
+	TPM                 int            `json:"tpm" gorm:"default:0"`                                     // 每分钟 token 数上限，0 为不限制
+	MaxConcurrency      int            `json:"max_concurrency" gorm:"default:0"`                         // 最大并发请求数，0 为不限制
+	Scopes              string         `json:"scopes" gorm:"type:varchar(2048);default:''"`              // 权限范围，dto.TokenScope 的 JSON，空为不限制
+	DeletedAt           gorm.DeletedAt `gorm:"index"`
 }
 
 func (token *Token) Clean() {
 	token.Key = ""
 }
 
-func (token *Token) GetIpLimitsMap() map[string]any {
-	// delete empty spaces
-	//split with \n
-	ipLimitsMap := make(map[string]any)
-	if token.AllowIps == nil {
-		return ipLimitsMap
+// CheckIpAccess 按令牌的 IP 允许和禁止列表检查客户端 IP，列表支持 IPv4 / IPv6 地址和 CIDR
+func (token *Token) CheckIpAccess(ip string) common.IPAccess {
+	var allowIps, denyIps string
+	if token.AllowIps != nil {
+		allowIps = *token.AllowIps
 	}
-	cleanIps := strings.ReplaceAll(*token.AllowIps, " ", "")
-	if cleanIps == "" {
-		return ipLimitsMap
+	if token.DenyIps != nil {
+		denyIps = *token.DenyIps
 	}
-	ips := strings.Split(cleanIps, "\n")
-	for _, ip := range ips {
-		ip = strings.TrimSpace(ip)
-		ip = strings.ReplaceAll(ip, ",", "")
-		if common.IsIP(ip) {
-			ipLimitsMap[ip] = true
-		}
-	}
-	return ipLimitsMap
+	return common.CheckIPAccess(ip, allowIps, denyIps)
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
@@ -185,7 +184,8 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "deny_ips", "group", "conversation_log_mode", "budgets",
+		"rpm", "tpm", "max_concurrency", "scopes").Updates(token).Error
 	return err
 }
 
@@ -239,6 +239,39 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
 	return limitsMap
 }
 
+// TokenModelLimitAllows 判断模型是否在令牌的模型限制中，限制项支持 * 通配符，例如 gpt-4o*
+func TokenModelLimitAllows(limits map[string]bool, modelName string) bool {
+	if limits[modelName] || limits[ratio_setting.FormatMatchingModelName(modelName)] {
+		return true
+	}
+	for pattern := range limits {
+		if strings.Contains(pattern, "*") && common.WildcardMatch(pattern, modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTokenScope 解析令牌的权限范围，空字符串表示不限制
+func ParseTokenScope(value string) (*dto.TokenScope, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var scope dto.TokenScope
+	if err := common.UnmarshalJsonStr(value, &scope); err != nil {
+		return nil, fmt.Errorf("权限范围格式错误: %v", err)
+	}
+	for _, endpoint := range scope.Endpoints {
+		if !common.StringsContains(dto.TokenScopeEndpoints, endpoint) {
+			return nil, fmt.Errorf("不支持的端点类型: %s", endpoint)
+		}
+	}
+	if scope.MaxTokens < 0 || scope.MaxN < 0 || scope.MaxRequestQuota < 0 {
+		return nil, errors.New("权限范围中的上限不能为负数")
+	}
+	return &scope, nil
+}
+
 func DisableModelLimits(tokenId int) error {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
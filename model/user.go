@@ -46,6 +46,9 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	Budgets          string         `json:"budgets" gorm:"type:varchar(1024);default:''"` // 周期预算，[]dto.SpendingBudget 的 JSON，空为不限制
+	AllowIps         string         `json:"allow_ips" gorm:"type:text"`                   // IP 允许列表，每行一个地址或 CIDR，空为不限制
+	DenyIps          string         `json:"deny_ips" gorm:"type:text"`                    // IP 禁止列表，优先于允许列表
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -57,6 +60,9 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+		Budgets:  user.Budgets,
+		AllowIps: user.AllowIps,
+		DenyIps:  user.DenyIps,
 	}
 	return cache
 }
@@ -462,6 +468,9 @@ func (user *User) Edit(updatePassword bool) error {
 		"group":        newUser.Group,
 		"quota":        newUser.Quota,
 		"remark":       newUser.Remark,
+		"budgets":      newUser.Budgets,
+		"allow_ips":    newUser.AllowIps,
+		"deny_ips":     newUser.DenyIps,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
@@ -22,6 +22,9 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+	Budgets  string `json:"budgets"`
+	AllowIps string `json:"allow_ips"`
+	DenyIps  string `json:"deny_ips"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -31,6 +34,14 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	common.SetContextKey(c, constant.ContextKeyUserEmail, user.Email)
 	common.SetContextKey(c, constant.ContextKeyUserName, user.Username)
 	common.SetContextKey(c, constant.ContextKeyUserSetting, user.GetSetting())
+	if budgets, err := ParseSpendingBudgets(user.Budgets); err == nil && len(budgets) > 0 {
+		common.SetContextKey(c, constant.ContextKeyUserBudgets, budgets)
+	}
+}
+
+// CheckIpAccess 按用户的 IP 允许和禁止列表检查客户端 IP，对用户的所有令牌生效
+func (user *UserBase) CheckIpAccess(ip string) common.IPAccess {
+	return common.CheckIPAccess(ip, user.AllowIps, user.DenyIps)
 }
 
 func (user *UserBase) GetSetting() dto.UserSetting {
@@ -107,6 +118,9 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+		Budgets:  user.Budgets,
+		AllowIps: user.AllowIps,
+		DenyIps:  user.DenyIps,
 	}
 
 	return userCache, nil
@@ -12,6 +12,7 @@ import (
 
 	common2 "github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
@@ -249,6 +250,8 @@ func sendPingData(c *gin.Context, mutex *sync.Mutex) error {
 	}
 }
 
+var errHedgeLost = errors.New("hedged request lost to another attempt")
+
 func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
 	return doRequest(c, req, info)
 }
@@ -267,9 +270,9 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	var stopPinger context.CancelFunc
 	if info.IsStream {
 		helper.SetEventStreamHeaders(c)
-		// 处理流式请求的 ping 保活
+		// 处理流式请求的 ping 保活，对冲请求在决出获胜者前不向下游输出
 		generalSettings := operation_setting.GetGeneralSetting()
-		if generalSettings.PingIntervalEnabled && !info.DisablePing {
+		if generalSettings.PingIntervalEnabled && !info.DisablePing && info.HedgeAttempt == nil {
 			pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
 			stopPinger = startPingKeepAlive(c, pingInterval)
 			// 使用defer确保在任何情况下都能停止ping goroutine
@@ -284,14 +287,29 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	if info.HedgeAttempt != nil {
+		req = req.WithContext(info.HedgeAttempt.Context())
+	}
 	resp, err := client.Do(req)
 	if err != nil {
+		if info.HedgeAttempt != nil && info.HedgeAttempt.Lost() {
+			return nil, types.NewError(errHedgeLost, types.ErrorCodeHedgeLost, types.ErrOptionWithSkipRetry())
+		}
 		logger.LogError(c, "do request failed: "+err.Error())
 		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
 	}
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	if resp.StatusCode < http.StatusBadRequest && info.HedgeLost() {
+		// 对冲请求中其他尝试已先收到响应
+		_ = resp.Body.Close()
+		return nil, types.NewError(errHedgeLost, types.ErrorCodeHedgeLost, types.ErrOptionWithSkipRetry())
+	}
+	if info.ChannelMeta != nil && info.ChannelIsMultiKey {
+		// 记录上游返回的限额，余量模式据此选择 key，429 时该 key 单独冷却
+		model.UpdateChannelKeyRateLimit(info.ChannelId, info.ChannelMultiKeyIndex, resp.StatusCode, resp.Header)
+	}
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
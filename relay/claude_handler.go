@@ -151,7 +151,9 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		}
 	}
 
+	capture := startConversationCapture(c, info)
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	capture.finish(c)
 	//log.Printf("usage: %v", usage)
 	if newAPIError != nil {
 		// reset status code 重置状态码
@@ -160,5 +162,8 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 	}
 
 	service.PostClaudeConsumeQuota(c, info, usage.(*dto.Usage))
+
+	// 记录对话内容（如果启用）
+	recordClaudeConversation(c, info, claudeReq, capture, usage.(*dto.Usage))
 	return nil
 }
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// HedgeRace 对冲请求中同一个请求的多个尝试，先收到上游成功响应的尝试获胜，其余尝试被取消
+type HedgeRace struct {
+	mu       sync.Mutex
+	attempts []*HedgeAttempt
+	winner   *HedgeAttempt
+	claimed  chan struct{}
+}
+
+// HedgeAttempt 对冲请求中的一个尝试，上游请求使用其 Context，以便落选时中断
+type HedgeAttempt struct {
+	race   *HedgeRace
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewHedgeRace() *HedgeRace {
+	return &HedgeRace{claimed: make(chan struct{})}
+}
+
+func (r *HedgeRace) NewAttempt() *HedgeAttempt {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempt := &HedgeAttempt{race: r, ctx: ctx, cancel: cancel}
+	r.mu.Lock()
+	r.attempts = append(r.attempts, attempt)
+	r.mu.Unlock()
+	return attempt
+}
+
+// Claimed 有尝试获胜时关闭
+func (r *HedgeRace) Claimed() <-chan struct{} {
+	return r.claimed
+}
+
+// Close 释放所有尝试的 Context
+func (r *HedgeRace) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, attempt := range r.attempts {
+		attempt.cancel()
+	}
+}
+
+func (a *HedgeAttempt) Context() context.Context {
+	return a.ctx
+}
+
+// Claim 尝试成为获胜者，已有其他获胜者时返回 false；获胜后取消其他尝试
+func (a *HedgeAttempt) Claim() bool {
+	r := a.race
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return r.winner == a
+	}
+	r.winner = a
+	close(r.claimed)
+	for _, attempt := range r.attempts {
+		if attempt != a {
+			attempt.cancel()
+		}
+	}
+	return true
+}
+
+// Won 是否为获胜者
+func (a *HedgeAttempt) Won() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	return a.race.winner == a
+}
+
+// Lost 其他尝试已经获胜
+func (a *HedgeAttempt) Lost() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	return a.race.winner != nil && a.race.winner != a
+}
+
+// HedgeLost 对冲请求中本尝试未获胜，不应再向下游输出或计费；非对冲请求始终返回 false
+// 尚无获胜者时由本尝试获胜
+func (info *RelayInfo) HedgeLost() bool {
+	return info.HedgeAttempt != nil && !info.HedgeAttempt.Claim()
+}
+
+// CloneForHedge 为对冲请求的每个尝试复制一份 RelayInfo，转换过程中会修改的子结构单独复制
+func (info *RelayInfo) CloneForHedge(attempt *HedgeAttempt) *RelayInfo {
+	clone := *info
+	if info.ClaudeConvertInfo != nil {
+		claudeConvertInfo := *info.ClaudeConvertInfo
+		clone.ClaudeConvertInfo = &claudeConvertInfo
+	}
+	if info.ResponsesUsageInfo != nil {
+		responsesUsageInfo := *info.ResponsesUsageInfo
+		if info.ResponsesUsageInfo.BuiltInTools != nil {
+			responsesUsageInfo.BuiltInTools = make(map[string]*BuildInToolInfo, len(info.ResponsesUsageInfo.BuiltInTools))
+			for name, tool := range info.ResponsesUsageInfo.BuiltInTools {
+				if tool != nil {
+					toolCopy := *tool
+					tool = &toolCopy
+				}
+				responsesUsageInfo.BuiltInTools[name] = tool
+			}
+		}
+		clone.ResponsesUsageInfo = &responsesUsageInfo
+	}
+	clone.HedgeAttempt = attempt
+	return &clone
+}
@@ -102,12 +102,15 @@ type RelayInfo struct {
 	AudioUsage             bool
 	ReasoningEffort        string
 	UserSetting            dto.UserSetting
+	TokenBudgets           []dto.SpendingBudget // 令牌的周期预算
+	UserBudgets            []dto.SpendingBudget // 用户的周期预算
 	UserEmail              string
 	UserQuota              int
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
-	FinalPreConsumedQuota  int  // 最终预消耗的配额
-	IsClaudeBetaQuery      bool // /v1/messages?beta=true
+	FinalPreConsumedQuota  int           // 最终预消耗的配额
+	IsClaudeBetaQuery      bool          // /v1/messages?beta=true
+	HedgeAttempt           *HedgeAttempt // 对冲请求中的尝试，非对冲请求为 nil
 
 	PriceData types.PriceData
 
@@ -423,6 +426,8 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 	if ok {
 		info.UserSetting = userSetting
 	}
+	info.TokenBudgets, _ = common.GetContextKeyType[[]dto.SpendingBudget](c, constant.ContextKeyTokenBudgets)
+	info.UserBudgets, _ = common.GetContextKeyType[[]dto.SpendingBudget](c, constant.ContextKeyUserBudgets)
 
 	return info
 }
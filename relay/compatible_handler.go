@@ -174,7 +174,14 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		}
 	}
 
+	var collector *StreamContentCollector
+	capture := startConversationCapture(c, info)
+	if capture != nil && info.IsStream {
+		collector = NewStreamContentCollector(common.ConversationMaxCaptureBytes)
+		capture.collectStream(collector)
+	}
 	usage, newApiErr := adaptor.DoResponse(c, httpResp, info)
+	capture.finish(c)
 	if newApiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
@@ -188,17 +195,16 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	}
 
 	// 记录对话内容（如果启用）
-	if textResponse, exists := c.Get("text_response"); exists {
-		if response, ok := textResponse.(*dto.OpenAITextResponse); ok {
-			responseContent := ExtractResponseContent(response)
-			RecordConversationHelper(c, info, textReq, responseContent, usage.(*dto.Usage), info.StartTime)
-		}
-	}
+	recordOpenAIConversation(c, info, textReq, capture, collector, usage.(*dto.Usage))
 
 	return nil
 }
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	// 对冲请求中落选的尝试不计费
+	if relayInfo.HedgeLost() {
+		return
+	}
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.PromptTokens,
@@ -207,6 +213,8 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		}
 		extraContent += "（可能是请求出错）"
 	}
+	service.RecordTPMUsage(ctx, usage.PromptTokens+usage.CompletionTokens)
+	service.RecordChannelTokenUsage(ctx, usage.PromptTokens+usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -0,0 +1,351 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/gin-gonic/gin"
+)
+
+// streamDataCollector 逐条接收客户端收到的 SSE data 内容
+type streamDataCollector interface {
+	AddData(data string)
+}
+
+// conversationCaptureWriter 在写回客户端的同时缓存响应内容，用于对话记录
+// 缓存的是客户端实际收到的数据，因此与上游渠道类型无关
+type conversationCaptureWriter struct {
+	gin.ResponseWriter
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+
+	// 设置 collector 后按行解析 SSE，只保留未结束的半行，内存占用与流长度无关
+	collector streamDataCollector
+	pending   []byte
+}
+
+func (w *conversationCaptureWriter) capture(data []byte) {
+	if w.collector != nil {
+		w.feed(data)
+		return
+	}
+	if w.truncated {
+		return
+	}
+	remain := w.limit - w.buf.Len()
+	if len(data) > remain {
+		data = data[:remain]
+		w.truncated = true
+	}
+	w.buf.Write(data)
+}
+
+func (w *conversationCaptureWriter) feed(data []byte) {
+	w.pending = append(w.pending, data...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(w.pending[:i]), "\r")
+		w.pending = w.pending[i+1:]
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimLeft(line[5:], " ")
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		w.collector.AddData(data)
+	}
+	// 单行超过上限时直接丢弃，避免异常数据占用内存
+	if len(w.pending) > w.limit {
+		w.pending = nil
+		w.truncated = true
+	}
+}
+
+func (w *conversationCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *conversationCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// startConversationCapture 开始捕获响应，未启用对话记录或本次请求未命中记录策略时返回 nil
+func startConversationCapture(c *gin.Context, info *relaycommon.RelayInfo) *conversationCaptureWriter {
+	if !shouldRecordConversation(c, info) {
+		return nil
+	}
+	w := &conversationCaptureWriter{
+		ResponseWriter: c.Writer,
+		limit:          common.ConversationMaxCaptureBytes,
+	}
+	c.Writer = w
+	return w
+}
+
+// collectStream 改为逐条解析 SSE 并交给 collector，不再缓存原始响应
+func (w *conversationCaptureWriter) collectStream(collector streamDataCollector) {
+	if w == nil {
+		return
+	}
+	w.collector = collector
+}
+
+// finish 停止捕获并还原原始的 ResponseWriter
+func (w *conversationCaptureWriter) finish(c *gin.Context) {
+	if w == nil {
+		return
+	}
+	if c.Writer == w {
+		c.Writer = w.ResponseWriter
+	}
+}
+
+// Bytes 返回已捕获的原始响应
+func (w *conversationCaptureWriter) Bytes() []byte {
+	if w == nil {
+		return nil
+	}
+	return w.buf.Bytes()
+}
+
+// sseEvent SSE 事件，只保留对话记录需要的字段
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// parseSSEEvents 将捕获到的 SSE 响应拆分为事件列表，忽略 ping 注释和 [DONE]
+func parseSSEEvents(raw []byte) []sseEvent {
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64<<10), len(raw)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if current.Data != "" {
+				events = append(events, current)
+			}
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event:"):
+			current.Event = strings.TrimSpace(line[6:])
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimLeft(line[5:], " ")
+			if data == "[DONE]" {
+				continue
+			}
+			current.Data += data
+		}
+	}
+	if current.Data != "" {
+		events = append(events, current)
+	}
+	return events
+}
+
+// buildClaudeResponseContent 将 Claude 流式事件还原为完整的 message 结构
+// 保留 text / thinking / tool_use 等内容块
+func buildClaudeResponseContent(raw []byte, isStream bool) string {
+	if !isStream {
+		return string(raw)
+	}
+	message := dto.ClaudeResponse{
+		Type: "message",
+		Role: "assistant",
+	}
+	var blocks []*dto.ClaudeMediaMessage
+	partialJson := make(map[int]*strings.Builder)
+	for _, event := range parseSSEEvents(raw) {
+		var resp dto.ClaudeResponse
+		if err := common.UnmarshalJsonStr(event.Data, &resp); err != nil {
+			continue
+		}
+		switch resp.Type {
+		case "message_start":
+			if resp.Message != nil {
+				message.Id = resp.Message.Id
+				message.Model = resp.Message.Model
+				message.Usage = resp.Message.Usage
+			}
+		case "content_block_start":
+			if resp.ContentBlock == nil {
+				continue
+			}
+			index := resp.GetIndex()
+			for len(blocks) <= index {
+				blocks = append(blocks, nil)
+			}
+			block := *resp.ContentBlock
+			blocks[index] = &block
+		case "content_block_delta":
+			index := resp.GetIndex()
+			if resp.Delta == nil || index >= len(blocks) || blocks[index] == nil {
+				continue
+			}
+			block := blocks[index]
+			switch resp.Delta.Type {
+			case "text_delta":
+				block.SetText(block.GetText() + resp.Delta.GetText())
+			case "thinking_delta":
+				if resp.Delta.Thinking != nil {
+					thinking := *resp.Delta.Thinking
+					if block.Thinking != nil {
+						thinking = *block.Thinking + thinking
+					}
+					block.Thinking = &thinking
+				}
+			case "signature_delta":
+				block.Signature += resp.Delta.Signature
+			case "input_json_delta":
+				if resp.Delta.PartialJson != nil {
+					if partialJson[index] == nil {
+						partialJson[index] = &strings.Builder{}
+					}
+					partialJson[index].WriteString(*resp.Delta.PartialJson)
+				}
+			}
+		case "message_delta":
+			if resp.Delta != nil && resp.Delta.StopReason != nil {
+				message.StopReason = *resp.Delta.StopReason
+			}
+			if resp.Usage != nil {
+				if message.Usage == nil {
+					message.Usage = resp.Usage
+				} else {
+					message.Usage.OutputTokens = resp.Usage.OutputTokens
+				}
+			}
+		}
+	}
+	for index, builder := range partialJson {
+		if index >= len(blocks) || blocks[index] == nil {
+			continue
+		}
+		var input any
+		if err := common.UnmarshalJsonStr(builder.String(), &input); err == nil {
+			blocks[index].Input = input
+		} else {
+			blocks[index].Input = builder.String()
+		}
+	}
+	for _, block := range blocks {
+		if block != nil {
+			message.Content = append(message.Content, *block)
+		}
+	}
+	if len(message.Content) == 0 {
+		return ""
+	}
+	return common.GetJsonString(message)
+}
+
+// buildGeminiResponseContent 将 Gemini 流式分片合并为一个完整的 GenerateContentResponse
+// 相邻的同类文本（普通文本 / thought）会被拼接，functionCall 等其他 part 原样保留
+func buildGeminiResponseContent(raw []byte, isStream bool) string {
+	if !isStream {
+		return string(raw)
+	}
+	var merged dto.GeminiChatResponse
+	candidates := make(map[int64]*dto.GeminiChatCandidate)
+	var order []int64
+	for _, event := range parseSSEEvents(raw) {
+		var chunk dto.GeminiChatResponse
+		if err := common.UnmarshalJsonStr(event.Data, &chunk); err != nil {
+			continue
+		}
+		if chunk.UsageMetadata.TotalTokenCount > 0 {
+			merged.UsageMetadata = chunk.UsageMetadata
+		}
+		if chunk.PromptFeedback != nil {
+			merged.PromptFeedback = chunk.PromptFeedback
+		}
+		for _, candidate := range chunk.Candidates {
+			target, ok := candidates[candidate.Index]
+			if !ok {
+				target = &dto.GeminiChatCandidate{Index: candidate.Index}
+				target.Content.Role = candidate.Content.Role
+				candidates[candidate.Index] = target
+				order = append(order, candidate.Index)
+			}
+			for _, part := range candidate.Content.Parts {
+				parts := target.Content.Parts
+				if part.Text != "" && len(parts) > 0 {
+					last := &parts[len(parts)-1]
+					if last.Text != "" && last.Thought == part.Thought && last.FunctionCall == nil && last.InlineData == nil {
+						last.Text += part.Text
+						continue
+					}
+				}
+				target.Content.Parts = append(target.Content.Parts, part)
+			}
+			if candidate.FinishReason != nil {
+				target.FinishReason = candidate.FinishReason
+			}
+			if len(candidate.SafetyRatings) > 0 {
+				target.SafetyRatings = candidate.SafetyRatings
+			}
+		}
+	}
+	for _, index := range order {
+		merged.Candidates = append(merged.Candidates, *candidates[index])
+	}
+	if len(merged.Candidates) == 0 {
+		return ""
+	}
+	return common.GetJsonString(merged)
+}
+
+// buildResponsesResponseContent 从 Responses API 流中取出最终的 response 对象
+// 优先使用 response.completed 事件；若流被中断，则用已完成的 output item 拼出结果
+// output item 以原始 JSON 保留，避免丢失 function_call / reasoning 等字段
+func buildResponsesResponseContent(raw []byte, isStream bool) string {
+	if !isStream {
+		return string(raw)
+	}
+	var final json.RawMessage
+	var outputs []json.RawMessage
+	for _, event := range parseSSEEvents(raw) {
+		var resp struct {
+			Type     string          `json:"type"`
+			Response json.RawMessage `json:"response,omitempty"`
+			Item     json.RawMessage `json:"item,omitempty"`
+		}
+		if err := common.UnmarshalJsonStr(event.Data, &resp); err != nil {
+			continue
+		}
+		switch resp.Type {
+		case "response.completed", "response.incomplete", "response.failed":
+			if len(resp.Response) > 0 {
+				final = resp.Response
+			}
+		case dto.ResponsesOutputTypeItemDone:
+			if len(resp.Item) > 0 {
+				outputs = append(outputs, resp.Item)
+			}
+		}
+	}
+	if len(final) > 0 {
+		return string(final)
+	}
+	if len(outputs) == 0 {
+		return ""
+	}
+	return common.GetJsonString(map[string]any{
+		"object": "response",
+		"status": "incomplete",
+		"output": outputs,
+	})
+}
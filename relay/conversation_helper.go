@@ -3,26 +3,141 @@ package relay
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
 // RecordConversationHelper 记录对话的辅助函数
 // 在响应完成后异步记录对话内容
 func RecordConversationHelper(c *gin.Context, info *relaycommon.RelayInfo, textReq *dto.GeneralOpenAIRequest, responseContent string, usage *dto.Usage, startTime time.Time) {
-	// 检查是否启用对话记录
-	if !common.ConversationLogEnabled {
+	// 只记录聊天对话类型
+	if textReq == nil || textReq.Messages == nil || len(textReq.Messages) == 0 {
 		return
 	}
+	recordConversation(c, info, types.RelayFormatOpenAI, textReq.Messages, conversationSessionId(c, textReq.Metadata), textReq.Stream, responseContent, usage, startTime)
+}
 
-	// 只记录聊天对话类型
-	if textReq == nil || textReq.Messages == nil || len(textReq.Messages) == 0 {
+// claudeConversationRequest Claude 格式下记录的请求内容
+type claudeConversationRequest struct {
+	System   any                 `json:"system,omitempty"`
+	Messages []dto.ClaudeMessage `json:"messages"`
+	Tools    any                 `json:"tools,omitempty"`
+}
+
+// geminiConversationRequest Gemini 格式下记录的请求内容
+type geminiConversationRequest struct {
+	SystemInstruction *dto.GeminiChatContent  `json:"systemInstruction,omitempty"`
+	Contents          []dto.GeminiChatContent `json:"contents"`
+	Tools             json.RawMessage         `json:"tools,omitempty"`
+}
+
+// responsesConversationRequest Responses API 格式下记录的请求内容
+type responsesConversationRequest struct {
+	Instructions       json.RawMessage `json:"instructions,omitempty"`
+	Input              json.RawMessage `json:"input,omitempty"`
+	Tools              json.RawMessage `json:"tools,omitempty"`
+	PreviousResponseID string          `json:"previous_response_id,omitempty"`
+}
+
+// recordClaudeConversation 记录 /v1/messages 的对话，响应为还原后的 Claude message
+func recordClaudeConversation(c *gin.Context, info *relaycommon.RelayInfo, claudeReq *dto.ClaudeRequest, capture *conversationCaptureWriter, usage *dto.Usage) {
+	if capture == nil || claudeReq == nil || len(claudeReq.Messages) == 0 {
+		return
+	}
+	request := claudeConversationRequest{
+		System:   claudeReq.System,
+		Messages: claudeReq.Messages,
+		Tools:    claudeReq.Tools,
+	}
+	responseContent := buildClaudeResponseContent(capture.Bytes(), info.IsStream)
+	recordConversation(c, info, types.RelayFormatClaude, request, conversationSessionId(c, claudeReq.Metadata), info.IsStream, responseContent, usage, info.StartTime)
+}
+
+// recordGeminiConversation 记录 Gemini 原生接口的对话，响应为合并后的 GenerateContentResponse
+func recordGeminiConversation(c *gin.Context, info *relaycommon.RelayInfo, geminiReq *dto.GeminiChatRequest, capture *conversationCaptureWriter, usage *dto.Usage) {
+	if capture == nil || geminiReq == nil || len(geminiReq.Contents) == 0 {
+		return
+	}
+	request := geminiConversationRequest{
+		SystemInstruction: geminiReq.SystemInstructions,
+		Contents:          geminiReq.Contents,
+		Tools:             geminiReq.Tools,
+	}
+	responseContent := buildGeminiResponseContent(capture.Bytes(), info.IsStream)
+	recordConversation(c, info, types.RelayFormatGemini, request, conversationSessionId(c, nil), info.IsStream, responseContent, usage, info.StartTime)
+}
+
+// recordResponsesConversation 记录 /v1/responses 的对话，响应为最终的 response 对象
+func recordResponsesConversation(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest, capture *conversationCaptureWriter, usage *dto.Usage) {
+	if capture == nil || responsesReq == nil || len(responsesReq.Input) == 0 {
+		return
+	}
+	request := responsesConversationRequest{
+		Instructions:       responsesReq.Instructions,
+		Input:              responsesReq.Input,
+		Tools:              responsesReq.Tools,
+		PreviousResponseID: responsesReq.PreviousResponseID,
+	}
+	responseContent := buildResponsesResponseContent(capture.Bytes(), info.IsStream)
+	recordConversation(c, info, types.RelayFormatOpenAIResponses, request, conversationSessionId(c, responsesReq.Metadata), info.IsStream, responseContent, usage, info.StartTime)
+}
+
+// conversationSessionIdHeader 客户端指定会话 id 的请求头
+const conversationSessionIdHeader = "X-Session-Id"
+
+// maxConversationSessionIdLength 与 conversations.session_id 列宽一致
+const maxConversationSessionIdLength = 64
+
+// conversationSessionId 读取客户端指定的会话 id，优先使用请求头，其次为请求 metadata 中的 session_id
+// 未指定时返回空字符串，由记录时按消息前缀自动识别
+func conversationSessionId(c *gin.Context, metadata json.RawMessage) string {
+	sessionId := strings.TrimSpace(c.GetHeader(conversationSessionIdHeader))
+	if sessionId == "" && len(metadata) > 0 {
+		var meta struct {
+			SessionId string `json:"session_id"`
+		}
+		if err := common.Unmarshal(metadata, &meta); err == nil {
+			sessionId = strings.TrimSpace(meta.SessionId)
+		}
+	}
+	if runes := []rune(sessionId); len(runes) > maxConversationSessionIdLength {
+		sessionId = string(runes[:maxConversationSessionIdLength])
+	}
+	return sessionId
+}
+
+// shouldRecordConversation 判断本次请求是否记录对话
+// 结果缓存在上下文中，避免采样在捕获和落库时得到不同结论
+func shouldRecordConversation(c *gin.Context, info *relaycommon.RelayInfo) bool {
+	if !common.ConversationLogEnabled {
+		return false
+	}
+	if decision, ok := common.GetContextKeyType[bool](c, constant.ContextKeyConversationLogDecision); ok {
+		return decision
+	}
+	groups := []string{info.UsingGroup, info.UserGroup}
+	tokenMode := common.GetContextKeyString(c, constant.ContextKeyTokenConversationLog)
+	decision := operation_setting.ShouldRecordConversation(groups, tokenMode, info.UserSetting.ConversationLogMode)
+	common.SetContextKey(c, constant.ContextKeyConversationLogDecision, decision)
+	return decision
+}
+
+// recordConversation 各种请求格式共用的记录逻辑
+func recordConversation(c *gin.Context, info *relaycommon.RelayInfo, format types.RelayFormat, requestMessages interface{}, sessionId string, isStream bool, responseContent string, usage *dto.Usage, startTime time.Time) {
+	// 检查是否启用对话记录以及本次请求是否命中记录策略
+	if !shouldRecordConversation(c, info) {
 		return
 	}
 
@@ -50,15 +165,17 @@ func RecordConversationHelper(c *gin.Context, info *relaycommon.RelayInfo, textR
 		TokenId:          info.TokenId,
 		TokenName:        info.TokenKey, // 使用 TokenKey 作为 TokenName
 		ChannelId:        info.ChannelId,
-		RequestMessages:  textReq.Messages,
+		RequestMessages:  requestMessages,
 		ResponseContent:  responseContent,
 		PromptTokens:     0,
 		CompletionTokens: 0,
-		IsStream:         textReq.Stream,
+		IsStream:         isStream,
 		CreatedAt:        startTime.Unix(),
 		UseTime:          useTime,
 		Ip:               "",
 		Group:            info.UsingGroup,
+		Format:           string(format),
+		SessionId:        sessionId,
 	}
 
 	// 设置 IP
@@ -79,60 +196,282 @@ func RecordConversationHelper(c *gin.Context, info *relaycommon.RelayInfo, textR
 				logger.LogError(c, fmt.Sprintf("panic in RecordConversation: %v", r))
 			}
 		}()
+		redactConversationParams(&params)
 		model.RecordConversation(c, params)
 	}()
 }
 
-// ExtractResponseContent 从响应中提取文本内容
-func ExtractResponseContent(response *dto.OpenAITextResponse) string {
-	if response == nil || len(response.Choices) == 0 {
-		return ""
+// redactConversationParams 落库前按配置脱敏，请求消息先序列化为 JSON 再处理
+func redactConversationParams(params *model.RecordConversationParams) {
+	if !operation_setting.GetConversationRedactionSetting().Enabled {
+		return
+	}
+	requestJSON, err := common.Marshal(params.RequestMessages)
+	if err != nil {
+		return
 	}
+	request, response, rules := service.RedactConversation(string(requestJSON), params.ResponseContent)
+	params.RequestMessages = json.RawMessage(request)
+	params.ResponseContent = response
+	params.RedactedRules = joinRedactedRules(rules)
+}
 
-	var content string
-	for _, choice := range response.Choices {
-		if choice.Message.Content != nil {
-			if str, ok := choice.Message.Content.(string); ok {
-				content += str
-			} else if arr, ok := choice.Message.Content.([]interface{}); ok {
-				// 处理数组类型的 content
-				contentBytes, _ := json.Marshal(arr)
-				content += string(contentBytes)
+// redactedRulesMaxBytes 与 conversations.redacted_rules 的 varchar(255) 一致，超长会导致整条记录写入失败
+const redactedRulesMaxBytes = 255
+
+// joinRedactedRules 去重后以逗号拼接规则名，超出列长度的规则名整体丢弃
+func joinRedactedRules(rules []string) string {
+	seen := make(map[string]bool, len(rules))
+	var builder strings.Builder
+	for _, rule := range rules {
+		if rule == "" || seen[rule] {
+			continue
+		}
+		seen[rule] = true
+		size := len(rule)
+		if builder.Len() > 0 {
+			size++
+		}
+		if builder.Len()+size > redactedRulesMaxBytes {
+			if builder.Len() == 0 {
+				// 单个规则名已超长时按字符边界截断
+				cut := redactedRulesMaxBytes
+				for cut > 0 && !utf8.RuneStart(rule[cut]) {
+					cut--
+				}
+				builder.WriteString(rule[:cut])
 			}
+			break
+		}
+		if builder.Len() > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(rule)
+	}
+	return builder.String()
+}
+
+// recordOpenAIConversation 记录 /v1/chat/completions 的对话
+// 流式请求使用 collector 还原出的完整响应，非流式优先使用渠道解析好的 text_response
+func recordOpenAIConversation(c *gin.Context, info *relaycommon.RelayInfo, textReq *dto.GeneralOpenAIRequest, capture *conversationCaptureWriter, collector *StreamContentCollector, usage *dto.Usage) {
+	if capture == nil {
+		return
+	}
+	var responseContent string
+	if collector != nil {
+		responseContent = collector.GetContent()
+		// 以客户端收到的最后一个 usage chunk 为准
+		if collector.Usage != nil && collector.Usage.TotalTokens > 0 {
+			usage = collector.Usage
 		}
-		// 处理 reasoning_content（用于 o3 等模型）
-		if choice.Message.ReasoningContent != "" {
-			content += "\n[Reasoning]\n" + choice.Message.ReasoningContent
+		if collector.Truncated {
+			logger.LogWarn(c, fmt.Sprintf("conversation stream content exceeds %d bytes, truncated", collector.MaxBytes))
 		}
-		if choice.Message.Reasoning != "" {
-			content += "\n[Reasoning]\n" + choice.Message.Reasoning
+	} else if textResponse, exists := c.Get("text_response"); exists {
+		if response, ok := textResponse.(*dto.OpenAITextResponse); ok {
+			responseContent = ExtractResponseContent(response)
+		}
+	} else {
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(capture.Bytes(), &response); err == nil {
+			responseContent = ExtractResponseContent(&response)
 		}
 	}
+	RecordConversationHelper(c, info, textReq, responseContent, usage, info.StartTime)
+}
 
-	return content
+// ExtractResponseContent 将非流式响应转换为记录用的结构化内容
+// 与流式还原结果保持一致：chat.completion 对象，保留 reasoning_content 与 tool_calls
+func ExtractResponseContent(response *dto.OpenAITextResponse) string {
+	if response == nil || len(response.Choices) == 0 {
+		return ""
+	}
+	return common.GetJsonString(response)
 }
 
-// ExtractStreamResponseContent 从流式响应中提取文本内容
-// 注意：这个函数需要在流式处理过程中累积内容
+// StreamContentCollector 将流式 chunk 还原为完整的 chat.completion 响应
+// 按 choice 分别累积 content、reasoning 和 tool_calls 参数片段；
+// 累积字节数超过 MaxBytes 后不再追加内容，只继续记录 finish_reason 与 usage
 type StreamContentCollector struct {
-	Content string
+	MaxBytes  int
+	Truncated bool
+	Usage     *dto.Usage
+
+	id      string
+	model   string
+	created int64
+	size    int
+	choices []*streamChoiceCollector
+}
+
+type streamChoiceCollector struct {
+	index        int
+	role         string
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    []*dto.ToolCallResponse
+	finishReason string
+}
+
+// maxStreamToolCalls 单个 choice 允许的最大 tool call 数量，防止异常 index 导致内存膨胀
+const maxStreamToolCalls = 256
+
+func NewStreamContentCollector(maxBytes int) *StreamContentCollector {
+	return &StreamContentCollector{MaxBytes: maxBytes}
+}
+
+// AddData 解析一条 SSE data 内容
+func (s *StreamContentCollector) AddData(data string) {
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+		return
+	}
+	s.AddStreamResponse(&streamResponse)
+}
+
+func (s *StreamContentCollector) AddStreamResponse(streamResponse *dto.ChatCompletionsStreamResponse) {
+	if streamResponse == nil {
+		return
+	}
+	if s.id == "" {
+		s.id = streamResponse.Id
+		s.model = streamResponse.Model
+		s.created = streamResponse.Created
+	}
+	if streamResponse.Usage != nil {
+		s.Usage = streamResponse.Usage
+	}
+	for i := range streamResponse.Choices {
+		choice := &streamResponse.Choices[i]
+		s.addDelta(choice.Index, &choice.Delta)
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.getChoice(choice.Index).finishReason = *choice.FinishReason
+		}
+	}
 }
 
+// AddChunk 追加单个 delta，视为第 0 个 choice
 func (s *StreamContentCollector) AddChunk(delta *dto.ChatCompletionsStreamResponseChoiceDelta) {
+	s.addDelta(0, delta)
+}
+
+func (s *StreamContentCollector) addDelta(index int, delta *dto.ChatCompletionsStreamResponseChoiceDelta) {
 	if delta == nil {
 		return
 	}
-	if delta.Content != nil {
-		s.Content += *delta.Content
+	choice := s.getChoice(index)
+	if delta.Role != "" {
+		choice.role = delta.Role
+	}
+	if text := delta.GetContentString(); s.reserve(len(text)) {
+		choice.content.WriteString(text)
+	}
+	if text := delta.GetReasoningContent(); s.reserve(len(text)) {
+		choice.reasoning.WriteString(text)
 	}
-	if delta.ReasoningContent != nil {
-		s.Content += "\n[Reasoning]\n" + *delta.ReasoningContent
+	for _, toolCall := range delta.ToolCalls {
+		toolIndex := len(choice.toolCalls) - 1
+		if toolCall.Index != nil {
+			toolIndex = *toolCall.Index
+		} else if toolCall.ID != "" || toolIndex < 0 {
+			toolIndex = len(choice.toolCalls)
+		}
+		if toolIndex < 0 || toolIndex >= maxStreamToolCalls {
+			continue
+		}
+		for len(choice.toolCalls) <= toolIndex {
+			choice.toolCalls = append(choice.toolCalls, &dto.ToolCallResponse{Type: "function"})
+		}
+		target := choice.toolCalls[toolIndex]
+		if toolCall.ID != "" {
+			target.ID = toolCall.ID
+		}
+		if toolType, ok := toolCall.Type.(string); ok && toolType != "" {
+			target.Type = toolType
+		}
+		if toolCall.Function.Name != "" {
+			target.Function.Name = toolCall.Function.Name
+		}
+		if s.reserve(len(toolCall.Function.Arguments)) {
+			target.Function.Arguments += toolCall.Function.Arguments
+		}
+	}
+}
+
+func (s *StreamContentCollector) getChoice(index int) *streamChoiceCollector {
+	for _, choice := range s.choices {
+		if choice.index == index {
+			return choice
+		}
+	}
+	choice := &streamChoiceCollector{index: index}
+	s.choices = append(s.choices, choice)
+	return choice
+}
+
+// reserve 检查追加 n 字节后是否超出上限
+func (s *StreamContentCollector) reserve(n int) bool {
+	if n == 0 {
+		return false
 	}
-	if delta.Reasoning != nil {
-		s.Content += "\n[Reasoning]\n" + *delta.Reasoning
+	if s.MaxBytes > 0 && s.size+n > s.MaxBytes {
+		s.Truncated = true
+		return false
 	}
+	s.size += n
+	return true
+}
+
+// Response 返回还原后的完整响应，没有任何内容时返回 nil
+func (s *StreamContentCollector) Response() *dto.OpenAITextResponse {
+	response := &dto.OpenAITextResponse{
+		Id:      s.id,
+		Model:   s.model,
+		Object:  "chat.completion",
+		Created: s.created,
+	}
+	hasContent := false
+	for _, choice := range s.choices {
+		message := dto.Message{
+			Role:             choice.role,
+			ReasoningContent: choice.reasoning.String(),
+		}
+		if message.Role == "" {
+			message.Role = "assistant"
+		}
+		if choice.content.Len() > 0 {
+			message.SetStringContent(choice.content.String())
+		}
+		toolCalls := make([]*dto.ToolCallResponse, 0, len(choice.toolCalls))
+		for i, toolCall := range choice.toolCalls {
+			toolCall.SetIndex(i)
+			toolCalls = append(toolCalls, toolCall)
+		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
+		if choice.content.Len() > 0 || choice.reasoning.Len() > 0 || len(toolCalls) > 0 {
+			hasContent = true
+		}
+		response.Choices = append(response.Choices, dto.OpenAITextResponseChoice{
+			Index:        choice.index,
+			Message:      message,
+			FinishReason: choice.finishReason,
+		})
+	}
+	if !hasContent {
+		return nil
+	}
+	if s.Usage != nil {
+		response.Usage = *s.Usage
+	}
+	return response
 }
 
 func (s *StreamContentCollector) GetContent() string {
-	return s.Content
+	response := s.Response()
+	if response == nil {
+		return ""
+	}
+	return common.GetJsonString(response)
 }
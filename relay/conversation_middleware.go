@@ -40,8 +40,8 @@ func AfterResponseHook(c *gin.Context, info *relaycommon.RelayInfo, textReq *dto
 // StreamAfterResponseHook 流式响应后钩子函数
 //
 // 使用方式：
-// collector := &relay.StreamContentCollector{}
-// ... 在流处理过程中调用 collector.AddChunk(delta) ...
+// collector := relay.NewStreamContentCollector(common.ConversationMaxCaptureBytes)
+// ... 在流处理过程中调用 collector.AddStreamResponse(streamResponse) ...
 // defer relay.StreamAfterResponseHook(c, info, textReq, collector, usage, startTime)
 func StreamAfterResponseHook(c *gin.Context, info *relaycommon.RelayInfo, textReq *dto.GeneralOpenAIRequest, collector *StreamContentCollector, usage *dto.Usage, startTime time.Time) {
 	if collector == nil {
@@ -49,5 +49,8 @@ func StreamAfterResponseHook(c *gin.Context, info *relaycommon.RelayInfo, textRe
 	}
 
 	responseContent := collector.GetContent()
+	if collector.Usage != nil && collector.Usage.TotalTokens > 0 {
+		usage = collector.Usage
+	}
 	RecordConversationHelper(c, info, textReq, responseContent, usage, startTime)
 }
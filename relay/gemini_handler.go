@@ -187,13 +187,18 @@ func GeminiHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		}
 	}
 
+	capture := startConversationCapture(c, info)
 	usage, openaiErr := adaptor.DoResponse(c, resp.(*http.Response), info)
+	capture.finish(c)
 	if openaiErr != nil {
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
 
 	postConsumeQuota(c, info, usage.(*dto.Usage), "")
+
+	// 记录对话内容（如果启用）
+	recordGeminiConversation(c, info, geminiReq, capture, usage.(*dto.Usage))
 	return nil
 }
 
@@ -97,7 +97,9 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		}
 	}
 
+	capture := startConversationCapture(c, info)
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	capture.finish(c)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
@@ -109,5 +111,8 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 	} else {
 		postConsumeQuota(c, info, usage.(*dto.Usage), "")
 	}
+
+	// 记录对话内容（如果启用）
+	recordResponsesConversation(c, info, responsesReq, capture, usage.(*dto.Usage))
 	return nil
 }
@@ -134,6 +134,14 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/health", controller.GetChannelHealth)
+			channelRoute.DELETE("/health/:id", controller.ResetChannelHealth)
+			channelRoute.GET("/circuit", controller.GetChannelCircuit)
+			channelRoute.DELETE("/circuit/:id", controller.ResetChannelCircuit)
+			channelRoute.GET("/schedule", controller.GetChannelSchedules)
+			channelRoute.POST("/schedule", controller.AddChannelSchedule)
+			channelRoute.PUT("/schedule", controller.UpdateChannelSchedule)
+			channelRoute.DELETE("/schedule/:id", controller.DeleteChannelSchedule)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.POST("/:id/key", middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
@@ -193,11 +201,21 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/margin", middleware.AdminAuth(), controller.GetMarginReport)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
+		// 用户自己的对话记录
+		selfConversationRoute := apiRouter.Group("/conversation/self")
+		selfConversationRoute.Use(middleware.UserAuth())
+		{
+			selfConversationRoute.GET("", controller.GetSelfConversations)
+			selfConversationRoute.GET("/:id", controller.GetSelfConversationDetail)
+			selfConversationRoute.DELETE("", controller.DeleteSelfConversations)
+		}
+
 		// 对话记录路由
 		conversationRoute := apiRouter.Group("/conversation")
 		conversationRoute.Use(middleware.AdminAuth())
@@ -207,6 +225,10 @@ func SetApiRouter(router *gin.Engine) {
 			conversationRoute.DELETE("/", controller.DeleteConversations)
 			conversationRoute.POST("/delete_by_condition", controller.DeleteConversationsByCondition)
 			conversationRoute.GET("/stats", controller.GetConversationStats)
+			conversationRoute.GET("/search", controller.SearchConversations)
+			conversationRoute.GET("/export", controller.ExportConversations)
+			conversationRoute.GET("/sessions", controller.GetConversationSessions)
+			conversationRoute.GET("/sessions/:session_id", controller.GetConversationSessionThread)
 			conversationRoute.GET("/setting", controller.GetConversationLogSetting)
 			conversationRoute.PUT("/setting", controller.UpdateConversationLogSetting)
 
@@ -214,7 +236,11 @@ func SetApiRouter(router *gin.Engine) {
 			conversationRoute.POST("/archive", controller.ArchiveOldConversations)
 			conversationRoute.POST("/cleanup_archives", controller.CleanupOldArchives)
 			conversationRoute.POST("/optimize", controller.OptimizeConversationTables)
+			conversationRoute.POST("/search_index", controller.BuildConversationSearchIndexes)
 			conversationRoute.GET("/table_stats", controller.GetConversationTableStats)
+			conversationRoute.POST("/compress/migrate", controller.StartConversationCompressMigration)
+			conversationRoute.GET("/compress/progress", controller.GetConversationCompressMigrationProgress)
+			conversationRoute.POST("/compress/cancel", controller.CancelConversationCompressMigration)
 			conversationRoute.GET("/search_archive", controller.SearchArchivedConversations)
 		}
 
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var budgetPeriodNames = map[string]string{
+	dto.BudgetPeriodDay:   "日",
+	dto.BudgetPeriodWeek:  "周",
+	dto.BudgetPeriodMonth: "月",
+}
+
+var budgetSubjectNames = map[string]string{
+	model.BudgetSubjectToken: "令牌",
+	model.BudgetSubjectUser:  "用户",
+}
+
+func getSpendingBudgetStatuses(relayInfo *relaycommon.RelayInfo) ([]model.BudgetStatus, error) {
+	var statuses []model.BudgetStatus
+	if !relayInfo.IsPlayground && len(relayInfo.TokenBudgets) > 0 {
+		tokenStatuses, err := model.GetBudgetStatuses(model.BudgetSubjectToken, relayInfo.TokenId, relayInfo.TokenBudgets)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, tokenStatuses...)
+	}
+	if len(relayInfo.UserBudgets) > 0 {
+		userStatuses, err := model.GetBudgetStatuses(model.BudgetSubjectUser, relayInfo.UserId, relayInfo.UserBudgets)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, userStatuses...)
+	}
+	return statuses, nil
+}
+
+// checkSpendingBudgets 检查令牌和用户的周期预算，并在响应头中返回剩余最少的预算
+// 与信任额度一致：剩余预算高于信任额度时直接放行，否则要求剩余预算不少于预扣费额度
+func checkSpendingBudgets(c *gin.Context, relayInfo *relaycommon.RelayInfo, preConsumedQuota int, trustQuota int) *types.NewAPIError {
+	statuses, err := getSpendingBudgetStatuses(relayInfo)
+	if err != nil {
+		// 统计失败时不拦截请求，避免 Redis 或数据库抖动导致服务不可用
+		logger.LogError(c, "failed to get spending budget status: "+err.Error())
+		return nil
+	}
+	if len(statuses) == 0 {
+		return nil
+	}
+	tightest := statuses[0]
+	for _, status := range statuses[1:] {
+		if status.Remaining < tightest.Remaining {
+			tightest = status
+		}
+	}
+	remaining := tightest.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Header("X-Budget-Limit", strconv.Itoa(tightest.Quota))
+	c.Header("X-Budget-Remaining", strconv.FormatInt(remaining, 10))
+	c.Header("X-Budget-Reset", strconv.FormatInt(tightest.ResetAt, 10))
+
+	if remaining > 0 && (remaining > int64(trustQuota) || remaining >= int64(preConsumedQuota)) {
+		return nil
+	}
+	retryAfter := tightest.ResetAt - time.Now().Unix()
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	return types.NewErrorWithStatusCode(
+		fmt.Errorf("%s%s预算不足, 预算额度: %s, 剩余: %s, 需要预扣费额度: %s, 将于 %s 恢复",
+			budgetSubjectNames[tightest.Subject], budgetPeriodNames[tightest.Period],
+			logger.FormatQuota(tightest.Quota), logger.FormatQuota(int(remaining)), logger.FormatQuota(preConsumedQuota),
+			time.Unix(tightest.ResetAt, 0).Format("2006-01-02 15:04:05")),
+		types.ErrorCodeBudgetExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+}
+
+// recordSpendingBudgets 将消费计入令牌和用户的周期预算，quota 为负数时表示退还
+func recordSpendingBudgets(relayInfo *relaycommon.RelayInfo, quota int) {
+	if !relayInfo.IsPlayground {
+		if err := model.RecordBudgetSpend(model.BudgetSubjectToken, relayInfo.TokenId, relayInfo.TokenBudgets, quota); err != nil {
+			common.SysError("failed to record token budget spend: " + err.Error())
+		}
+	}
+	if err := model.RecordBudgetSpend(model.BudgetSubjectUser, relayInfo.UserId, relayInfo.UserBudgets, quota); err != nil {
+		common.SysError("failed to record user budget spend: " + err.Error())
+	}
+}
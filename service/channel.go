@@ -98,6 +98,22 @@ func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
 	return search
 }
 
+// IsChannelKeyRateLimited 余量模式的多 key 渠道被上游限流时，该 key 已单独进入冷却，不需要禁用
+// 额度耗尽（insufficient_quota）同样返回 429，仍按原逻辑处理
+func IsChannelKeyRateLimited(channelError types.ChannelError, keyIndex int, err *types.NewAPIError) bool {
+	if err == nil || !channelError.IsMultiKey || err.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	if err.ToOpenAIError().Type == "insufficient_quota" {
+		return false
+	}
+	channel, getErr := model.CacheGetChannel(channelError.ChannelId)
+	if getErr != nil || channel.ChannelInfo.MultiKeyMode != constant.MultiKeyModeHeadroom {
+		return false
+	}
+	return model.IsChannelKeyCoolingDown(channelError.ChannelId, keyIndex)
+}
+
 func ShouldEnableChannel(newAPIError *types.NewAPIError, status int) bool {
 	if !common.AutomaticEnableChannelEnabled {
 		return false
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelAffinitySessionHeader 与对话记录共用的会话 id 请求头
+const channelAffinitySessionHeader = "X-Session-Id"
+
+// GetChannelAffinityKey 计算本次请求的渠道亲和 key，未启用或无法识别会话时返回空
+// 会话标识优先级：X-Session-Id 请求头 > metadata.session_id > user 字段（Claude 为 metadata.user_id）> system 和前几条消息的哈希
+func GetChannelAffinityKey(c *gin.Context, group string, modelName string) string {
+	affinitySetting := operation_setting.GetChannelAffinitySetting()
+	if !affinitySetting.Enabled {
+		return ""
+	}
+	source := ""
+	if sessionId := strings.TrimSpace(c.GetHeader(channelAffinitySessionHeader)); sessionId != "" {
+		source = "session:" + sessionId
+	} else if strings.HasPrefix(c.ContentType(), "application/json") {
+		source = channelAffinitySourceFromBody(c, affinitySetting.PrefixMessages)
+	}
+	if source == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(group + "\n" + modelName + "\n" + source))
+	return hex.EncodeToString(sum[:16])
+}
+
+func channelAffinitySourceFromBody(c *gin.Context, prefixMessages int) string {
+	var body map[string]json.RawMessage
+	if err := common.UnmarshalBodyReusable(c, &body); err != nil {
+		return ""
+	}
+	var metadata struct {
+		SessionId string `json:"session_id"`
+		UserId    string `json:"user_id"`
+	}
+	if raw, ok := body["metadata"]; ok {
+		_ = common.Unmarshal(raw, &metadata)
+	}
+	if sessionId := strings.TrimSpace(metadata.SessionId); sessionId != "" {
+		return "session:" + sessionId
+	}
+	var user string
+	if raw, ok := body["user"]; ok {
+		_ = common.Unmarshal(raw, &user)
+	}
+	if user = strings.TrimSpace(user); user == "" {
+		user = strings.TrimSpace(metadata.UserId)
+	}
+	if user != "" {
+		return "user:" + user
+	}
+
+	// 没有显式标识时使用对话开头作为会话指纹，同一会话后续轮次的开头不变
+	if prefixMessages <= 0 {
+		return ""
+	}
+	var prefix strings.Builder
+	for _, field := range []string{"system", "instructions", "systemInstruction", "system_instruction"} {
+		if raw, ok := body[field]; ok {
+			prefix.Write(raw)
+			prefix.WriteByte('\n')
+		}
+	}
+	messages := 0
+	for _, field := range []string{"messages", "contents", "input"} {
+		raw, ok := body[field]
+		if !ok {
+			continue
+		}
+		var items []json.RawMessage
+		if err := common.Unmarshal(raw, &items); err != nil {
+			// Responses API 的 input 可以是字符串
+			prefix.Write(raw)
+			messages++
+			break
+		}
+		for _, item := range items[:min(prefixMessages, len(items))] {
+			prefix.Write(item)
+			prefix.WriteByte('\n')
+			messages++
+		}
+		break
+	}
+	if messages == 0 {
+		return ""
+	}
+	return "prefix:" + prefix.String()
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// IsChannelSideFailure 是否为渠道侧的问题（5xx、429、超时、渠道错误、会触发自动禁用的错误）
+// 请求本身的错误（参数错误、内容审核等）不计入渠道的健康分和熔断
+func IsChannelSideFailure(channelType int, err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	if err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusRequestTimeout {
+		return true
+	}
+	return ShouldDisableChannel(channelType, err)
+}
+
+// ShouldUseChannelCircuitBreaker 启用熔断后，单 key 渠道出错时交给熔断器处理而不是直接禁用
+// 多 key 渠道仍按 key 禁用
+func ShouldUseChannelCircuitBreaker(channelError types.ChannelError) bool {
+	return operation_setting.GetChannelCircuitBreakerSetting().Enabled && !channelError.IsMultiKey
+}
+
+// RecordChannelCircuitResult 记录一次转发结果，熔断器状态变化时通知管理员
+// err 为 nil 表示成功，非渠道侧的错误不计入
+func RecordChannelCircuitResult(channelError types.ChannelError, modelName string, err *types.NewAPIError) {
+	if !ShouldUseChannelCircuitBreaker(channelError) {
+		return
+	}
+	if err != nil && !IsChannelSideFailure(channelError.ChannelType, err) {
+		return
+	}
+	from, to := model.RecordChannelCircuitResult(channelError.ChannelId, modelName, err == nil)
+	if from == to {
+		return
+	}
+
+	var subject, content string
+	switch to {
+	case model.ChannelCircuitOpen:
+		subject = fmt.Sprintf("通道「%s」（#%d）模型 %s 已熔断", channelError.ChannelName, channelError.ChannelId, modelName)
+		content = fmt.Sprintf("%s，状态 %s -> %s，原因：%s", subject, from, to, err.Error())
+	case model.ChannelCircuitClosed:
+		subject = fmt.Sprintf("通道「%s」（#%d）模型 %s 已恢复", channelError.ChannelName, channelError.ChannelId, modelName)
+		content = fmt.Sprintf("%s，状态 %s -> %s", subject, from, to)
+	default:
+		return
+	}
+	common.SysLog(content)
+	notifyType := fmt.Sprintf("%s_%d_circuit_%s", dto.NotifyTypeChannelUpdate, channelError.ChannelId, to)
+	gopool.Go(func() {
+		NotifyRootUser(notifyType, subject, content)
+	})
+}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// FineTuneRecord OpenAI chat 微调数据集中的一行
+type FineTuneRecord struct {
+	Messages []dto.Message         `json:"messages"`
+	Tools    []dto.ToolCallRequest `json:"tools,omitempty"`
+}
+
+// ConversationToFineTuneRecord 将对话记录转换为 OpenAI 微调格式
+// 不同请求格式统一转换为 OpenAI chat messages，思考内容不会导出
+func ConversationToFineTuneRecord(conv *model.Conversation) (*FineTuneRecord, error) {
+	var record *FineTuneRecord
+	var assistant *dto.Message
+	var err error
+	switch conv.Format {
+	case "", string(types.RelayFormatOpenAI):
+		record, err = openAIConversationMessages(conv.RequestMessages)
+		if err == nil {
+			assistant = openAIConversationAssistant(conv.ResponseContent)
+		}
+	case types.RelayFormatClaude:
+		record, err = claudeConversationMessages(conv.RequestMessages)
+		if err == nil {
+			assistant, err = claudeConversationAssistant(conv.ResponseContent)
+		}
+	case types.RelayFormatGemini:
+		record, err = geminiConversationMessages(conv.RequestMessages)
+		if err == nil {
+			assistant, err = geminiConversationAssistant(conv.ResponseContent)
+		}
+	case types.RelayFormatOpenAIResponses:
+		record, err = responsesConversationMessages(conv.RequestMessages)
+		if err == nil {
+			assistant, err = responsesConversationAssistant(conv.ResponseContent)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported conversation format: %s", conv.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if assistant == nil {
+		return nil, errors.New("conversation has no assistant response")
+	}
+	if len(record.Messages) == 0 {
+		return nil, errors.New("conversation has no request messages")
+	}
+	for i := range record.Messages {
+		record.Messages[i].ReasoningContent = ""
+		record.Messages[i].Reasoning = ""
+	}
+	record.Messages = append(record.Messages, *assistant)
+	return record, nil
+}
+
+func openAIConversationMessages(requestMessages string) (*FineTuneRecord, error) {
+	var messages []dto.Message
+	if err := common.UnmarshalJsonStr(requestMessages, &messages); err != nil {
+		return nil, err
+	}
+	return &FineTuneRecord{Messages: messages}, nil
+}
+
+// openAIConversationAssistant 早期记录的响应是纯文本，之后为完整的 chat completion 对象
+func openAIConversationAssistant(responseContent string) *dto.Message {
+	var response dto.OpenAITextResponse
+	if err := common.UnmarshalJsonStr(responseContent, &response); err == nil && len(response.Choices) > 0 {
+		message := response.Choices[0].Message
+		message.Role = "assistant"
+		message.ReasoningContent = ""
+		message.Reasoning = ""
+		return &message
+	}
+	if strings.TrimSpace(responseContent) == "" {
+		return nil
+	}
+	message := dto.Message{Role: "assistant"}
+	message.SetStringContent(responseContent)
+	return &message
+}
+
+// exportRelayInfo 复用请求格式转换函数时所需的最小 RelayInfo
+func exportRelayInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+}
+
+func claudeConversationMessages(requestMessages string) (*FineTuneRecord, error) {
+	var claudeRequest dto.ClaudeRequest
+	if err := common.UnmarshalJsonStr(requestMessages, &claudeRequest); err != nil {
+		return nil, err
+	}
+	openAIRequest, err := ClaudeToOpenAIRequest(claudeRequest, exportRelayInfo())
+	if err != nil {
+		return nil, err
+	}
+	return &FineTuneRecord{Messages: openAIRequest.Messages, Tools: openAIRequest.Tools}, nil
+}
+
+func claudeConversationAssistant(responseContent string) (*dto.Message, error) {
+	var response dto.ClaudeResponse
+	if err := common.UnmarshalJsonStr(responseContent, &response); err != nil {
+		return nil, err
+	}
+	var texts []string
+	var toolCalls []dto.ToolCallRequest
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.GetText())
+		case "tool_use":
+			toolCalls = append(toolCalls, dto.ToolCallRequest{
+				ID:   block.Id,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      block.Name,
+					Arguments: toJSONString(block.Input),
+				},
+			})
+		}
+	}
+	return buildExportAssistant(texts, toolCalls), nil
+}
+
+func geminiConversationMessages(requestMessages string) (*FineTuneRecord, error) {
+	var geminiRequest dto.GeminiChatRequest
+	if err := common.UnmarshalJsonStr(requestMessages, &geminiRequest); err != nil {
+		return nil, err
+	}
+	openAIRequest, err := GeminiToOpenAIRequest(&geminiRequest, exportRelayInfo())
+	if err != nil {
+		return nil, err
+	}
+	record := &FineTuneRecord{Messages: openAIRequest.Messages}
+	// 记录中的 tools 为原始 JSON，functionDeclarations 需单独解析
+	var tools []dto.GeminiChatTool
+	if err := common.Unmarshal(geminiRequest.Tools, &tools); err != nil {
+		var tool dto.GeminiChatTool
+		if err := common.Unmarshal(geminiRequest.Tools, &tool); err == nil {
+			tools = append(tools, tool)
+		}
+	}
+	for _, tool := range tools {
+		declarations, err := common.Any2Type[[]dto.FunctionRequest](tool.FunctionDeclarations)
+		if err != nil {
+			continue
+		}
+		for _, function := range declarations {
+			record.Tools = append(record.Tools, dto.ToolCallRequest{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        function.Name,
+					Description: function.Description,
+					Parameters:  function.Parameters,
+				},
+			})
+		}
+	}
+	return record, nil
+}
+
+func geminiConversationAssistant(responseContent string) (*dto.Message, error) {
+	var response dto.GeminiChatResponse
+	if err := common.UnmarshalJsonStr(responseContent, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Candidates) == 0 {
+		return nil, nil
+	}
+	var texts []string
+	var toolCalls []dto.ToolCallRequest
+	for _, part := range response.Candidates[0].Content.Parts {
+		if part.Thought {
+			continue
+		}
+		if part.FunctionCall != nil {
+			toolCalls = append(toolCalls, dto.ToolCallRequest{
+				ID:   fmt.Sprintf("call_%d", len(toolCalls)+1),
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      part.FunctionCall.FunctionName,
+					Arguments: toJSONString(part.FunctionCall.Arguments),
+				},
+			})
+		} else if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return buildExportAssistant(texts, toolCalls), nil
+}
+
+// responsesItem Responses API 中 input / output 的单个元素，只保留导出需要的字段
+type responsesItem struct {
+	Type      string `json:"type"`
+	Role      string `json:"role"`
+	Content   any    `json:"content"`
+	CallId    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Output    any    `json:"output"`
+}
+
+// responsesItemText 取出 content 中的文本，content 可能是字符串或 input_text / output_text 数组
+func responsesItemText(content any) string {
+	if text, ok := content.(string); ok {
+		return text
+	}
+	parts, ok := content.([]any)
+	if !ok {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		if m, ok := part.(map[string]any); ok {
+			if text, ok := m["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, "")
+}
+
+func responsesConversationMessages(requestMessages string) (*FineTuneRecord, error) {
+	var request struct {
+		Instructions any   `json:"instructions"`
+		Input        any   `json:"input"`
+		Tools        []any `json:"tools"`
+	}
+	if err := common.UnmarshalJsonStr(requestMessages, &request); err != nil {
+		return nil, err
+	}
+	record := &FineTuneRecord{}
+	if instructions, ok := request.Instructions.(string); ok && instructions != "" {
+		message := dto.Message{Role: "system"}
+		message.SetStringContent(instructions)
+		record.Messages = append(record.Messages, message)
+	}
+	if input, ok := request.Input.(string); ok {
+		message := dto.Message{Role: "user"}
+		message.SetStringContent(input)
+		record.Messages = append(record.Messages, message)
+	} else {
+		items, err := common.Any2Type[[]responsesItem](request.Input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			switch item.Type {
+			case "", "message":
+				role := item.Role
+				if role == "developer" {
+					role = "system"
+				}
+				message := dto.Message{Role: role}
+				message.SetStringContent(responsesItemText(item.Content))
+				record.Messages = append(record.Messages, message)
+			case "function_call":
+				message := dto.Message{Role: "assistant"}
+				message.SetNullContent()
+				message.SetToolCalls([]dto.ToolCallRequest{{
+					ID:       item.CallId,
+					Type:     "function",
+					Function: dto.FunctionRequest{Name: item.Name, Arguments: item.Arguments},
+				}})
+				record.Messages = append(record.Messages, message)
+			case "function_call_output":
+				message := dto.Message{Role: "tool", ToolCallId: item.CallId}
+				if output, ok := item.Output.(string); ok {
+					message.SetStringContent(output)
+				} else {
+					message.SetStringContent(toJSONString(item.Output))
+				}
+				record.Messages = append(record.Messages, message)
+			}
+		}
+	}
+	for _, tool := range request.Tools {
+		m, ok := tool.(map[string]any)
+		if !ok || m["type"] != "function" {
+			continue
+		}
+		name, _ := m["name"].(string)
+		description, _ := m["description"].(string)
+		record.Tools = append(record.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        name,
+				Description: description,
+				Parameters:  m["parameters"],
+			},
+		})
+	}
+	return record, nil
+}
+
+func responsesConversationAssistant(responseContent string) (*dto.Message, error) {
+	var response struct {
+		Output []responsesItem `json:"output"`
+	}
+	if err := common.UnmarshalJsonStr(responseContent, &response); err != nil {
+		return nil, err
+	}
+	var texts []string
+	var toolCalls []dto.ToolCallRequest
+	for _, item := range response.Output {
+		switch item.Type {
+		case "message":
+			texts = append(texts, responsesItemText(item.Content))
+		case "function_call":
+			toolCalls = append(toolCalls, dto.ToolCallRequest{
+				ID:       item.CallId,
+				Type:     "function",
+				Function: dto.FunctionRequest{Name: item.Name, Arguments: item.Arguments},
+			})
+		}
+	}
+	return buildExportAssistant(texts, toolCalls), nil
+}
+
+// buildExportAssistant 组装 assistant 消息，没有任何内容时返回 nil
+func buildExportAssistant(texts []string, toolCalls []dto.ToolCallRequest) *dto.Message {
+	content := strings.Join(texts, "")
+	if content == "" && len(toolCalls) == 0 {
+		return nil
+	}
+	message := dto.Message{Role: "assistant"}
+	if content != "" {
+		message.SetStringContent(content)
+	} else {
+		message.SetNullContent()
+	}
+	if len(toolCalls) > 0 {
+		message.SetToolCalls(toolCalls)
+	}
+	return &message
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// 内置脱敏规则，按顺序执行：先处理 API Key 和信用卡，避免其中的数字被当作电话号码
+var builtinRedactionRules = []struct {
+	name    string
+	pattern *regexp.Regexp
+	// validate 为 nil 时命中即替换，否则仅在返回 true 时替换
+	validate func(match string) bool
+}{
+	{operation_setting.RedactionRuleApiKey, regexp.MustCompile(`\bsk-[A-Za-z0-9_\-]{16,}`), nil},
+	{operation_setting.RedactionRuleEmail, regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), nil},
+	{operation_setting.RedactionRuleCreditCard, regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`), luhnValid},
+	{operation_setting.RedactionRulePhone, regexp.MustCompile(`(?:\+\d{1,3}[ \-]?)?(?:\(\d{2,4}\)[ \-]?|\b\d{2,4}[ \-])\d{3,4}[ \-]?\d{4}\b|\b1[3-9]\d{9}\b`), nil},
+}
+
+var redactionRegexCache sync.Map
+
+func getRedactionRegex(pattern string) *regexp.Regexp {
+	if v, ok := redactionRegexCache.Load(pattern); ok {
+		return v.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	actual, _ := redactionRegexCache.LoadOrStore(pattern, re)
+	return actual.(*regexp.Regexp)
+}
+
+func redactionPlaceholder(name string) string {
+	return "[REDACTED:" + name + "]"
+}
+
+// luhnValid 信用卡号校验，减少对普通长数字的误判
+func luhnValid(match string) bool {
+	sum := 0
+	count := 0
+	double := false
+	for i := len(match) - 1; i >= 0; i-- {
+		ch := match[i]
+		if ch < '0' || ch > '9' {
+			continue
+		}
+		d := int(ch - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		count++
+	}
+	return count >= 13 && sum%10 == 0
+}
+
+// redactKeywords 使用 AC 自动机替换关键词，匹配不区分大小写
+func redactKeywords(text string, keywords []string) (string, bool) {
+	m := getOrBuildAC(keywords)
+	if m == nil || text == "" {
+		return text, false
+	}
+	// 逐个字符转小写，保证与原文的 rune 下标一一对应
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	hits := m.MultiPatternSearch(lower, false)
+	if len(hits) == 0 {
+		return text, false
+	}
+	// 合并重叠的命中区间
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Pos < hits[j].Pos
+	})
+	placeholder := redactionPlaceholder(operation_setting.RedactionRuleKeyword)
+	var builder strings.Builder
+	builder.Grow(len(text))
+	last := 0
+	for _, hit := range hits {
+		start, end := hit.Pos, hit.Pos+len(hit.Word)
+		if end <= last {
+			continue
+		}
+		if start < last {
+			start = last
+		} else {
+			builder.WriteString(string(runes[last:start]))
+			builder.WriteString(placeholder)
+		}
+		last = end
+	}
+	builder.WriteString(string(runes[last:]))
+	return builder.String(), true
+}
+
+// redactText 对单段文本执行全部脱敏规则，命中的规则名写入 fired
+func redactText(text string, setting *operation_setting.ConversationRedactionSetting, fired map[string]struct{}) string {
+	if text == "" {
+		return text
+	}
+	for _, rule := range builtinRedactionRules {
+		if !common.StringsContains(setting.BuiltinRules, rule.name) {
+			continue
+		}
+		hit := false
+		text = rule.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.validate != nil && !rule.validate(match) {
+				return match
+			}
+			hit = true
+			return redactionPlaceholder(rule.name)
+		})
+		if hit {
+			fired[rule.name] = struct{}{}
+		}
+	}
+	if len(setting.Keywords) > 0 {
+		var hit bool
+		text, hit = redactKeywords(text, setting.Keywords)
+		if hit {
+			fired[operation_setting.RedactionRuleKeyword] = struct{}{}
+		}
+	}
+	for _, rule := range setting.CustomRules {
+		re := getRedactionRegex(rule.Pattern)
+		if re == nil || !re.MatchString(text) {
+			continue
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = redactionPlaceholder(rule.Name)
+		}
+		text = re.ReplaceAllLiteralString(text, replacement)
+		fired[rule.Name] = struct{}{}
+	}
+	return text
+}
+
+// redactValue 递归处理 JSON 中的字符串值，保证替换后结构仍然合法
+func redactValue(value any, setting *operation_setting.ConversationRedactionSetting, fired map[string]struct{}) any {
+	switch v := value.(type) {
+	case string:
+		return redactText(v, setting, fired)
+	case []any:
+		for i := range v {
+			v[i] = redactValue(v[i], setting, fired)
+		}
+		return v
+	case map[string]any:
+		for key, item := range v {
+			v[key] = redactValue(item, setting, fired)
+		}
+		return v
+	}
+	return value
+}
+
+// redactContent 内容为 JSON 时只替换其中的字符串值，否则按纯文本处理
+func redactContent(content string, setting *operation_setting.ConversationRedactionSetting, fired map[string]struct{}) string {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var value any
+		if err := common.UnmarshalJsonStr(trimmed, &value); err == nil {
+			hits := make(map[string]struct{})
+			value = redactValue(value, setting, hits)
+			if len(hits) == 0 {
+				return content
+			}
+			if data, err := common.Marshal(value); err == nil {
+				for name := range hits {
+					fired[name] = struct{}{}
+				}
+				return string(data)
+			}
+		}
+	}
+	return redactText(content, setting, fired)
+}
+
+// RedactConversation 在对话落库前脱敏
+// 返回脱敏后的请求 JSON、响应内容以及命中的规则名（已排序去重）
+// 未开启脱敏时原样返回
+func RedactConversation(requestJSON string, responseContent string) (string, string, []string) {
+	setting := operation_setting.GetConversationRedactionSetting()
+	if !setting.Enabled {
+		return requestJSON, responseContent, nil
+	}
+	fired := make(map[string]struct{})
+	requestJSON = redactContent(requestJSON, setting, fired)
+	responseContent = redactContent(responseContent, setting, fired)
+	if len(fired) == 0 {
+		return requestJSON, responseContent, nil
+	}
+	rules := make([]string, 0, len(fired))
+	for name := range fired {
+		rules = append(rules, name)
+	}
+	sort.Strings(rules)
+	return requestJSON, responseContent, rules
+}
@@ -43,6 +43,9 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 	}
 
 	trustQuota := common.GetTrustQuota()
+	if budgetErr := checkSpendingBudgets(c, relayInfo, preConsumedQuota, trustQuota); budgetErr != nil {
+		return budgetErr
+	}
 
 	relayInfo.UserQuota = userQuota
 	if userQuota > trustQuota {
@@ -73,6 +76,7 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 			return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
 		}
 		logger.LogInfo(c, fmt.Sprintf("用户 %d 预扣费 %s, 预扣费后剩余额度: %s", relayInfo.UserId, logger.FormatQuota(preConsumedQuota), logger.FormatQuota(userQuota-preConsumedQuota)))
+		recordSpendingBudgets(relayInfo, preConsumedQuota)
 	}
 	relayInfo.FinalPreConsumedQuota = preConsumedQuota
 	return nil
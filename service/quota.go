@@ -157,6 +157,9 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string,
 	usage *dto.RealtimeUsage, extraContent string) {
 
+	RecordTPMUsage(ctx, usage.TotalTokens)
+	RecordChannelTokenUsage(ctx, usage.TotalTokens)
+
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.InputTokenDetails.TextTokens
 	textOutTokens := usage.OutputTokenDetails.TextTokens
@@ -236,11 +239,17 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 }
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	// 对冲请求中落选的尝试不计费
+	if relayInfo.HedgeLost() {
+		return
+	}
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
 	modelName := relayInfo.OriginModelName
+	RecordTPMUsage(ctx, promptTokens+completionTokens)
+	RecordChannelTokenUsage(ctx, promptTokens+completionTokens)
 
 	tokenName := ctx.GetString("token_name")
 	completionRatio := relayInfo.PriceData.CompletionRatio
@@ -362,6 +371,13 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData types.PriceData)
 }
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	// 对冲请求中落选的尝试不计费
+	if relayInfo.HedgeLost() {
+		return
+	}
+
+	RecordTPMUsage(ctx, usage.PromptTokens+usage.CompletionTokens)
+	RecordChannelTokenUsage(ctx, usage.PromptTokens+usage.CompletionTokens)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -509,6 +525,7 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 			return err
 		}
 	}
+	recordSpendingBudgets(relayInfo, quota)
 
 	if sendEmail {
 		if (quota + preConsumedQuota) != 0 {
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 令牌的 RPM / TPM 使用令牌桶限流，桶容量为每分钟上限，按秒匀速恢复
+// 为了使用整数运算，桶内的数量按 1/60 计数，即每秒恢复 perMinute 个单位
+
+const (
+	tokenRateLimitWindow = 60 // 秒
+	// tokenInflightExpiration Redis 中并发计数的过期时间，防止实例异常退出后计数无法释放
+	tokenInflightExpiration = 10 * time.Minute
+	tokenRateLimitTimeout   = 2 * time.Second
+)
+
+var (
+	tokenMemoryLimiter = limiter.NewMemoryLimiter()
+
+	tokenInflightLock sync.Mutex
+	tokenInflight     = make(map[int]int)
+)
+
+type tokenBucketResult struct {
+	allowed bool
+	limit   int
+	left    int64 // 扣除后桶内剩余的单位数，透支时为负数
+}
+
+func (r tokenBucketResult) remaining() int64 {
+	if r.left <= 0 {
+		return 0
+	}
+	return r.left / tokenRateLimitWindow
+}
+
+// resetSeconds 桶重新充满所需的秒数
+func (r tokenBucketResult) resetSeconds() int64 {
+	capacity := int64(r.limit) * tokenRateLimitWindow
+	return (capacity - r.left + int64(r.limit) - 1) / int64(r.limit)
+}
+
+// waitSeconds 桶内恢复到 need 个单位所需的秒数
+func (r tokenBucketResult) waitSeconds(need int64) int64 {
+	wait := (need - r.left + int64(r.limit) - 1) / int64(r.limit)
+	if wait < 1 {
+		wait = 1
+	}
+	return wait
+}
+
+func takeTokenBucket(key string, perMinute int, amount int, mode limiter.TakeMode) (tokenBucketResult, error) {
+	result := tokenBucketResult{limit: perMinute}
+	opts := []limiter.Option{
+		limiter.WithCapacity(int64(perMinute) * tokenRateLimitWindow),
+		limiter.WithRate(int64(perMinute)),
+		limiter.WithRequested(int64(amount) * tokenRateLimitWindow),
+	}
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenRateLimitTimeout)
+		defer cancel()
+		allowed, left, err := limiter.New(ctx, common.RDB).Take(ctx, key, mode, opts...)
+		if err != nil {
+			return result, err
+		}
+		result.allowed, result.left = allowed, left
+		return result, nil
+	}
+	result.allowed, result.left = tokenMemoryLimiter.Take(key, mode, opts...)
+	return result, nil
+}
+
+func setTokenRateLimitHeaders(c *gin.Context, kind string, result tokenBucketResult) {
+	c.Header("x-ratelimit-limit-"+kind, strconv.Itoa(result.limit))
+	c.Header("x-ratelimit-remaining-"+kind, strconv.FormatInt(result.remaining(), 10))
+	c.Header("x-ratelimit-reset-"+kind, (time.Duration(result.resetSeconds()) * time.Second).String())
+}
+
+func rateLimitError(c *gin.Context, code types.ErrorCode, retryAfter int64, message string) *types.NewAPIError {
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	return types.NewErrorWithStatusCode(fmt.Errorf("%s", message), code,
+		http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+}
+
+func acquireTokenInflight(tokenId int, maxConcurrency int) (bool, error) {
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenRateLimitTimeout)
+		defer cancel()
+		key := fmt.Sprintf("tokenRateLimit:inflight:%d", tokenId)
+		count, err := common.RDB.Incr(ctx, key).Result()
+		if err != nil {
+			return false, err
+		}
+		common.RDB.Expire(ctx, key, tokenInflightExpiration)
+		if count > int64(maxConcurrency) {
+			common.RDB.Decr(ctx, key)
+			return false, nil
+		}
+		return true, nil
+	}
+	tokenInflightLock.Lock()
+	defer tokenInflightLock.Unlock()
+	if tokenInflight[tokenId] >= maxConcurrency {
+		return false, nil
+	}
+	tokenInflight[tokenId]++
+	return true, nil
+}
+
+func releaseTokenInflight(tokenId int) {
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenRateLimitTimeout)
+		defer cancel()
+		key := fmt.Sprintf("tokenRateLimit:inflight:%d", tokenId)
+		if count, err := common.RDB.Decr(ctx, key).Result(); err == nil && count <= 0 {
+			common.RDB.Del(ctx, key)
+		}
+		return
+	}
+	tokenInflightLock.Lock()
+	defer tokenInflightLock.Unlock()
+	if tokenInflight[tokenId] <= 1 {
+		delete(tokenInflight, tokenId)
+	} else {
+		tokenInflight[tokenId]--
+	}
+}
+
+// AcquireTokenRateLimit 检查令牌的并发、RPM 和 TPM 限制，并在响应头中返回 x-ratelimit-* 信息
+// TPM 在请求开始时按预估的输入 token 数扣除，请求结束后由 RecordTPMUsage 补扣实际用量
+// 返回的 release 用于释放并发占用，需要在请求结束后调用；之后的准入检查拒绝请求时，调用方需通过 RefundRateLimitCharges 退还预扣
+func AcquireTokenRateLimit(c *gin.Context, relayInfo *relaycommon.RelayInfo) (release func(), apiErr *types.NewAPIError) {
+	release = func() {}
+	tokenId := relayInfo.TokenId
+	if tokenId <= 0 {
+		return release, nil
+	}
+	rpm := common.GetContextKeyInt(c, constant.ContextKeyTokenRPM)
+	tpm := common.GetContextKeyInt(c, constant.ContextKeyTokenTPM)
+	maxConcurrency := common.GetContextKeyInt(c, constant.ContextKeyTokenMaxConcurrency)
+
+	// 限流存储异常时不拦截请求，避免 Redis 抖动导致服务不可用
+	if maxConcurrency > 0 {
+		acquired, err := acquireTokenInflight(tokenId, maxConcurrency)
+		if err != nil {
+			logger.LogError(c, "failed to acquire token concurrency: "+err.Error())
+		} else if !acquired {
+			return release, rateLimitError(c, types.ErrorCodeTokenRateLimitExceeded, 1, fmt.Sprintf("令牌并发请求数已达上限：最多同时处理 %d 个请求", maxConcurrency))
+		} else {
+			var once sync.Once
+			release = func() {
+				once.Do(func() {
+					releaseTokenInflight(tokenId)
+				})
+			}
+		}
+	}
+
+	if rpm > 0 {
+		key := fmt.Sprintf("tokenRateLimit:rpm:%d", tokenId)
+		result, err := takeTokenBucket(key, rpm, 1, limiter.TakeModeEnough)
+		if err != nil {
+			logger.LogError(c, "failed to check token rpm: "+err.Error())
+		} else {
+			setTokenRateLimitHeaders(c, "requests", result)
+			if !result.allowed {
+				release()
+				return func() {}, rateLimitError(c, types.ErrorCodeTokenRateLimitExceeded, result.waitSeconds(tokenRateLimitWindow),
+					fmt.Sprintf("令牌已达到请求数限制：每分钟最多请求 %d 次", rpm))
+			}
+			common.SetContextKey(c, constant.ContextKeyRPMCharges, []rateLimitCharge{{key: key, perMinute: rpm}})
+		}
+	}
+
+	if tpm > 0 {
+		result, err := chargeTPM(c, fmt.Sprintf("tokenRateLimit:tpm:%d", tokenId), tpm, relayInfo.PromptTokens)
+		if err != nil {
+			logger.LogError(c, "failed to check token tpm: "+err.Error())
+		} else if !result.allowed {
+			release()
+			RefundRateLimitCharges(c)
+			return func() {}, rateLimitError(c, types.ErrorCodeTokenRateLimitExceeded, result.waitSeconds(1),
+				fmt.Sprintf("令牌已达到 token 数限制：每分钟最多使用 %d 个 token", tpm))
+		}
+	}
+	return release, nil
+}
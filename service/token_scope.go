@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenScopeEndpoint 返回请求路径对应的令牌权限范围端点类型，无法识别时返回空字符串
+func TokenScopeEndpoint(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/mj") || strings.Contains(path, "/mj/"):
+		return dto.TokenScopeEndpointMidjourney
+	case strings.HasPrefix(path, "/suno"):
+		return dto.TokenScopeEndpointSuno
+	case strings.HasPrefix(path, "/v1/video") || strings.HasPrefix(path, "/kling") || strings.HasPrefix(path, "/jimeng"):
+		return dto.TokenScopeEndpointVideo
+	case strings.HasPrefix(path, "/v1/messages"):
+		return dto.TokenScopeEndpointChat
+	}
+	switch relayconstant.Path2RelayMode(path) {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions, relayconstant.RelayModeEdits:
+		return dto.TokenScopeEndpointChat
+	case relayconstant.RelayModeResponses:
+		return dto.TokenScopeEndpointResponses
+	case relayconstant.RelayModeEmbeddings:
+		return dto.TokenScopeEndpointEmbeddings
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+		return dto.TokenScopeEndpointImages
+	case relayconstant.RelayModeAudioSpeech, relayconstant.RelayModeAudioTranscription, relayconstant.RelayModeAudioTranslation:
+		return dto.TokenScopeEndpointAudio
+	case relayconstant.RelayModeRealtime:
+		return dto.TokenScopeEndpointRealtime
+	case relayconstant.RelayModeRerank:
+		return dto.TokenScopeEndpointRerank
+	case relayconstant.RelayModeModerations:
+		return dto.TokenScopeEndpointModerations
+	case relayconstant.RelayModeGemini:
+		if strings.Contains(path, "embed") {
+			return dto.TokenScopeEndpointEmbeddings
+		}
+		return dto.TokenScopeEndpointChat
+	}
+	return ""
+}
+
+func getTokenScope(c *gin.Context) *dto.TokenScope {
+	scope, ok := common.GetContextKeyType[*dto.TokenScope](c, constant.ContextKeyTokenScope)
+	if !ok {
+		return nil
+	}
+	return scope
+}
+
+func tokenScopeError(err error) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(err, types.ErrorCodeTokenScopeViolation, http.StatusForbidden,
+		types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+}
+
+// CheckTokenScopeEndpoint 检查令牌是否允许访问当前端点
+func CheckTokenScopeEndpoint(c *gin.Context) error {
+	scope := getTokenScope(c)
+	if scope == nil || len(scope.Endpoints) == 0 {
+		return nil
+	}
+	endpoint := TokenScopeEndpoint(c.Request.URL.Path)
+	if endpoint == "" || !common.StringsContains(scope.Endpoints, endpoint) {
+		return fmt.Errorf("该令牌无权访问此端点，允许的端点类型: %s", strings.Join(scope.Endpoints, ", "))
+	}
+	return nil
+}
+
+// requestToolNames 返回请求中使用的工具名称，包括工具的 type、name，以及 Gemini 风格工具对象的键名
+func requestToolNames(body map[string]any) []string {
+	var names []string
+	if _, ok := body["web_search_options"]; ok {
+		names = append(names, "web_search")
+	}
+	tools, _ := body["tools"].([]any)
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]any)
+		if !ok {
+			continue
+		}
+		toolType, hasType := toolMap["type"].(string)
+		if hasType {
+			names = append(names, toolType)
+		}
+		if name, ok := toolMap["name"].(string); ok {
+			names = append(names, name)
+		}
+		if !hasType {
+			for key := range toolMap {
+				names = append(names, key)
+			}
+		}
+	}
+	return names
+}
+
+// CheckTokenScopeRequest 在预扣费前检查请求的 max_tokens、n、参数和工具是否在令牌的权限范围内
+func CheckTokenScopeRequest(c *gin.Context, meta *types.TokenCountMeta) *types.NewAPIError {
+	scope := getTokenScope(c)
+	if scope == nil {
+		return nil
+	}
+	endpoint := TokenScopeEndpoint(c.Request.URL.Path)
+	if scope.MaxTokens > 0 && (endpoint == dto.TokenScopeEndpointChat || endpoint == dto.TokenScopeEndpointResponses) {
+		if meta.MaxTokens <= 0 {
+			return tokenScopeError(fmt.Errorf("该令牌要求请求指定 max_tokens，且不超过 %d", scope.MaxTokens))
+		}
+		if meta.MaxTokens > scope.MaxTokens {
+			return tokenScopeError(fmt.Errorf("max_tokens %d 超过该令牌允许的上限 %d", meta.MaxTokens, scope.MaxTokens))
+		}
+	}
+	if scope.MaxN == 0 && len(scope.ForbiddenParams) == 0 && len(scope.ForbiddenTools) == 0 {
+		return nil
+	}
+	// multipart 等非 JSON 请求没有可检查的参数
+	if !strings.Contains(c.ContentType(), "json") {
+		return nil
+	}
+	var body map[string]any
+	if err := common.UnmarshalBodyReusable(c, &body); err != nil {
+		logger.LogError(c, "failed to parse request body for token scope: "+err.Error())
+		return nil
+	}
+
+	if scope.MaxN > 0 {
+		n, _ := body["n"].(float64)
+		if generationConfig, ok := body["generationConfig"].(map[string]any); ok {
+			if candidateCount, ok := generationConfig["candidateCount"].(float64); ok && candidateCount > n {
+				n = candidateCount
+			}
+		}
+		if int(n) > scope.MaxN {
+			return tokenScopeError(fmt.Errorf("n=%d 超过该令牌允许的上限 %d", int(n), scope.MaxN))
+		}
+	}
+	for _, param := range scope.ForbiddenParams {
+		if _, ok := body[param]; ok {
+			return tokenScopeError(fmt.Errorf("该令牌禁止使用参数 %s", param))
+		}
+	}
+	if len(scope.ForbiddenTools) > 0 {
+		for _, name := range requestToolNames(body) {
+			for _, pattern := range scope.ForbiddenTools {
+				if common.WildcardMatch(pattern, name) {
+					return tokenScopeError(fmt.Errorf("该令牌禁止使用工具 %s", name))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckTokenScopeQuota 检查按模型价格预估的单次请求费用是否超过令牌允许的上限
+func CheckTokenScopeQuota(c *gin.Context, priceData types.PriceData) *types.NewAPIError {
+	scope := getTokenScope(c)
+	if scope == nil || scope.MaxRequestQuota <= 0 || priceData.FreeModel {
+		return nil
+	}
+	if priceData.QuotaToPreConsume > scope.MaxRequestQuota {
+		return tokenScopeError(fmt.Errorf("本次请求预估费用 %s 超过该令牌单次请求的上限 %s",
+			logger.FormatQuota(priceData.QuotaToPreConsume), logger.FormatQuota(scope.MaxRequestQuota)))
+	}
+	return nil
+}
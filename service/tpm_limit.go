@@ -0,0 +1,148 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TPM 限制在请求开始时按 CountRequestToken 预估的输入 token 数预扣，桶内仍有余量即放行（允许透支），
+// 请求结束后由 RecordTPMUsage 按实际用量补扣差额，透支的部分会推迟之后请求的放行时间
+
+// rateLimitCharge 本次请求预扣过的 RPM / TPM 桶
+type rateLimitCharge struct {
+	key       string
+	perMinute int
+}
+
+// chargeTPM 按预估 token 数预扣 TPM 桶，并在响应头中返回剩余最少的 TPM 桶
+func chargeTPM(c *gin.Context, key string, perMinute int, tokens int) (tokenBucketResult, error) {
+	result, err := takeTokenBucket(key, perMinute, tokens, limiter.TakeModePositive)
+	if err != nil {
+		return result, err
+	}
+	current, parseErr := strconv.ParseInt(c.Writer.Header().Get("x-ratelimit-remaining-tokens"), 10, 64)
+	if parseErr != nil || result.remaining() < current {
+		setTokenRateLimitHeaders(c, "tokens", result)
+	}
+	if result.allowed {
+		charges, _ := common.GetContextKeyType[[]rateLimitCharge](c, constant.ContextKeyTPMCharges)
+		common.SetContextKey(c, constant.ContextKeyTPMCharges, append(charges, rateLimitCharge{key: key, perMinute: perMinute}))
+		common.SetContextKey(c, constant.ContextKeyTPMCharged, tokens)
+	}
+	return result, nil
+}
+
+// AcquireModelTPMLimit 检查用户在分组和模型上的 TPM 限制，随模型请求速率限制一起启用
+func AcquireModelTPMLimit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if !setting.ModelRequestRateLimitEnabled || relayInfo.UserId <= 0 {
+		return nil
+	}
+	group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	}
+
+	if tpm := setting.GetGroupTPMLimit(group); tpm > 0 {
+		result, err := chargeTPM(c, fmt.Sprintf("rateLimit:TPM:%d", relayInfo.UserId), tpm, relayInfo.PromptTokens)
+		if err != nil {
+			logger.LogError(c, "failed to check group tpm: "+err.Error())
+		} else if !result.allowed {
+			return rateLimitError(c, types.ErrorCodeTPMLimitExceeded, result.waitSeconds(1),
+				fmt.Sprintf("您已达到 token 数限制：每分钟最多使用 %d 个 token", tpm))
+		}
+	}
+
+	return acquireModelTPM(c, relayInfo)
+}
+
+// AcquireFallbackModelTPMLimit 切换到备用模型时检查备用模型的 TPM 限制，分组 TPM 已在请求开始时预扣，不重复扣除
+func AcquireFallbackModelTPMLimit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if !setting.ModelRequestRateLimitEnabled || relayInfo.UserId <= 0 {
+		return nil
+	}
+	return acquireModelTPM(c, relayInfo)
+}
+
+// acquireModelTPM 按 relayInfo.OriginModelName 检查模型 TPM 限制
+func acquireModelTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	modelName := relayInfo.OriginModelName
+	if tpm := setting.GetModelTPMLimit(modelName); tpm > 0 {
+		result, err := chargeTPM(c, fmt.Sprintf("rateLimit:TPM:%d:%s", relayInfo.UserId, modelName), tpm, relayInfo.PromptTokens)
+		if err != nil {
+			logger.LogError(c, "failed to check model tpm: "+err.Error())
+		} else if !result.allowed {
+			return rateLimitError(c, types.ErrorCodeTPMLimitExceeded, result.waitSeconds(1),
+				fmt.Sprintf("您已达到模型 %s 的 token 数限制：每分钟最多使用 %d 个 token", modelName, tpm))
+		}
+	}
+	return nil
+}
+
+// RefundRateLimitCharges 请求在准入阶段被拒绝时退还已预扣的令牌 RPM 和各 TPM 桶，被拒绝的请求不占用限额
+func RefundRateLimitCharges(c *gin.Context) {
+	if charges, ok := common.GetContextKeyType[[]rateLimitCharge](c, constant.ContextKeyRPMCharges); ok {
+		for _, charge := range charges {
+			refundTokenBucket(c, charge, 1)
+		}
+		common.SetContextKey(c, constant.ContextKeyRPMCharges, []rateLimitCharge(nil))
+	}
+	charged := common.GetContextKeyInt(c, constant.ContextKeyTPMCharged)
+	if charges, ok := common.GetContextKeyType[[]rateLimitCharge](c, constant.ContextKeyTPMCharges); ok && charged > 0 {
+		for _, charge := range charges {
+			refundTokenBucket(c, charge, charged)
+		}
+		common.SetContextKey(c, constant.ContextKeyTPMCharges, []rateLimitCharge(nil))
+		common.SetContextKey(c, constant.ContextKeyTPMCharged, 0)
+	}
+}
+
+// refundTokenBucket 以负数强制扣除的方式把 amount 加回桶内
+func refundTokenBucket(c *gin.Context, charge rateLimitCharge, amount int) {
+	if _, err := takeTokenBucket(charge.key, charge.perMinute, -amount, limiter.TakeModeForce); err != nil {
+		logger.LogError(c, "failed to refund rate limit: "+err.Error())
+	}
+}
+
+// RecordChannelTokenUsage 请求结束后按实际使用的 token 总数补记渠道 TPM 中预估不足的部分，与 RecordTPMUsage 一起调用
+// 多 key 渠道同时补记所用 key 的本地 TPM 预算，余量调度模式据此选择 key
+func RecordChannelTokenUsage(c *gin.Context, totalTokens int) {
+	charged, ok := common.GetContextKeyType[int](c, constant.ContextKeyChannelLimitCharged)
+	if !ok || totalTokens <= charged {
+		return
+	}
+	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
+	model.RecordChannelLimitTokens(channelId, totalTokens-charged)
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		model.RecordChannelKeyTokens(channelId, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), totalTokens-charged)
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelLimitCharged, totalTokens)
+}
+
+// RecordTPMUsage 请求结束后按实际使用的 token 总数补扣各 TPM 桶中预估不足的部分，实际用量少于预估时不退还
+func RecordTPMUsage(c *gin.Context, totalTokens int) {
+	charges, ok := common.GetContextKeyType[[]rateLimitCharge](c, constant.ContextKeyTPMCharges)
+	if !ok || len(charges) == 0 {
+		return
+	}
+	charged := common.GetContextKeyInt(c, constant.ContextKeyTPMCharged)
+	if totalTokens <= charged {
+		return
+	}
+	for _, charge := range charges {
+		if _, err := takeTokenBucket(charge.key, charge.perMinute, totalTokens-charged, limiter.TakeModeForce); err != nil {
+			logger.LogError(c, "failed to record tpm usage: "+err.Error())
+		}
+	}
+	common.SetContextKey(c, constant.ContextKeyTPMCharged, totalTokens)
+}
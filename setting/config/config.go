@@ -216,6 +216,15 @@ func updateConfigFromMap(config interface{}, configMap map[string]string) error
 			}
 			field.SetFloat(floatValue)
 		case reflect.Map, reflect.Slice, reflect.Struct:
+			if field.Kind() == reflect.Map && fieldType.Tag.Get("config") == "replace" {
+				// 标记为 replace 的 map 整体替换，直接反序列化到原 map 会保留已删除的 key
+				newValue := reflect.New(field.Type())
+				if err := json.Unmarshal([]byte(strValue), newValue.Interface()); err != nil {
+					continue
+				}
+				field.Set(newValue.Elem())
+				continue
+			}
 			// 复杂类型使用JSON反序列化
 			err := json.Unmarshal([]byte(strValue), field.Addr().Interface())
 			if err != nil {
@@ -0,0 +1,31 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelAffinitySetting 渠道亲和：同一会话的连续请求固定到同一个 (渠道, key)，提高上游提示词缓存命中率
+type ChannelAffinitySetting struct {
+	Enabled bool `json:"enabled"`
+	// 绑定关系的有效期（秒），每次成功请求后续期
+	TTLSeconds int `json:"ttl_seconds"`
+	// 请求没有会话标识和 user 字段时，取 system 和前几条消息计算会话哈希
+	PrefixMessages int `json:"prefix_messages"`
+	// 绑定渠道在该模型上的健康分低于该值时重新选择渠道
+	MinHealthScore float64 `json:"min_health_score"`
+}
+
+// 默认配置
+var channelAffinitySetting = ChannelAffinitySetting{
+	Enabled:        false,
+	TTLSeconds:     3600,
+	PrefixMessages: 1,
+	MinHealthScore: 0.2,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_affinity_setting", &channelAffinitySetting)
+}
+
+func GetChannelAffinitySetting() *ChannelAffinitySetting {
+	return &channelAffinitySetting
+}
@@ -0,0 +1,33 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelCircuitBreakerSetting 按 (渠道, 模型) 熔断，启用后单 key 渠道出错时不再自动禁用
+type ChannelCircuitBreakerSetting struct {
+	Enabled bool `json:"enabled"`
+	// 统计窗口（秒）内失败次数达到阈值时熔断
+	FailureThreshold int `json:"failure_threshold"`
+	WindowSeconds    int `json:"window_seconds"`
+	// 熔断后经过冷却时间进入半开状态
+	CooldownSeconds int `json:"cooldown_seconds"`
+	// 半开状态下每隔多少秒放行一个真实请求试探，成功则恢复，失败则重新熔断
+	ProbeIntervalSeconds int `json:"probe_interval_seconds"`
+}
+
+// 默认配置
+var channelCircuitBreakerSetting = ChannelCircuitBreakerSetting{
+	Enabled:              false,
+	FailureThreshold:     5,
+	WindowSeconds:        60,
+	CooldownSeconds:      60,
+	ProbeIntervalSeconds: 10,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_circuit_breaker_setting", &channelCircuitBreakerSetting)
+}
+
+func GetChannelCircuitBreakerSetting() *ChannelCircuitBreakerSetting {
+	return &channelCircuitBreakerSetting
+}
@@ -0,0 +1,31 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelCostRoutingSetting 成本路由：同一优先级内优先选择上游采购价最低的健康渠道
+// 采购价在渠道设置的 upstream_prices 中配置，未配置采购价的渠道不参与比价，始终按正常权重参与选择
+type ChannelCostRoutingSetting struct {
+	Enabled bool `json:"enabled"`
+	// 健康分低于该值的渠道不参与成本优先选择，按正常权重兜底
+	MinHealthScore float64 `json:"min_health_score"`
+	// 比价时按该 token 数估算一次请求的美元成本，使按 token 计价和按次计价的渠道可以直接比较
+	ReferencePromptTokens     int `json:"reference_prompt_tokens"`
+	ReferenceCompletionTokens int `json:"reference_completion_tokens"`
+}
+
+// 默认配置
+var channelCostRoutingSetting = ChannelCostRoutingSetting{
+	Enabled:                   false,
+	MinHealthScore:            0.2,
+	ReferencePromptTokens:     1000,
+	ReferenceCompletionTokens: 500,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_cost_routing_setting", &channelCostRoutingSetting)
+}
+
+func GetChannelCostRoutingSetting() *ChannelCostRoutingSetting {
+	return &channelCostRoutingSetting
+}
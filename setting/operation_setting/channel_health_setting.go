@@ -0,0 +1,35 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelHealthSetting 按真实转发结果计算的渠道健康分
+type ChannelHealthSetting struct {
+	// 是否按健康分调整渠道选择权重，关闭后仍会统计
+	Enabled bool `json:"enabled"`
+	// 统计数据的半衰期（秒），越小越侧重最近的请求
+	HalfLifeSeconds int `json:"half_life_seconds"`
+	// 参考延迟（毫秒），首字 / 总延迟等于该值时延迟得分为 0.5
+	LatencyReferenceMs int `json:"latency_reference_ms"`
+	// 成功率的指数，越大对失败越敏感
+	ErrorPenalty float64 `json:"error_penalty"`
+	// 健康分下限，保证异常渠道仍有少量流量用于恢复
+	MinScore float64 `json:"min_score"`
+}
+
+// 默认配置
+var channelHealthSetting = ChannelHealthSetting{
+	Enabled:            true,
+	HalfLifeSeconds:    300,
+	LatencyReferenceMs: 3000,
+	ErrorPenalty:       2,
+	MinScore:           0.05,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_health_setting", &channelHealthSetting)
+}
+
+func GetChannelHealthSetting() *ChannelHealthSetting {
+	return &channelHealthSetting
+}
@@ -0,0 +1,39 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// HedgeGroupRule 分组级别的对冲策略
+type HedgeGroupRule struct {
+	// 首个渠道超过该时间（毫秒）仍未收到上游响应时，向第二个渠道发起相同请求
+	DelayMs int `json:"delay_ms"`
+}
+
+// ChannelHedgeSetting 对冲请求：以多一次上游调用为代价降低慢渠道带来的延迟，只对配置了规则的分组生效
+type ChannelHedgeSetting struct {
+	Enabled bool `json:"enabled"`
+	// 分组规则，key 为分组名；加载时整体替换，删除的分组不会残留
+	GroupRules map[string]HedgeGroupRule `json:"group_rules" config:"replace"`
+}
+
+// 默认配置
+var channelHedgeSetting = ChannelHedgeSetting{
+	Enabled:    false,
+	GroupRules: map[string]HedgeGroupRule{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_hedge_setting", &channelHedgeSetting)
+}
+
+func GetChannelHedgeSetting() *ChannelHedgeSetting {
+	return &channelHedgeSetting
+}
+
+// GetHedgeDelayMs 返回分组的对冲等待时间，未启用或分组未配置时返回 0
+func GetHedgeDelayMs(group string) int {
+	if !channelHedgeSetting.Enabled {
+		return 0
+	}
+	return max(channelHedgeSetting.GroupRules[group].DelayMs, 0)
+}
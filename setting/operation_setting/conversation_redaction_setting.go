@@ -0,0 +1,76 @@
+package operation_setting
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 内置脱敏规则名称，同时作为命中记录写入对话表
+const (
+	RedactionRuleEmail      = "email"
+	RedactionRulePhone      = "phone"
+	RedactionRuleCreditCard = "credit_card"
+	RedactionRuleApiKey     = "api_key"
+	RedactionRuleKeyword    = "keyword"
+)
+
+// ConversationRedactionRule 自定义正则脱敏规则
+type ConversationRedactionRule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"` // 为空时使用 [REDACTED:name]
+}
+
+type ConversationRedactionSetting struct {
+	Enabled bool `json:"enabled"`
+	// 启用的内置规则：email / phone / credit_card / api_key
+	BuiltinRules []string `json:"builtin_rules"`
+	// 关键词列表，使用与敏感词检测相同的 AC 自动机匹配，不区分大小写
+	Keywords []string `json:"keywords"`
+	// 自定义正则规则，按顺序在内置规则之后执行
+	CustomRules []ConversationRedactionRule `json:"custom_rules"`
+}
+
+// 默认关闭，开启后启用全部内置规则
+var conversationRedactionSetting = ConversationRedactionSetting{
+	Enabled: false,
+	BuiltinRules: []string{
+		RedactionRuleApiKey,
+		RedactionRuleEmail,
+		RedactionRuleCreditCard,
+		RedactionRulePhone,
+	},
+	Keywords:    []string{},
+	CustomRules: []ConversationRedactionRule{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("conversation_redaction_setting", &conversationRedactionSetting)
+}
+
+func GetConversationRedactionSetting() *ConversationRedactionSetting {
+	return &conversationRedactionSetting
+}
+
+// ValidateConversationRedactionRules 校验内置规则名称和自定义正则是否合法
+func ValidateConversationRedactionRules(builtinRules []string, customRules []ConversationRedactionRule) error {
+	for _, name := range builtinRules {
+		switch name {
+		case RedactionRuleEmail, RedactionRulePhone, RedactionRuleCreditCard, RedactionRuleApiKey:
+		default:
+			return fmt.Errorf("未知的内置脱敏规则: %s", name)
+		}
+	}
+	for _, rule := range customRules {
+		if rule.Name == "" {
+			return fmt.Errorf("自定义脱敏规则名称不能为空")
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("自定义脱敏规则 %s 的正则无效: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
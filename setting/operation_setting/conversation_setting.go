@@ -0,0 +1,115 @@
+package operation_setting
+
+import (
+	"math/rand"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 对话记录策略模式，分组规则、令牌和用户设置共用
+const (
+	ConversationLogModeDefault = ""       // 继承上一级策略
+	ConversationLogModeAlways  = "always" // 全部记录
+	ConversationLogModeNever   = "never"  // 从不记录
+	ConversationLogModeSample  = "sample" // 按采样率记录，仅分组规则可用
+)
+
+// 对话存储模式
+const (
+	ConversationStorageModePlain      = "plain"      // 明文存储在 conversations 表
+	ConversationStorageModeCompressed = "compressed" // GZIP 压缩后存储在 conversations_compressed 表
+)
+
+// ConversationGroupRule 分组级别的记录规则
+type ConversationGroupRule struct {
+	Mode       string  `json:"mode"`
+	SampleRate float64 `json:"sample_rate"` // 仅 sample 模式生效，取值 0-1
+}
+
+type ConversationSetting struct {
+	// 未命中分组规则且令牌 / 用户未指定时的采样率，取值 0-1
+	SampleRate float64 `json:"sample_rate"`
+	// 分组规则，key 为分组名；加载时整体替换，删除的分组不会残留
+	GroupRules map[string]ConversationGroupRule `json:"group_rules" config:"replace"`
+	// 是否允许令牌和用户自行开启 / 关闭记录
+	AllowUserOverride bool `json:"allow_user_override"`
+	// 新记录的存储模式，切换后已有记录不受影响，可通过后台迁移任务转为压缩存储
+	StorageMode string `json:"storage_mode"`
+}
+
+// 默认配置：全部记录，与原先的全局开关行为一致
+var conversationSetting = ConversationSetting{
+	SampleRate:        1,
+	GroupRules:        map[string]ConversationGroupRule{},
+	AllowUserOverride: true,
+	StorageMode:       ConversationStorageModePlain,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("conversation_setting", &conversationSetting)
+}
+
+func GetConversationSetting() *ConversationSetting {
+	return &conversationSetting
+}
+
+// IsConversationCompressedStorage 新记录是否写入压缩表
+func IsConversationCompressedStorage() bool {
+	return conversationSetting.StorageMode == ConversationStorageModeCompressed
+}
+
+// IsValidConversationLogMode 校验令牌 / 用户可设置的模式
+func IsValidConversationLogMode(mode string) bool {
+	switch mode {
+	case ConversationLogModeDefault, ConversationLogModeAlways, ConversationLogModeNever:
+		return true
+	}
+	return false
+}
+
+// ShouldRecordConversation 按策略判断本次请求是否记录对话
+// groups 依次为实际使用的分组和用户所在分组，任一分组为 never 即不记录
+// 优先级：分组 never > 分组 always > 令牌设置 > 用户设置 > 分组采样率 > 全局采样率
+func ShouldRecordConversation(groups []string, tokenMode string, userMode string) bool {
+	sampleRate := conversationSetting.SampleRate
+	sampled := false
+	always := false
+	for _, group := range groups {
+		rule, ok := conversationSetting.GroupRules[group]
+		if !ok {
+			continue
+		}
+		switch rule.Mode {
+		case ConversationLogModeNever:
+			return false
+		case ConversationLogModeAlways:
+			always = true
+		case ConversationLogModeSample:
+			if !sampled {
+				sampleRate = rule.SampleRate
+				sampled = true
+			}
+		}
+	}
+	if always {
+		return true
+	}
+	if conversationSetting.AllowUserOverride {
+		for _, mode := range []string{tokenMode, userMode} {
+			switch mode {
+			case ConversationLogModeAlways:
+				return true
+			case ConversationLogModeNever:
+				return false
+			}
+		}
+	}
+	if sampleRate >= 1 {
+		return true
+	}
+	if sampleRate <= 0 {
+		return false
+	}
+	return rand.Float64() < sampleRate
+}
@@ -0,0 +1,39 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ModelFallbackAllGroups 对所有分组生效的备用链配置 key，分组未单独配置时使用
+const ModelFallbackAllGroups = "*"
+
+// ModelFallbackSetting 模型备用链：某个模型的所有渠道重试失败后，依次改用备用模型
+type ModelFallbackSetting struct {
+	Enabled bool `json:"enabled"`
+	// 分组 -> 模型 -> 按顺序尝试的备用模型列表；加载时整体替换，删除的分组不会残留
+	Chains map[string]map[string][]string `json:"chains" config:"replace"`
+}
+
+// 默认配置
+var modelFallbackSetting = ModelFallbackSetting{
+	Enabled: false,
+	Chains:  map[string]map[string][]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback_setting", &modelFallbackSetting)
+}
+
+func GetModelFallbackSetting() *ModelFallbackSetting {
+	return &modelFallbackSetting
+}
+
+// GetModelFallbackChain 返回分组下模型的备用链，分组未配置该模型时使用 "*" 的配置
+func GetModelFallbackChain(group string, modelName string) []string {
+	if !modelFallbackSetting.Enabled {
+		return nil
+	}
+	if chain, ok := modelFallbackSetting.Chains[group][modelName]; ok {
+		return chain
+	}
+	return modelFallbackSetting.Chains[ModelFallbackAllGroups][modelName]
+}
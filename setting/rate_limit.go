@@ -16,6 +16,15 @@ var ModelRequestRateLimitSuccessCount = 1000
 var ModelRequestRateLimitGroup = map[string][2]int{}
 var ModelRequestRateLimitMutex sync.RWMutex
 
+// ModelRequestRateLimitTPM 用户每分钟最多使用的 token 数，0 代表不限制
+var ModelRequestRateLimitTPM = 0
+
+// ModelRequestRateLimitTPMGroup 分组的用户 TPM，优先级高于 ModelRequestRateLimitTPM
+var ModelRequestRateLimitTPMGroup = map[string]int{}
+
+// ModelRequestRateLimitTPMModel 用户在单个模型上每分钟最多使用的 token 数，与分组 TPM 同时生效
+var ModelRequestRateLimitTPMModel = map[string]int{}
+
 func ModelRequestRateLimitGroup2JSONString() string {
 	ModelRequestRateLimitMutex.RLock()
 	defer ModelRequestRateLimitMutex.RUnlock()
@@ -67,3 +76,77 @@ func CheckModelRequestRateLimitGroup(jsonStr string) error {
 
 	return nil
 }
+
+func ModelRequestRateLimitTPMGroup2JSONString() string {
+	return tpmLimit2JSONString(ModelRequestRateLimitTPMGroup)
+}
+
+func ModelRequestRateLimitTPMModel2JSONString() string {
+	return tpmLimit2JSONString(ModelRequestRateLimitTPMModel)
+}
+
+func tpmLimit2JSONString(limits map[string]int) string {
+	ModelRequestRateLimitMutex.RLock()
+	defer ModelRequestRateLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(limits)
+	if err != nil {
+		common.SysLog("error marshalling tpm limit: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelRequestRateLimitTPMGroupByJSONString(jsonStr string) error {
+	limits := make(map[string]int)
+	if err := json.Unmarshal([]byte(jsonStr), &limits); err != nil {
+		return err
+	}
+	ModelRequestRateLimitMutex.Lock()
+	defer ModelRequestRateLimitMutex.Unlock()
+	ModelRequestRateLimitTPMGroup = limits
+	return nil
+}
+
+func UpdateModelRequestRateLimitTPMModelByJSONString(jsonStr string) error {
+	limits := make(map[string]int)
+	if err := json.Unmarshal([]byte(jsonStr), &limits); err != nil {
+		return err
+	}
+	ModelRequestRateLimitMutex.Lock()
+	defer ModelRequestRateLimitMutex.Unlock()
+	ModelRequestRateLimitTPMModel = limits
+	return nil
+}
+
+// GetGroupTPMLimit 返回分组的用户 TPM，分组未单独配置时使用全局配置
+func GetGroupTPMLimit(group string) int {
+	ModelRequestRateLimitMutex.RLock()
+	defer ModelRequestRateLimitMutex.RUnlock()
+
+	if tpm, found := ModelRequestRateLimitTPMGroup[group]; found {
+		return tpm
+	}
+	return ModelRequestRateLimitTPM
+}
+
+// GetModelTPMLimit 返回模型的用户 TPM，未配置时返回 0
+func GetModelTPMLimit(modelName string) int {
+	ModelRequestRateLimitMutex.RLock()
+	defer ModelRequestRateLimitMutex.RUnlock()
+
+	return ModelRequestRateLimitTPMModel[modelName]
+}
+
+func CheckModelRequestRateLimitTPM(jsonStr string) error {
+	limits := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &limits)
+	if err != nil {
+		return err
+	}
+	for name, tpm := range limits {
+		if tpm < 0 || tpm > math.MaxInt32 {
+			return fmt.Errorf("%s has invalid tpm limit %d, must be between 0 and 2147483647", name, tpm)
+		}
+	}
+	return nil
+}
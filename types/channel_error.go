@@ -19,3 +19,11 @@ func NewChannelError(channelId int, channelType int, channelName string, isMulti
 		UsingKey:    usingKey,
 	}
 }
+
+// ChannelAttempt 一次请求中对某个渠道的尝试，StatusCode 为 0 表示该次尝试未失败
+type ChannelAttempt struct {
+	ChannelId   int    `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
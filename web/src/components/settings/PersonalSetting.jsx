@@ -86,6 +86,7 @@ const PersonalSetting = () => {
     gotifyPriority: 5,
     acceptUnsetModelRatioModel: false,
     recordIpLog: false,
+    conversationLogMode: '',
   });
 
   useEffect(() => {
@@ -159,6 +160,7 @@ const PersonalSetting = () => {
         acceptUnsetModelRatioModel:
           settings.accept_unset_model_ratio_model || false,
         recordIpLog: settings.record_ip_log || false,
+        conversationLogMode: settings.conversation_log_mode || '',
       });
     }
   }, [userState?.user?.setting]);
@@ -427,6 +429,7 @@ const PersonalSetting = () => {
         accept_unset_model_ratio_model:
           notificationSettings.acceptUnsetModelRatioModel,
         record_ip_log: notificationSettings.recordIpLog,
+        conversation_log_mode: notificationSettings.conversationLogMode || '',
       });
 
       if (res.data.success) {
@@ -32,6 +32,9 @@ const RateLimitSetting = () => {
     ModelRequestRateLimitSuccessCount: 1000,
     ModelRequestRateLimitDurationMinutes: 1,
     ModelRequestRateLimitGroup: '',
+    ModelRequestRateLimitTPM: 0,
+    ModelRequestRateLimitTPMGroup: '',
+    ModelRequestRateLimitTPMModel: '',
   });
 
   let [loading, setLoading] = useState(false);
@@ -42,7 +45,11 @@ const RateLimitSetting = () => {
     if (success) {
       let newInputs = {};
       data.forEach((item) => {
-        if (item.key === 'ModelRequestRateLimitGroup') {
+        if (
+          item.key === 'ModelRequestRateLimitGroup' ||
+          item.key === 'ModelRequestRateLimitTPMGroup' ||
+          item.key === 'ModelRequestRateLimitTPMModel'
+        ) {
           item.value = JSON.stringify(JSON.parse(item.value), null, 2);
         }
 
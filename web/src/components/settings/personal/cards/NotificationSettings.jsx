@@ -748,6 +748,22 @@ const NotificationSettings = ({
                     '开启后，仅"消费"和"错误"日志将记录您的客户端IP地址',
                   )}
                 />
+                <Form.Select
+                  field='conversationLogMode'
+                  label={t('对话记录')}
+                  optionList={[
+                    { label: t('跟随系统设置'), value: '' },
+                    { label: t('始终记录'), value: 'always' },
+                    { label: t('从不记录'), value: 'never' },
+                  ]}
+                  onChange={(value) =>
+                    handleFormChange('conversationLogMode', value)
+                  }
+                  extraText={t(
+                    '是否记录您的请求和回复内容，令牌上的设置优先于此处',
+                  )}
+                  style={{ width: '100%' }}
+                />
               </div>
             </TabPane>
 
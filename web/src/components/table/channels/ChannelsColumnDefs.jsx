@@ -151,6 +151,45 @@ const renderMultiKeyStatus = (status, keySize, enabledKeySize, t) => {
   }
 };
 
+// 渠道配置了并发、RPM 或 TPM 上限时显示当前用量
+const renderUtilization = (utilization, t) => {
+  if (!utilization) {
+    return null;
+  }
+  const items = [];
+  if (utilization.max_concurrency > 0) {
+    items.push(
+      `${t('并发')} ${utilization.concurrency}/${utilization.max_concurrency}`,
+    );
+  }
+  if (utilization.rpm > 0) {
+    items.push(`RPM ${utilization.requests}/${utilization.rpm}`);
+  }
+  if (utilization.tpm > 0) {
+    items.push(`TPM ${utilization.tokens}/${utilization.tpm}`);
+  }
+  const ratio = Math.max(
+    utilization.max_concurrency > 0
+      ? utilization.concurrency / utilization.max_concurrency
+      : 0,
+    utilization.rpm > 0 ? utilization.requests / utilization.rpm : 0,
+    utilization.tpm > 0 ? utilization.tokens / utilization.tpm : 0,
+  );
+  let color = 'green';
+  if (utilization.saturated) {
+    color = 'red';
+  } else if (ratio >= 0.8) {
+    color = 'orange';
+  }
+  return (
+    <Tooltip content={items.join(' · ')}>
+      <Tag color={color} shape='circle'>
+        {utilization.saturated ? t('已满载') : `${Math.round(ratio * 100)}%`}
+      </Tag>
+    </Tooltip>
+  );
+};
+
 const renderResponseTime = (responseTime, t) => {
   let time = responseTime / 1000;
   time = time.toFixed(2) + t(' 秒');
@@ -323,7 +362,12 @@ export const getChannelsColumns = ({
       key: COLUMN_KEYS.RESPONSE_TIME,
       title: t('响应时间'),
       dataIndex: 'response_time',
-      render: (text, record, index) => <div>{renderResponseTime(text, t)}</div>,
+      render: (text, record, index) => (
+        <Space spacing={1}>
+          {renderResponseTime(text, t)}
+          {renderUtilization(record.utilization, t)}
+        </Space>
+      ),
     },
     {
       key: COLUMN_KEYS.BALANCE,
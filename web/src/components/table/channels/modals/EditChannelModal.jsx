@@ -163,6 +163,12 @@ const EditChannelModal = (props) => {
     allow_service_tier: false,
     disable_store: false, // false = 允许透传（默认开启）
     allow_safety_identifier: false,
+    // 渠道级别并发、RPM、TPM 上限（存入 settings），0 表示不限制
+    max_concurrency: 0,
+    rpm: 0,
+    tpm: 0,
+    // 上游采购价（存入 settings.upstream_prices），JSON 文本
+    upstream_prices: '',
   };
   const [batch, setBatch] = useState(false);
   const [multiToSingle, setMultiToSingle] = useState(false);
@@ -477,6 +483,8 @@ const EditChannelModal = (props) => {
         const modeVal = chInfo.multi_key_mode || 'random';
         setMultiKeyMode(modeVal);
         data.multi_key_mode = modeVal;
+        data.multi_key_rpm = chInfo.multi_key_rpm || 0;
+        data.multi_key_tpm = chInfo.multi_key_tpm || 0;
       } else {
         setBatch(false);
         setMultiToSingle(false);
@@ -529,6 +537,13 @@ const EditChannelModal = (props) => {
           data.disable_store = parsedSettings.disable_store || false;
           data.allow_safety_identifier =
             parsedSettings.allow_safety_identifier || false;
+          // 读取渠道用量上限
+          data.max_concurrency = parsedSettings.max_concurrency || 0;
+          data.rpm = parsedSettings.rpm || 0;
+          data.tpm = parsedSettings.tpm || 0;
+          data.upstream_prices = parsedSettings.upstream_prices
+            ? JSON.stringify(parsedSettings.upstream_prices, null, 2)
+            : '';
         } catch (error) {
           console.error('解析其他设置失败:', error);
           data.azure_responses_version = '';
@@ -539,6 +554,10 @@ const EditChannelModal = (props) => {
           data.allow_service_tier = false;
           data.disable_store = false;
           data.allow_safety_identifier = false;
+          data.max_concurrency = 0;
+          data.rpm = 0;
+          data.tpm = 0;
+          data.upstream_prices = '';
         }
       } else {
         // 兼容历史数据：老渠道没有 settings 时，默认按 json 展示
@@ -548,6 +567,10 @@ const EditChannelModal = (props) => {
         data.allow_service_tier = false;
         data.disable_store = false;
         data.allow_safety_identifier = false;
+        data.max_concurrency = 0;
+        data.rpm = 0;
+        data.tpm = 0;
+        data.upstream_prices = '';
       }
 
       if (
@@ -1021,6 +1044,28 @@ const EditChannelModal = (props) => {
       }
     }
 
+    // 渠道用量上限，0 表示不限制
+    for (const field of ['max_concurrency', 'rpm', 'tpm']) {
+      const value = parseInt(localInputs[field], 10);
+      if (value > 0) {
+        settings[field] = value;
+      } else {
+        delete settings[field];
+      }
+    }
+
+    // 上游采购价，留空表示未配置
+    if (localInputs.upstream_prices && localInputs.upstream_prices.trim()) {
+      try {
+        settings.upstream_prices = JSON.parse(localInputs.upstream_prices);
+      } catch (error) {
+        showInfo(t('上游采购价必须是合法的 JSON 格式！'));
+        return;
+      }
+    } else {
+      delete settings.upstream_prices;
+    }
+
     localInputs.settings = JSON.stringify(settings);
 
     // 清理不需要发送到后端的字段
@@ -1039,6 +1084,10 @@ const EditChannelModal = (props) => {
     delete localInputs.allow_service_tier;
     delete localInputs.disable_store;
     delete localInputs.allow_safety_identifier;
+    delete localInputs.max_concurrency;
+    delete localInputs.rpm;
+    delete localInputs.tpm;
+    delete localInputs.upstream_prices;
 
     let res;
     localInputs.auto_ban = localInputs.auto_ban ? 1 : 0;
@@ -1060,6 +1109,10 @@ const EditChannelModal = (props) => {
       res = await API.post(`/api/channel/`, {
         mode: mode,
         multi_key_mode: mode === 'multi_to_single' ? multiKeyMode : undefined,
+        multi_key_rpm:
+          mode === 'multi_to_single' ? localInputs.multi_key_rpm : undefined,
+        multi_key_tpm:
+          mode === 'multi_to_single' ? localInputs.multi_key_tpm : undefined,
         channel: localInputs,
       });
     }
@@ -1854,6 +1907,7 @@ const EditChannelModal = (props) => {
                           optionList={[
                             { label: t('随机'), value: 'random' },
                             { label: t('轮询'), value: 'polling' },
+                            { label: t('余量优先'), value: 'headroom' },
                           ]}
                           style={{ width: '100%' }}
                           value={inputs.multi_key_mode || 'random'}
@@ -1871,6 +1925,43 @@ const EditChannelModal = (props) => {
                             className='!rounded-lg mt-2'
                           />
                         )}
+                        {inputs.multi_key_mode === 'headroom' && (
+                          <>
+                            <Banner
+                              type='info'
+                              description={t(
+                                '余量模式根据每个密钥的 RPM/TPM 预算和上游返回的限流响应头选择剩余余量最多的密钥，被限流（429）的密钥会按 Retry-After 单独冷却，而不是被禁用',
+                              )}
+                              className='!rounded-lg mt-2'
+                            />
+                            <Row gutter={12}>
+                              <Col span={12}>
+                                <Form.InputNumber
+                                  field='multi_key_rpm'
+                                  label={t('单密钥 RPM 预算')}
+                                  placeholder={t('0 表示不限制')}
+                                  min={0}
+                                  style={{ width: '100%' }}
+                                  onChange={(value) =>
+                                    handleInputChange('multi_key_rpm', value)
+                                  }
+                                />
+                              </Col>
+                              <Col span={12}>
+                                <Form.InputNumber
+                                  field='multi_key_tpm'
+                                  label={t('单密钥 TPM 预算')}
+                                  placeholder={t('0 表示不限制')}
+                                  min={0}
+                                  style={{ width: '100%' }}
+                                  onChange={(value) =>
+                                    handleInputChange('multi_key_tpm', value)
+                                  }
+                                />
+                              </Col>
+                            </Row>
+                          </>
+                        )}
                       </>
                     )}
 
@@ -2466,6 +2557,69 @@ const EditChannelModal = (props) => {
                       </Col>
                     </Row>
 
+                    <Row gutter={12}>
+                      <Col span={8}>
+                        <Form.InputNumber
+                          field='max_concurrency'
+                          label={t('最大并发')}
+                          placeholder={t('0 表示不限制')}
+                          min={0}
+                          onNumberChange={(value) =>
+                            handleInputChange('max_concurrency', value)
+                          }
+                          style={{ width: '100%' }}
+                        />
+                      </Col>
+                      <Col span={8}>
+                        <Form.InputNumber
+                          field='rpm'
+                          label={t('每分钟请求数上限')}
+                          placeholder={t('0 表示不限制')}
+                          min={0}
+                          onNumberChange={(value) =>
+                            handleInputChange('rpm', value)
+                          }
+                          style={{ width: '100%' }}
+                        />
+                      </Col>
+                      <Col span={8}>
+                        <Form.InputNumber
+                          field='tpm'
+                          label={t('每分钟 Token 数上限')}
+                          placeholder={t('0 表示不限制')}
+                          min={0}
+                          onNumberChange={(value) =>
+                            handleInputChange('tpm', value)
+                          }
+                          style={{ width: '100%' }}
+                        />
+                      </Col>
+                    </Row>
+                    <Text type='tertiary' size='small'>
+                      {t(
+                        '任一用量达到上限时选择渠道会跳过该渠道，Token 数按预估输入计算',
+                      )}
+                    </Text>
+
+                    <Form.TextArea
+                      field='upstream_prices'
+                      label={t('上游采购价')}
+                      placeholder={
+                        t(
+                          '此项可选，用于成本路由和毛利统计，单位为美元。key 为模型名，"*" 为默认价格',
+                        ) +
+                        '\n{\n  "*": {\n    "input": 2.5,\n    "output": 10\n  },\n  "dall-e-3": {\n    "request": 0.04\n  }\n}'
+                      }
+                      autosize
+                      onChange={(value) =>
+                        handleInputChange('upstream_prices', value)
+                      }
+                      extraText={t(
+                        'input / output 为每百万 Token 价格，request 为每次请求价格',
+                      )}
+                      showClear
+                    />
+
                     <Form.Switch
                       field='auto_ban'
                       label={t('是否自动禁用')}
@@ -406,6 +406,38 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
         );
       },
     },
+    ...(channel?.channel_info?.multi_key_mode === 'headroom'
+      ? [
+          {
+            title: t('近一分钟用量'),
+            dataIndex: 'requests',
+            render: (requests, record) =>
+              record.headroom === undefined ? (
+                <Text type='quaternary'>-</Text>
+              ) : (
+                <Tooltip
+                  content={`${t('剩余余量')}: ${Math.round(record.headroom * 100)}%`}
+                >
+                  <Text style={{ fontSize: '12px' }}>
+                    {requests || 0} RPM / {record.tokens || 0} TPM
+                  </Text>
+                </Tooltip>
+              ),
+          },
+          {
+            title: t('冷却至'),
+            dataIndex: 'cooldown_until',
+            render: (time) =>
+              time ? (
+                <Tag color='orange' size='small' shape='circle'>
+                  {timestamp2string(time)}
+                </Tag>
+              ) : (
+                <Text type='quaternary'>-</Text>
+              ),
+          },
+        ]
+      : []),
     {
       title: t('操作'),
       key: 'action',
@@ -469,7 +501,9 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
             <Tag size='small' shape='circle' color='white'>
               {channel.channel_info.multi_key_mode === 'random'
                 ? t('随机模式')
-                : t('轮询模式')}
+                : channel.channel_info.multi_key_mode === 'headroom'
+                  ? t('余量模式')
+                  : t('轮询模式')}
             </Tag>
           )}
         </Space>
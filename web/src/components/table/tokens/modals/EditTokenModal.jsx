@@ -72,6 +72,13 @@ const EditTokenModal = (props) => {
     model_limits_enabled: false,
     model_limits: [],
     allow_ips: '',
+    deny_ips: '',
+    budgets: '',
+    rpm: 0,
+    tpm: 0,
+    max_concurrency: 0,
+    scopes: '',
+    conversation_log_mode: '',
     group: '',
     tokenCount: 1,
   });
@@ -379,6 +386,18 @@ const EditTokenModal = (props) => {
                       />
                     )}
                   </Col>
+                  <Col span={24}>
+                    <Form.Select
+                      field='conversation_log_mode'
+                      label={t('对话记录')}
+                      optionList={[
+                        { label: t('跟随用户设置'), value: '' },
+                        { label: t('始终记录'), value: 'always' },
+                        { label: t('从不记录'), value: 'never' },
+                      ]}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
                   <Col xs={24} sm={24} md={24} lg={10} xl={10}>
                     <Form.DatePicker
                       field='expired_time'
@@ -536,8 +555,11 @@ const EditTokenModal = (props) => {
                         '请选择该令牌支持的模型，留空支持所有模型',
                       )}
                       multiple
+                      allowCreate
                       optionList={models}
-                      extraText={t('非必要，不建议启用模型限制')}
+                      extraText={t(
+                        '非必要，不建议启用模型限制；支持输入 * 通配符，例如 gpt-4o*',
+                      )}
                       filter={selectFilter}
                       autoClearSearchValue={false}
                       searchPosition='dropdown'
@@ -549,7 +571,9 @@ const EditTokenModal = (props) => {
                     <Form.TextArea
                       field='allow_ips'
                       label={t('IP白名单')}
-                      placeholder={t('允许的IP，一行一个，不填写则不限制')}
+                      placeholder={t(
+                        '允许的IP或CIDR，一行一个，支持IPv6，例如 10.0.0.0/8，不填写则不限制',
+                      )}
                       autosize
                       rows={1}
                       extraText={t('请勿过度信任此功能，IP可能被伪造')}
@@ -557,6 +581,78 @@ const EditTokenModal = (props) => {
                       style={{ width: '100%' }}
                     />
                   </Col>
+                  <Col span={24}>
+                    <Form.TextArea
+                      field='deny_ips'
+                      label={t('IP黑名单')}
+                      placeholder={t(
+                        '禁止的IP或CIDR，一行一个，支持IPv6，优先于白名单，不填写则不限制',
+                      )}
+                      autosize
+                      rows={1}
+                      showClear
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={8}>
+                    <Form.InputNumber
+                      field='rpm'
+                      label={t('每分钟请求数')}
+                      min={0}
+                      extraText={t('0 为不限制')}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={8}>
+                    <Form.InputNumber
+                      field='tpm'
+                      label={t('每分钟 Token 数')}
+                      min={0}
+                      extraText={t('0 为不限制')}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={8}>
+                    <Form.InputNumber
+                      field='max_concurrency'
+                      label={t('最大并发数')}
+                      min={0}
+                      extraText={t('0 为不限制')}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={24}>
+                    <Form.TextArea
+                      field='scopes'
+                      label={t('权限范围')}
+                      placeholder={t(
+                        '例如：{"endpoints":["chat"],"max_tokens":4096,"max_n":1,"forbidden_tools":["web_search*"],"max_request_quota":50000}',
+                      )}
+                      autosize
+                      rows={1}
+                      extraText={t(
+                        'endpoints 可选 chat、responses、embeddings、images、audio、realtime、rerank、moderations、video、midjourney、suno；另支持 forbidden_params 禁止指定参数，不填写则不限制',
+                      )}
+                      showClear
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={24}>
+                    <Form.TextArea
+                      field='budgets'
+                      label={t('周期预算')}
+                      placeholder={t(
+                        '例如：[{"period":"day","quota":500000},{"period":"month","quota":10000000,"rolling":true}]',
+                      )}
+                      autosize
+                      rows={1}
+                      extraText={t(
+                        '按日、周、月限制令牌的消费额度，period 可选 day、week、month，rolling 为 true 时按滚动窗口统计，不填写则不限制',
+                      )}
+                      showClear
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
                 </Row>
               </Card>
             </div>
@@ -513,6 +513,17 @@ export const getLogsColumns = ({
               let useChannelStr = useChannel.join('->');
               content = t('渠道') + `：${useChannelStr}`;
             }
+            // retry_path 带有每次失败的状态码，如 12(429)->15
+            if (Array.isArray(other.admin_info.retry_path)) {
+              let retryPathStr = other.admin_info.retry_path
+                .map((attempt) =>
+                  attempt.status_code
+                    ? `${attempt.channel_id}(${attempt.status_code})`
+                    : `${attempt.channel_id}`,
+                )
+                .join('->');
+              content = t('渠道') + `：${retryPathStr}`;
+            }
           }
         }
         return isAdminUser ? <div>{content}</div> : <></>;
@@ -78,6 +78,10 @@ const EditUserModal = (props) => {
     quota: 0,
     group: 'default',
     remark: '',
+    budgets: '',
+    conversation_log_mode: '',
+    allow_ips: '',
+    deny_ips: '',
   });
 
   const fetchGroups = async () => {
@@ -98,6 +102,14 @@ const EditUserModal = (props) => {
     const { success, message, data } = res.data;
     if (success) {
       data.password = '';
+      // 对话记录偏好保存在用户设置中
+      let setting = {};
+      try {
+        setting = data.setting ? JSON.parse(data.setting) : {};
+      } catch (e) {
+        setting = {};
+      }
+      data.conversation_log_mode = setting.conversation_log_mode || '';
       formApiRef.current?.setValues({ ...getInitValues(), ...data });
     } else {
       showError(message);
@@ -305,6 +317,62 @@ const EditUserModal = (props) => {
                           />
                         </Form.Slot>
                       </Col>
+
+                      <Col span={24}>
+                        <Form.TextArea
+                          field='budgets'
+                          label={t('周期预算')}
+                          placeholder={t(
+                            '例如：[{"period":"day","quota":500000},{"period":"month","quota":10000000,"rolling":true}]',
+                          )}
+                          autosize
+                          rows={1}
+                          extraText={t(
+                            '按日、周、月限制用户的消费额度，period 可选 day、week、month，rolling 为 true 时按滚动窗口统计，不填写则不限制',
+                          )}
+                          showClear
+                        />
+                      </Col>
+
+                      <Col span={24}>
+                        <Form.Select
+                          field='conversation_log_mode'
+                          label={t('对话记录')}
+                          optionList={[
+                            { label: t('跟随系统设置'), value: '' },
+                            { label: t('始终记录'), value: 'always' },
+                            { label: t('从不记录'), value: 'never' },
+                          ]}
+                          style={{ width: '100%' }}
+                        />
+                      </Col>
+
+                      <Col span={24}>
+                        <Form.TextArea
+                          field='allow_ips'
+                          label={t('IP白名单')}
+                          placeholder={t(
+                            '允许的IP或CIDR，一行一个，支持IPv6，例如 10.0.0.0/8，不填写则不限制',
+                          )}
+                          autosize
+                          rows={1}
+                          extraText={t('对该用户的所有令牌生效')}
+                          showClear
+                        />
+                      </Col>
+
+                      <Col span={24}>
+                        <Form.TextArea
+                          field='deny_ips'
+                          label={t('IP黑名单')}
+                          placeholder={t(
+                            '禁止的IP或CIDR，一行一个，支持IPv6，优先于白名单，不填写则不限制',
+                          )}
+                          autosize
+                          rows={1}
+                          showClear
+                        />
+                      </Col>
                     </Row>
                   </Card>
                 )}
@@ -399,6 +399,12 @@ export const useLogsData = () => {
             value: other.upstream_model_name,
           });
         }
+        if (other?.fallback_from) {
+          expandDataLocal.push({
+            key: t('原请求模型'),
+            value: `${other.fallback_from} → ${logs[i].model_name}（${t('备用模型')}）`,
+          });
+        }
         let content = '';
         if (other?.ws || other?.audio) {
           content = renderAudioModelPrice(
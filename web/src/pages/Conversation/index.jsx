@@ -2,9 +2,21 @@
 // 对话记录管理页面
 
 import React, { useEffect, useState } from 'react';
-import { Button, Table, Form, Modal, DatePicker, Input, Space, Tag, Popconfirm } from '@douyinfe/semi-ui';
+import { Button, Table, Form, Modal, DatePicker, Input, Space, Tag, Popconfirm, RadioGroup, Radio } from '@douyinfe/semi-ui';
 import { API, showError, showSuccess, showInfo, timestamp2string } from '../../helpers';
 
+// Claude / Gemini / Responses 格式的响应以 JSON 存储，格式化后展示
+const formatResponseContent = (content) => {
+  if (!content) {
+    return '';
+  }
+  try {
+    return JSON.stringify(JSON.parse(content), null, 2);
+  } catch (e) {
+    return content;
+  }
+};
+
 const ConversationManagement = () => {
   const [conversations, setConversations] = useState([]);
   const [loading, setLoading] = useState(false);
@@ -16,9 +28,15 @@ const ConversationManagement = () => {
   const [currentDetail, setCurrentDetail] = useState(null);
   const [settingVisible, setSettingVisible] = useState(false);
   const [conversationLogEnabled, setConversationLogEnabled] = useState(false);
+  // records 按单条记录展示，sessions 按会话聚合展示
+  const [viewMode, setViewMode] = useState('records');
+  const [sessions, setSessions] = useState([]);
+  const [threadVisible, setThreadVisible] = useState(false);
+  const [currentThread, setCurrentThread] = useState(null);
 
   // 筛选条件
   const [filters, setFilters] = useState({
+    keyword: '',
     username: '',
     model_name: '',
     start_time: 0,
@@ -28,18 +46,68 @@ const ConversationManagement = () => {
   useEffect(() => {
     loadConversations();
     loadSetting();
-  }, [page, pageSize]);
+  }, [page, pageSize, viewMode]);
+
+  // 加载会话列表，关键词检索只作用于单条记录
+  const loadSessions = async () => {
+    setLoading(true);
+    try {
+      const { keyword, ...rest } = filters;
+      const res = await API.get('/api/conversation/sessions', {
+        params: { page, page_size: pageSize, ...rest },
+      });
+      if (res.data.success) {
+        setSessions(res.data.data.data || []);
+        setTotal(res.data.data.total || 0);
+      } else {
+        showError('加载失败：' + res.data.message);
+      }
+    } catch (error) {
+      showError('加载失败：' + error.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  // 查看会话，每一轮只展示新增的消息
+  const viewThread = async (record) => {
+    try {
+      const res = await API.get(`/api/conversation/sessions/${encodeURIComponent(record.session_id)}`, {
+        params: { user_id: record.user_id },
+      });
+      if (res.data.success) {
+        setCurrentThread(res.data.data);
+        setThreadVisible(true);
+      } else {
+        showError('加载失败：' + res.data.message);
+      }
+    } catch (error) {
+      showError('加载失败：' + error.message);
+    }
+  };
 
   // 加载对话记录列表
   const loadConversations = async () => {
+    if (viewMode === 'sessions') {
+      return loadSessions();
+    }
     setLoading(true);
     try {
+      const { keyword, ...rest } = filters;
       const params = {
         page,
         page_size: pageSize,
-        ...filters,
+        ...rest,
       };
-      const res = await API.get('/api/conversation/', { params });
+      // 有关键词时走全文检索，同时检索归档表和压缩表
+      let res;
+      if (keyword) {
+        res = await API.get('/api/conversation/search', {
+          params: { ...params, keyword, include_archive: true },
+        });
+      } else {
+        res = await API.get('/api/conversation/', { params });
+      }
       if (res.data.success) {
         setConversations(res.data.data.data || []);
         setTotal(res.data.data.total || 0);
@@ -81,10 +149,18 @@ const ConversationManagement = () => {
     }
   };
 
-  // 查看详情
-  const viewDetail = async (id) => {
+  // 查看详情，检索结果中的归档记录已包含完整内容，直接展示
+  // 主表和压缩表的记录可能只存储了会话中本轮新增的消息，由服务端还原后展示
+  const viewDetail = async (id, record) => {
+    if (record && record.source === 'archive') {
+      setCurrentDetail(record);
+      setDetailVisible(true);
+      return;
+    }
     try {
-      const res = await API.get(`/api/conversation/${id}`);
+      const res = await API.get(`/api/conversation/${id}`, {
+        params: { source: record && record.source },
+      });
       if (res.data.success) {
         setCurrentDetail(res.data.data);
         setDetailVisible(true);
@@ -103,17 +179,28 @@ const ConversationManagement = () => {
       return;
     }
 
+    // 主表和压缩表的 id 相互独立，按来源分组删除
+    const groups = {};
+    selectedKeys.forEach((key) => {
+      const [source, id] = key.split(':');
+      (groups[source] = groups[source] || []).push(parseInt(id));
+    });
     try {
-      const res = await API.delete('/api/conversation/', {
-        data: { ids: selectedKeys },
-      });
-      if (res.data.success) {
-        showSuccess(`成功删除 ${res.data.data.deleted} 条记录`);
-        setSelectedKeys([]);
-        loadConversations();
-      } else {
-        showError('删除失败：' + res.data.message);
+      let deleted = 0;
+      for (const [source, ids] of Object.entries(groups)) {
+        const res = await API.delete('/api/conversation/', {
+          data: { ids, source },
+        });
+        if (!res.data.success) {
+          showError('删除失败：' + res.data.message);
+          loadConversations();
+          return;
+        }
+        deleted += res.data.data.deleted;
       }
+      showSuccess(`成功删除 ${deleted} 条记录`);
+      setSelectedKeys([]);
+      loadConversations();
     } catch (error) {
       showError('删除失败：' + error.message);
     }
@@ -148,6 +235,7 @@ const ConversationManagement = () => {
   // 重置筛选
   const handleReset = () => {
     setFilters({
+      keyword: '',
       username: '',
       model_name: '',
       start_time: 0,
@@ -211,37 +299,103 @@ const ConversationManagement = () => {
       width: 180,
       render: (text) => timestamp2string(text),
     },
+    {
+      title: '命中片段',
+      dataIndex: 'highlights',
+      width: 360,
+      render: (highlights) =>
+        (highlights || []).map((h, i) => (
+          // 片段已在服务端转义，仅保留 <mark> 高亮标签
+          <div key={i} style={{ fontSize: 12, wordBreak: 'break-all' }} dangerouslySetInnerHTML={{ __html: h.snippet }} />
+        )),
+    },
     {
       title: '操作',
       fixed: 'right',
       width: 150,
       render: (_, record) => (
         <Space>
-          <Button size="small" onClick={() => viewDetail(record.id)}>
+          <Button size="small" onClick={() => viewDetail(record.id, record)}>
             查看详情
           </Button>
-          <Popconfirm
-            title="确定删除吗？"
-            onConfirm={() => {
-              API.delete('/api/conversation/', { data: { ids: [record.id] } }).then((res) => {
-                if (res.data.success) {
-                  showSuccess('删除成功');
-                  loadConversations();
-                } else {
-                  showError('删除失败：' + res.data.message);
-                }
-              });
-            }}
-          >
-            <Button size="small" type="danger">
-              删除
-            </Button>
-          </Popconfirm>
+          {/* 归档记录不提供删除 */}
+          {record.source !== 'archive' && (
+            <Popconfirm
+              title="确定删除吗？"
+              onConfirm={() => {
+                API.delete('/api/conversation/', {
+                  data: { ids: [record.id], source: record.source },
+                }).then((res) => {
+                  if (res.data.success) {
+                    showSuccess('删除成功');
+                    loadConversations();
+                  } else {
+                    showError('删除失败：' + res.data.message);
+                  }
+                });
+              }}
+            >
+              <Button size="small" type="danger">
+                删除
+              </Button>
+            </Popconfirm>
+          )}
         </Space>
       ),
     },
   ];
 
+  const sessionColumns = [
+    {
+      title: '会话ID',
+      dataIndex: 'session_id',
+      width: 280,
+    },
+    {
+      title: '用户名',
+      dataIndex: 'username',
+      width: 120,
+    },
+    {
+      title: '模型',
+      dataIndex: 'model_name',
+      width: 150,
+      render: (text) => <Tag>{text}</Tag>,
+    },
+    {
+      title: '轮数',
+      dataIndex: 'turns',
+      width: 80,
+    },
+    {
+      title: '总Token',
+      dataIndex: 'total_tokens',
+      width: 100,
+    },
+    {
+      title: '开始时间',
+      dataIndex: 'first_at',
+      width: 180,
+      render: (text) => timestamp2string(text),
+    },
+    {
+      title: '最近一轮',
+      dataIndex: 'last_at',
+      width: 180,
+      render: (text) => timestamp2string(text),
+    },
+    {
+      title: '操作',
+      fixed: 'right',
+      width: 100,
+      render: (_, record) => (
+        <Button size="small" onClick={() => viewThread(record)}>
+          查看会话
+        </Button>
+      ),
+    },
+  ];
+
   return (
     <div className="mt-[60px] px-2" style={{ paddingTop: '20px', paddingBottom: '24px' }}>
       <h2 style={{ marginTop: 0 }}>对话记录管理</h2>
@@ -262,6 +416,13 @@ const ConversationManagement = () => {
       {/* 筛选表单 */}
       <Form layout="horizontal" style={{ marginBottom: 16 }}>
         <Space>
+          <Input
+            placeholder="搜索对话内容"
+            value={filters.keyword}
+            onChange={(value) => setFilters({ ...filters, keyword: value })}
+            onEnterPress={handleSearch}
+            style={{ width: 200 }}
+          />
           <Input
             placeholder="用户名"
             value={filters.username}
@@ -300,6 +461,17 @@ const ConversationManagement = () => {
       {/* 批量操作 */}
       <div style={{ marginBottom: 16 }}>
         <Space>
+          <RadioGroup
+            type="button"
+            value={viewMode}
+            onChange={(e) => {
+              setViewMode(e.target.value);
+              setPage(1);
+            }}
+          >
+            <Radio value="records">按记录</Radio>
+            <Radio value="sessions">按会话</Radio>
+          </RadioGroup>
           <Popconfirm
             title={`确定删除选中的 ${selectedKeys.length} 条记录吗？`}
             disabled={selectedKeys.length === 0}
@@ -319,28 +491,93 @@ const ConversationManagement = () => {
       </div>
 
       {/* 表格 */}
-      <Table
-        columns={columns}
-        dataSource={conversations}
-        loading={loading}
-        rowKey="id"
-        rowSelection={{
-          selectedRowKeys: selectedKeys,
-          onChange: setSelectedKeys,
-        }}
-        pagination={{
-          currentPage: page,
-          pageSize: pageSize,
-          total: total,
-          onPageChange: setPage,
-          showSizeChanger: true,
-          onPageSizeChange: (size) => {
-            setPageSize(size);
-            setPage(1);
-          },
-        }}
-        scroll={{ x: 1400 }}
-      />
+      {viewMode === 'sessions' ? (
+        <Table
+          columns={sessionColumns}
+          dataSource={sessions}
+          loading={loading}
+          rowKey={(record) => `${record.user_id}:${record.session_id}`}
+          pagination={{
+            currentPage: page,
+            pageSize: pageSize,
+            total: total,
+            onPageChange: setPage,
+            showSizeChanger: true,
+            onPageSizeChange: (size) => {
+              setPageSize(size);
+              setPage(1);
+            },
+          }}
+        />
+      ) : (
+        <Table
+          columns={columns}
+          dataSource={conversations}
+          loading={loading}
+          rowKey={(record) => `${record.source || 'conversations'}:${record.id}`}
+          rowSelection={{
+            selectedRowKeys: selectedKeys,
+            onChange: setSelectedKeys,
+            getCheckboxProps: (record) => ({
+              disabled: record.source === 'archive',
+            }),
+          }}
+          pagination={{
+            currentPage: page,
+            pageSize: pageSize,
+            total: total,
+            onPageChange: setPage,
+            showSizeChanger: true,
+            onPageSizeChange: (size) => {
+              setPageSize(size);
+              setPage(1);
+            },
+          }}
+          scroll={{ x: 1400 }}
+        />
+      )}
+
+      {/* 会话对话框 */}
+      <Modal
+        title="会话详情"
+        visible={threadVisible}
+        onCancel={() => setThreadVisible(false)}
+        footer={null}
+        width={800}
+        bodyStyle={{ maxHeight: '70vh', overflow: 'auto' }}
+      >
+        {currentThread && (
+          <div>
+            <p>
+              <strong>用户:</strong> {currentThread.username} (ID: {currentThread.user_id})
+            </p>
+            <p>
+              <strong>会话ID:</strong> {currentThread.session_id}
+            </p>
+            {(currentThread.turns || []).map((turn) => (
+              <div key={`${turn.source}:${turn.id}`} style={{ marginTop: 20 }}>
+                <h4>
+                  第 {turn.turn_index + 1} 轮 <Tag>{turn.model_name}</Tag>{' '}
+                  <span style={{ fontWeight: 'normal', fontSize: 12 }}>
+                    {timestamp2string(turn.created_at)} · Token {turn.total_tokens}
+                  </span>
+                  {turn.partial && (
+                    <Tag color="orange" style={{ marginLeft: 4 }}>
+                      上一轮已删除，消息可能不完整
+                    </Tag>
+                  )}
+                </h4>
+                <pre style={{ background: '#f6f7f9', padding: 12, borderRadius: 4, maxHeight: 300, overflow: 'auto' }}>
+                  {JSON.stringify(turn.messages || [], null, 2)}
+                </pre>
+                <pre style={{ background: '#eef6ee', padding: 12, borderRadius: 4, maxHeight: 300, overflow: 'auto' }}>
+                  {formatResponseContent(turn.response_content)}
+                </pre>
+              </div>
+            ))}
+          </div>
+        )}
+      </Modal>
 
       {/* 详情对话框 */}
       <Modal
@@ -360,6 +597,19 @@ const ConversationManagement = () => {
             <p>
               <strong>模型:</strong> {currentDetail.model_name}
             </p>
+            <p>
+              <strong>请求格式:</strong> <Tag>{currentDetail.format || 'openai'}</Tag>
+            </p>
+            {currentDetail.redacted_rules && (
+              <p>
+                <strong>已脱敏:</strong>{' '}
+                {currentDetail.redacted_rules.split(',').map((rule) => (
+                  <Tag key={rule} color='orange' style={{ marginRight: 4 }}>
+                    {rule}
+                  </Tag>
+                ))}
+              </p>
+            )}
             <p>
               <strong>Token使用:</strong> 输入 {currentDetail.prompt_tokens} / 输出{' '}
               {currentDetail.completion_tokens} / 总计 {currentDetail.total_tokens}
@@ -394,7 +644,7 @@ const ConversationManagement = () => {
                 overflow: 'auto',
               }}
             >
-              {currentDetail.response_content}
+              {formatResponseContent(currentDetail.response_content)}
             </pre>
           </div>
         )}
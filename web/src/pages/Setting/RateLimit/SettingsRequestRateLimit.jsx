@@ -39,6 +39,9 @@ export default function RequestRateLimit(props) {
     ModelRequestRateLimitSuccessCount: 1000,
     ModelRequestRateLimitDurationMinutes: 1,
     ModelRequestRateLimitGroup: '',
+    ModelRequestRateLimitTPM: 0,
+    ModelRequestRateLimitTPMGroup: '',
+    ModelRequestRateLimitTPMModel: '',
   });
   const refForm = useRef();
   const [inputsRow, setInputsRow] = useState(inputs);
@@ -229,6 +232,79 @@ export default function RequestRateLimit(props) {
                 />
               </Col>
             </Row>
+            <Row>
+              <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                <Form.InputNumber
+                  label={t('用户每分钟最多使用 Token 数')}
+                  step={1000}
+                  min={0}
+                  max={2147483647}
+                  suffix={'Token'}
+                  extraText={t(
+                    '按请求预估的输入 Token 数预扣，请求结束后按实际用量补扣，0代表不限制',
+                  )}
+                  field={'ModelRequestRateLimitTPM'}
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      ModelRequestRateLimitTPM: String(value),
+                    })
+                  }
+                />
+              </Col>
+            </Row>
+            <Row gutter={16}>
+              <Col xs={24} sm={12}>
+                <Form.TextArea
+                  label={t('分组 TPM 限制')}
+                  placeholder={t('{\n  "default": 100000,\n  "vip": 0\n}')}
+                  field={'ModelRequestRateLimitTPMGroup'}
+                  autosize={{ minRows: 5, maxRows: 15 }}
+                  trigger='blur'
+                  stopValidateWithError
+                  rules={[
+                    {
+                      validator: (rule, value) => verifyJSON(value),
+                      message: t('不是合法的 JSON 字符串'),
+                    },
+                  ]}
+                  extraText={t(
+                    '格式为：{"组名": 每分钟最多 Token 数}，优先级高于上方的全局 TPM，0代表不限制',
+                  )}
+                  onChange={(value) => {
+                    setInputs({
+                      ...inputs,
+                      ModelRequestRateLimitTPMGroup: value,
+                    });
+                  }}
+                />
+              </Col>
+              <Col xs={24} sm={12}>
+                <Form.TextArea
+                  label={t('模型 TPM 限制')}
+                  placeholder={t('{\n  "gpt-4o": 200000\n}')}
+                  field={'ModelRequestRateLimitTPMModel'}
+                  autosize={{ minRows: 5, maxRows: 15 }}
+                  trigger='blur'
+                  stopValidateWithError
+                  rules={[
+                    {
+                      validator: (rule, value) => verifyJSON(value),
+                      message: t('不是合法的 JSON 字符串'),
+                    },
+                  ]}
+                  extraText={t(
+                    '格式为：{"模型名": 每分钟最多 Token 数}，限制每个用户在该模型上的用量，与分组 TPM 同时生效',
+                  )}
+                  onChange={(value) => {
+                    setInputs({
+                      ...inputs,
+                      ModelRequestRateLimitTPMModel: value,
+                    });
+                  }}
+                />
+              </Col>
+            </Row>
             <Row>
               <Button size='default' onClick={onSubmit}>
                 {t('保存模型速率限制')}